@@ -0,0 +1,287 @@
+package apu
+
+import (
+	"nes-emulator/region"
+	"nes-emulator/savestate"
+)
+
+// Audio Processing Unit of the 2A03, mapped on CPU addresses $4000-$4017
+// More info here : https://www.nesdev.org/wiki/APU
+
+const (
+	PULSE_1_CONTROL         uint16 = 0x4000
+	PULSE_1_SWEEP           uint16 = 0x4001
+	PULSE_1_TIMER_LOW       uint16 = 0x4002
+	PULSE_1_TIMER_HIGH      uint16 = 0x4003
+	PULSE_2_CONTROL         uint16 = 0x4004
+	PULSE_2_SWEEP           uint16 = 0x4005
+	PULSE_2_TIMER_LOW       uint16 = 0x4006
+	PULSE_2_TIMER_HIGH      uint16 = 0x4007
+	TRIANGLE_LINEAR_COUNTER uint16 = 0x4008
+	TRIANGLE_TIMER_LOW      uint16 = 0x400A
+	TRIANGLE_TIMER_HIGH     uint16 = 0x400B
+	NOISE_CONTROL           uint16 = 0x400C
+	NOISE_PERIOD            uint16 = 0x400E
+	NOISE_LENGTH            uint16 = 0x400F
+	DMC_CONTROL             uint16 = 0x4010
+	DMC_DIRECT_LOAD         uint16 = 0x4011
+	DMC_SAMPLE_ADDRESS      uint16 = 0x4012
+	DMC_SAMPLE_LENGTH       uint16 = 0x4013
+	STATUS                  uint16 = 0x4015
+	FRAME_COUNTER           uint16 = 0x4017
+)
+
+type APU struct {
+	pulse1       pulseChannel
+	pulse2       pulseChannel
+	triangle     triangleChannel
+	noise        noiseChannel
+	dmc          dmcChannel
+	frameCounter frameCounter
+	// CPU cycles elapsed since power-up
+	cycles uint64
+	region region.Region
+	// Audio samples are only produced once a sample rate is set
+	sampler *sampler
+}
+
+func NewAPU() APU {
+	return APU{
+		pulse1:       newPulseChannel(true),
+		pulse2:       newPulseChannel(false),
+		noise:        newNoiseChannel(),
+		dmc:          newDmcChannel(),
+		frameCounter: newFrameCounter(),
+		region:       region.NTSC,
+	}
+}
+
+// Every unit goes back to its power-up state, region and audio sampling configuration are kept
+func (apu *APU) PowerOn() {
+	var sampler = apu.sampler
+	var consoleRegion = apu.region
+	*apu = NewAPU()
+	apu.sampler = sampler
+	apu.setRegionTables(consoleRegion)
+	apu.dmc.timerPeriod = apu.dmc.periodTable[0]
+}
+
+// Frame counter and noise/DMC periods depend on the region, as well as the CPU clock used for sampling
+func (apu *APU) SetRegion(consoleRegion region.Region) {
+	apu.setRegionTables(consoleRegion)
+	if apu.sampler != nil {
+		apu.SetSampleRate(apu.sampler.sampleRate)
+	}
+}
+
+func (apu *APU) setRegionTables(consoleRegion region.Region) {
+	apu.region = consoleRegion
+	apu.noise.periodTable = noisePeriodTables[consoleRegion]
+	apu.dmc.periodTable = dmcPeriodTables[consoleRegion]
+	apu.frameCounter.steps = frameCounterStepsTables[consoleRegion]
+}
+
+// https://www.nesdev.org/wiki/CPU_power_up_state#After_reset
+func (apu *APU) Reset() {
+	apu.writeStatus(0x00)
+	// The frame counter behaves as if the last value written to $4017 was written again
+	apu.frameCounter.cycles = 0
+	apu.dmc.outputLevel &= 0b0000_0001
+}
+
+// Audio sampling is configured by the frontend and is not part of the state
+func (apu *APU) SerializeState(state *savestate.Serializer) {
+	apu.pulse1.serializeState(state)
+	apu.pulse2.serializeState(state)
+	apu.triangle.serializeState(state)
+	apu.noise.serializeState(state)
+	apu.dmc.serializeState(state)
+	apu.frameCounter.serializeState(state)
+	state.Uint64(&apu.cycles)
+}
+
+// Registers
+
+func (apu *APU) WriteRegister(address uint16, data uint8) {
+	switch address {
+	case PULSE_1_CONTROL:
+		apu.pulse1.writeControl(data)
+	case PULSE_1_SWEEP:
+		apu.pulse1.writeSweep(data)
+	case PULSE_1_TIMER_LOW:
+		apu.pulse1.writeTimerLow(data)
+	case PULSE_1_TIMER_HIGH:
+		apu.pulse1.writeTimerHigh(data)
+	case PULSE_2_CONTROL:
+		apu.pulse2.writeControl(data)
+	case PULSE_2_SWEEP:
+		apu.pulse2.writeSweep(data)
+	case PULSE_2_TIMER_LOW:
+		apu.pulse2.writeTimerLow(data)
+	case PULSE_2_TIMER_HIGH:
+		apu.pulse2.writeTimerHigh(data)
+	case TRIANGLE_LINEAR_COUNTER:
+		apu.triangle.writeLinearCounter(data)
+	case TRIANGLE_TIMER_LOW:
+		apu.triangle.writeTimerLow(data)
+	case TRIANGLE_TIMER_HIGH:
+		apu.triangle.writeTimerHigh(data)
+	case NOISE_CONTROL:
+		apu.noise.writeControl(data)
+	case NOISE_PERIOD:
+		apu.noise.writePeriod(data)
+	case NOISE_LENGTH:
+		apu.noise.writeLength(data)
+	case DMC_CONTROL:
+		apu.dmc.writeControl(data)
+	case DMC_DIRECT_LOAD:
+		apu.dmc.writeDirectLoad(data)
+	case DMC_SAMPLE_ADDRESS:
+		apu.dmc.writeSampleAddress(data)
+	case DMC_SAMPLE_LENGTH:
+		apu.dmc.writeSampleLength(data)
+	case STATUS:
+		apu.writeStatus(data)
+	case FRAME_COUNTER:
+		apu.clockFrameEvents(apu.frameCounter.write(data))
+	default:
+		// $4009 and $400D are unused
+	}
+}
+
+func (apu *APU) writeStatus(data uint8) {
+	// ---D NT21
+	apu.pulse1.lengthCounter.setEnabled(data&0b0000_0001 != 0)
+	apu.pulse2.lengthCounter.setEnabled(data&0b0000_0010 != 0)
+	apu.triangle.lengthCounter.setEnabled(data&0b0000_0100 != 0)
+	apu.noise.lengthCounter.setEnabled(data&0b0000_1000 != 0)
+	apu.dmc.setEnabled(data&0b0001_0000 != 0)
+	apu.dmc.isInterruptPending = false
+}
+
+// Reading the status acknowledges the frame interrupt
+func (apu *APU) ReadStatus() uint8 {
+	var status = apu.PeekStatus()
+	apu.frameCounter.isInterruptPending = false
+	return status
+}
+
+// Status as ReadStatus returns it, without acknowledging the frame interrupt
+func (apu *APU) PeekStatus() uint8 {
+	// IF-D NT21
+	var status uint8 = 0
+	if apu.pulse1.lengthCounter.value > 0 {
+		status |= 0b0000_0001
+	}
+	if apu.pulse2.lengthCounter.value > 0 {
+		status |= 0b0000_0010
+	}
+	if apu.triangle.lengthCounter.value > 0 {
+		status |= 0b0000_0100
+	}
+	if apu.noise.lengthCounter.value > 0 {
+		status |= 0b0000_1000
+	}
+	if apu.dmc.bytesRemaining > 0 {
+		status |= 0b0001_0000
+	}
+	if apu.frameCounter.isInterruptPending {
+		status |= 0b0100_0000
+	}
+	if apu.dmc.isInterruptPending {
+		status |= 0b1000_0000
+	}
+	return status
+}
+
+// Interrupts
+
+func (apu *APU) IsIRQPending() bool {
+	return apu.frameCounter.isInterruptPending || apu.dmc.isInterruptPending
+}
+
+// DMC DMA
+
+// Returns the address the DMC wants to read, the bus must then call LoadDmcSample
+func (apu *APU) PendingDmcRead() (uint16, bool) {
+	return apu.dmc.currentAddress, apu.dmc.needsSample()
+}
+
+func (apu *APU) LoadDmcSample(data uint8) {
+	apu.dmc.loadSample(data)
+}
+
+// Clocking
+
+// Must be called once per CPU cycle
+func (apu *APU) Clock() {
+	apu.clockFrameEvents(apu.frameCounter.clock())
+	apu.triangle.clockTimer()
+	apu.noise.clockTimer()
+	apu.dmc.clockTimer()
+	// Pulse channels are clocked every APU cycle, which lasts 2 CPU cycles
+	if apu.cycles%2 == 1 {
+		apu.pulse1.clockTimer()
+		apu.pulse2.clockTimer()
+	}
+	apu.cycles += 1
+	if apu.sampler != nil {
+		apu.sampler.addOutput(apu.Output())
+	}
+}
+
+func (apu *APU) clockFrameEvents(events frameEvents) {
+	if events.isQuarterFrame {
+		apu.pulse1.envelope.clock()
+		apu.pulse2.envelope.clock()
+		apu.noise.envelope.clock()
+		apu.triangle.clockLinearCounter()
+	}
+	if events.isHalfFrame {
+		apu.pulse1.lengthCounter.clock()
+		apu.pulse2.lengthCounter.clock()
+		apu.triangle.lengthCounter.clock()
+		apu.noise.lengthCounter.clock()
+		apu.pulse1.clockSweep()
+		apu.pulse2.clockSweep()
+	}
+}
+
+// Output
+
+// Current output of the mixer, in range [0.0, 1.0]
+func (apu *APU) Output() float32 {
+	return mix(apu.pulse1.output(), apu.pulse2.output(), apu.triangle.output(), apu.noise.output(), apu.dmc.output())
+}
+
+// Enables the production of audio samples at the given rate (Hz), 0 disables it
+func (apu *APU) SetSampleRate(sampleRate int) {
+	if sampleRate <= 0 {
+		apu.sampler = nil
+		return
+	}
+	var newSampler = newSampler(sampleRate, apu.region.Timing().CpuFrequency)
+	apu.sampler = &newSampler
+}
+
+func (apu *APU) SampleRate() int {
+	if apu.sampler == nil {
+		return 0
+	}
+	return apu.sampler.sampleRate
+}
+
+func (apu *APU) BufferedSamples() int {
+	if apu.sampler == nil {
+		return 0
+	}
+	return len(apu.sampler.samples)
+}
+
+// Returns the filtered audio samples (roughly in range [-1.0, 1.0]) produced since the last call
+func (apu *APU) TakeSamples() []float32 {
+	if apu.sampler == nil {
+		return nil
+	}
+	return apu.sampler.takeSamples()
+}
@@ -0,0 +1,229 @@
+package apu
+
+import "testing"
+
+func newTestAPU() *APU {
+	var testAPU = NewAPU()
+	testAPU.PowerOn()
+	return &testAPU
+}
+
+func clock(apu *APU, cycles int) {
+	for i := 0; i < cycles; i++ {
+		apu.Clock()
+	}
+}
+
+// https://www.nesdev.org/wiki/APU_Frame_Counter : the flag is set on cycles 29828, 29829 and 29830 of the 4-step sequence
+func TestFrameIRQ(t *testing.T) {
+	var apu = newTestAPU()
+	clock(apu, 29827)
+	if apu.IsIRQPending() {
+		t.Fatalf("frame IRQ raised before cycle 29828")
+	}
+	clock(apu, 1)
+	if !apu.IsIRQPending() {
+		t.Fatalf("frame IRQ not raised on cycle 29828")
+	}
+	// Acknowledging it before the last cycles of the sequence does not last
+	if status := apu.ReadStatus(); status&0b0100_0000 == 0 {
+		t.Errorf("$4015 read $%02X, want the frame interrupt flag", status)
+	}
+	if apu.IsIRQPending() {
+		t.Errorf("reading $4015 did not acknowledge the frame IRQ")
+	}
+	clock(apu, 1)
+	if !apu.IsIRQPending() {
+		t.Fatalf("frame IRQ not raised again on cycle 29829")
+	}
+	apu.ReadStatus()
+	clock(apu, 1)
+	if !apu.IsIRQPending() {
+		t.Fatalf("frame IRQ not raised again on cycle 29830")
+	}
+	apu.ReadStatus()
+	clock(apu, 1)
+	if apu.IsIRQPending() || apu.PeekStatus()&0b0100_0000 != 0 {
+		t.Errorf("frame IRQ raised again once the sequence restarted")
+	}
+
+	// Peeking does not acknowledge
+	clock(apu, 29830)
+	if apu.PeekStatus()&0b0100_0000 == 0 || !apu.IsIRQPending() {
+		t.Errorf("frame IRQ not raised by the second sequence, or acknowledged by peeking")
+	}
+	// Setting the inhibit flag clears it, and prevents the next ones
+	apu.WriteRegister(FRAME_COUNTER, 0b0100_0000)
+	if apu.IsIRQPending() {
+		t.Errorf("inhibiting the frame IRQ did not clear it")
+	}
+	clock(apu, 2*29830)
+	if apu.IsIRQPending() {
+		t.Errorf("frame IRQ raised while inhibited")
+	}
+	// The 5-step sequence never raises it
+	apu.WriteRegister(FRAME_COUNTER, 0b1000_0000)
+	clock(apu, 2*37282)
+	if apu.IsIRQPending() {
+		t.Errorf("frame IRQ raised in 5-step mode")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	var apu = newTestAPU()
+	// Lengths are only loaded in enabled channels
+	apu.WriteRegister(PULSE_1_TIMER_HIGH, 0b0000_1000)
+	if status := apu.ReadStatus(); status != 0 {
+		t.Errorf("$4015 read $%02X after loading the length of a disabled channel", status)
+	}
+	apu.WriteRegister(STATUS, 0b0000_1111)
+	apu.WriteRegister(PULSE_1_TIMER_HIGH, 0b0000_1000)
+	apu.WriteRegister(PULSE_2_TIMER_HIGH, 0b0000_1000)
+	apu.WriteRegister(TRIANGLE_TIMER_HIGH, 0b0000_1000)
+	apu.WriteRegister(NOISE_LENGTH, 0b0000_1000)
+	if status := apu.ReadStatus(); status != 0b0000_1111 {
+		t.Errorf("$4015 read $%02X, want $0F", status)
+	}
+	// Disabling a channel clears its length counter
+	apu.WriteRegister(STATUS, 0b0000_1010)
+	if status := apu.ReadStatus(); status != 0b0000_1010 {
+		t.Errorf("$4015 read $%02X, want $0A", status)
+	}
+}
+
+func TestLengthCounter(t *testing.T) {
+	var tests = []struct {
+		index  uint8
+		length uint8
+	}{
+		{0x00, 10}, {0x01, 254}, {0x0F, 14}, {0x10, 12}, {0x1F, 30},
+	}
+	for _, test := range tests {
+		var apu = newTestAPU()
+		apu.WriteRegister(STATUS, 0b0000_0001)
+		apu.WriteRegister(PULSE_1_TIMER_HIGH, test.index<<3)
+		if apu.pulse1.lengthCounter.value != test.length {
+			t.Errorf("length index $%02X loaded %d, want %d", test.index, apu.pulse1.lengthCounter.value, test.length)
+		}
+	}
+
+	// Clocked by the half frames : 2 per 4-step sequence, unless halted
+	var apu = newTestAPU()
+	apu.WriteRegister(STATUS, 0b0000_0011)
+	apu.WriteRegister(PULSE_1_TIMER_HIGH, 0x00)
+	apu.WriteRegister(PULSE_2_CONTROL, 0b0010_0000)
+	apu.WriteRegister(PULSE_2_TIMER_HIGH, 0x00)
+	clock(apu, 29830)
+	if apu.pulse1.lengthCounter.value != 8 || apu.pulse2.lengthCounter.value != 10 {
+		t.Errorf("lengths %d and %d after a sequence, want 8 and 10 (halted)", apu.pulse1.lengthCounter.value, apu.pulse2.lengthCounter.value)
+	}
+}
+
+// https://www.nesdev.org/wiki/APU_Sweep
+func TestSweepMuting(t *testing.T) {
+	var tests = []struct {
+		name         string
+		timerPeriod  uint16
+		sweep        uint8
+		isSecond     bool
+		targetPeriod uint16
+		isMuted      bool
+	}{
+		// A shift of 0 adds the period to itself
+		{"period under 8", 7, 0x00, false, 14, true},
+		{"period of 8", 8, 0x00, false, 16, false},
+		// Even when the sweep is disabled, a target period over $7FF mutes the channel
+		{"target over $7FF", 0x400, 0x00, false, 0x800, true},
+		{"target of $7FF", 0x554, 0x01, false, 0x7FE, false},
+		{"negated on pulse 1", 0x100, 0x09, false, 0x7F, false},
+		{"negated on pulse 2", 0x100, 0x09, true, 0x80, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var pulse = newPulseChannel(!test.isSecond)
+			pulse.timerPeriod = test.timerPeriod
+			pulse.writeSweep(test.sweep)
+			if target := pulse.sweepTargetPeriod(); target != test.targetPeriod {
+				t.Errorf("target period $%03X, want $%03X", target, test.targetPeriod)
+			}
+			if pulse.isMutedBySweep() != test.isMuted {
+				t.Errorf("muted %v, want %v", pulse.isMutedBySweep(), test.isMuted)
+			}
+		})
+	}
+}
+
+// https://www.nesdev.org/wiki/APU_Noise
+func TestNoiseShiftRegister(t *testing.T) {
+	var tests = []struct {
+		name   string
+		period uint8
+		steps  int
+	}{
+		{"mode 0", 0x00, 32767},
+		{"mode 1", 0x80, 93},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var noise = newNoiseChannel()
+			noise.writePeriod(test.period)
+			var start = noise.shiftRegister
+			for step := 1; step <= test.steps; step++ {
+				noise.timerValue = 0
+				noise.clockTimer()
+				if noise.shiftRegister == start && step != test.steps {
+					t.Fatalf("sequence repeats after %d steps, want %d", step, test.steps)
+				}
+			}
+			if noise.shiftRegister != start {
+				t.Errorf("sequence does not repeat after %d steps", test.steps)
+			}
+		})
+	}
+}
+
+func TestDmcIRQ(t *testing.T) {
+	var apu = newTestAPU()
+	apu.WriteRegister(DMC_CONTROL, 0b1000_0000)
+	apu.WriteRegister(DMC_SAMPLE_ADDRESS, 0x01)
+	apu.WriteRegister(DMC_SAMPLE_LENGTH, 0x00)
+	apu.WriteRegister(STATUS, 0b0001_0000)
+	if status := apu.PeekStatus(); status != 0b0001_0000 {
+		t.Errorf("$4015 read $%02X while playing the sample, want $10", status)
+	}
+	var address, isNeeded = apu.PendingDmcRead()
+	if !isNeeded || address != 0xC040 {
+		t.Fatalf("DMC read of $%04X requested %v, want $C040", address, isNeeded)
+	}
+	// The sample is 1 byte long
+	apu.LoadDmcSample(0xFF)
+	if !apu.IsIRQPending() || apu.ReadStatus() != 0b1000_0000 {
+		t.Errorf("no DMC IRQ at the end of the sample")
+	}
+	// Reading $4015 does not acknowledge it, writing does
+	if !apu.IsIRQPending() {
+		t.Errorf("reading $4015 acknowledged the DMC IRQ")
+	}
+	apu.WriteRegister(STATUS, 0x00)
+	if apu.IsIRQPending() {
+		t.Errorf("writing $4015 did not acknowledge the DMC IRQ")
+	}
+
+	// Looping samples never end
+	apu.WriteRegister(DMC_CONTROL, 0b1100_0000)
+	apu.WriteRegister(STATUS, 0b0001_0000)
+	apu.LoadDmcSample(0xFF)
+	if apu.IsIRQPending() {
+		t.Errorf("DMC IRQ at the end of a looping sample")
+	}
+	if _, isNeeded := apu.PendingDmcRead(); isNeeded {
+		t.Errorf("DMC read requested while the sample buffer is full")
+	}
+	// Clearing the IRQ enable flag acknowledges it
+	apu.WriteRegister(DMC_CONTROL, 0b1000_0000)
+	apu.dmc.isInterruptPending = true
+	apu.WriteRegister(DMC_CONTROL, 0x00)
+	if apu.IsIRQPending() {
+		t.Errorf("clearing the IRQ enable flag did not acknowledge the DMC IRQ")
+	}
+}
@@ -0,0 +1,160 @@
+package apu
+
+import "nes-emulator/savestate"
+
+// https://www.nesdev.org/wiki/APU_DMC
+type dmcChannel struct {
+	isIRQEnabled       bool
+	isInterruptPending bool
+	isLooping          bool
+	timerPeriod        uint16
+	timerValue         uint16
+	periodTable        *[16]uint16
+	// Output unit
+	outputLevel   uint8
+	shiftRegister uint8
+	bitsRemaining uint8
+	isSilenced    bool
+	// Memory reader
+	sampleAddress       uint16
+	sampleLength        uint16
+	currentAddress      uint16
+	bytesRemaining      uint16
+	sampleBuffer        uint8
+	isSampleBufferEmpty bool
+}
+
+func newDmcChannel() dmcChannel {
+	return dmcChannel{
+		timerPeriod:         ntscDmcPeriodTable[0],
+		periodTable:         &ntscDmcPeriodTable,
+		bitsRemaining:       8,
+		isSilenced:          true,
+		isSampleBufferEmpty: true,
+	}
+}
+
+// Registers
+
+func (dmc *dmcChannel) writeControl(data uint8) {
+	// IL-- RRRR
+	dmc.isIRQEnabled = data&0b1000_0000 != 0
+	dmc.isLooping = data&0b0100_0000 != 0
+	dmc.timerPeriod = dmc.periodTable[data&0b0000_1111]
+	if !dmc.isIRQEnabled {
+		dmc.isInterruptPending = false
+	}
+}
+
+func (dmc *dmcChannel) writeDirectLoad(data uint8) {
+	// -DDD DDDD
+	dmc.outputLevel = data & 0b0111_1111
+}
+
+func (dmc *dmcChannel) writeSampleAddress(data uint8) {
+	// Sample address = %11AAAAAA.AA000000 = $C000 + (A * 64)
+	dmc.sampleAddress = 0xC000 | uint16(data)<<6
+}
+
+func (dmc *dmcChannel) writeSampleLength(data uint8) {
+	// Sample length = %LLLL.LLLL0001 = (L * 16) + 1 bytes
+	dmc.sampleLength = uint16(data)<<4 | 1
+}
+
+func (dmc *dmcChannel) setEnabled(isEnabled bool) {
+	if !isEnabled {
+		dmc.bytesRemaining = 0
+	} else if dmc.bytesRemaining == 0 {
+		dmc.restartSample()
+	}
+}
+
+func (dmc *dmcChannel) restartSample() {
+	dmc.currentAddress = dmc.sampleAddress
+	dmc.bytesRemaining = dmc.sampleLength
+}
+
+// Memory reader
+
+// The sample buffer is filled by the bus through DMA, stalling the CPU
+func (dmc *dmcChannel) needsSample() bool {
+	return dmc.isSampleBufferEmpty && dmc.bytesRemaining > 0
+}
+
+func (dmc *dmcChannel) loadSample(data uint8) {
+	dmc.sampleBuffer = data
+	dmc.isSampleBufferEmpty = false
+	// Address wraps around to $8000 instead of $0000
+	if dmc.currentAddress == 0xFFFF {
+		dmc.currentAddress = 0x8000
+	} else {
+		dmc.currentAddress += 1
+	}
+	dmc.bytesRemaining -= 1
+	if dmc.bytesRemaining == 0 {
+		if dmc.isLooping {
+			dmc.restartSample()
+		} else if dmc.isIRQEnabled {
+			dmc.isInterruptPending = true
+		}
+	}
+}
+
+// Clocking
+
+// Clocked every CPU cycle
+func (dmc *dmcChannel) clockTimer() {
+	if dmc.timerValue > 0 {
+		dmc.timerValue -= 1
+		return
+	}
+	dmc.timerValue = dmc.timerPeriod - 1
+	dmc.clockOutputUnit()
+}
+
+func (dmc *dmcChannel) clockOutputUnit() {
+	if !dmc.isSilenced {
+		if dmc.shiftRegister&1 != 0 {
+			if dmc.outputLevel <= 125 {
+				dmc.outputLevel += 2
+			}
+		} else if dmc.outputLevel >= 2 {
+			dmc.outputLevel -= 2
+		}
+	}
+	dmc.shiftRegister >>= 1
+	dmc.bitsRemaining -= 1
+	if dmc.bitsRemaining == 0 {
+		// A new output cycle starts
+		dmc.bitsRemaining = 8
+		if dmc.isSampleBufferEmpty {
+			dmc.isSilenced = true
+		} else {
+			dmc.isSilenced = false
+			dmc.shiftRegister = dmc.sampleBuffer
+			dmc.isSampleBufferEmpty = true
+		}
+	}
+}
+
+func (dmc *dmcChannel) output() uint8 {
+	return dmc.outputLevel
+}
+
+func (dmc *dmcChannel) serializeState(state *savestate.Serializer) {
+	state.Bool(&dmc.isIRQEnabled)
+	state.Bool(&dmc.isInterruptPending)
+	state.Bool(&dmc.isLooping)
+	state.Uint16(&dmc.timerPeriod)
+	state.Uint16(&dmc.timerValue)
+	state.Uint8(&dmc.outputLevel)
+	state.Uint8(&dmc.shiftRegister)
+	state.Uint8(&dmc.bitsRemaining)
+	state.Bool(&dmc.isSilenced)
+	state.Uint16(&dmc.sampleAddress)
+	state.Uint16(&dmc.sampleLength)
+	state.Uint16(&dmc.currentAddress)
+	state.Uint16(&dmc.bytesRemaining)
+	state.Uint8(&dmc.sampleBuffer)
+	state.Bool(&dmc.isSampleBufferEmpty)
+}
@@ -0,0 +1,62 @@
+package apu
+
+import "math"
+
+// The console output goes through a chain of first-order filters
+// More info here : https://www.nesdev.org/wiki/APU_Mixer#Emulation
+
+const HIGH_PASS_FILTER_1_FREQUENCY float64 = 90
+const HIGH_PASS_FILTER_2_FREQUENCY float64 = 440
+const LOW_PASS_FILTER_FREQUENCY float64 = 14000
+
+type firstOrderFilter struct {
+	b0             float32
+	b1             float32
+	a1             float32
+	previousInput  float32
+	previousOutput float32
+}
+
+func newLowPassFilter(sampleRate float64, cutoffFrequency float64) firstOrderFilter {
+	var c = sampleRate / math.Pi / cutoffFrequency
+	var a0i = 1 / (1 + c)
+	return firstOrderFilter{
+		b0: float32(a0i),
+		b1: float32(a0i),
+		a1: float32((1 - c) * a0i),
+	}
+}
+
+func newHighPassFilter(sampleRate float64, cutoffFrequency float64) firstOrderFilter {
+	var c = sampleRate / math.Pi / cutoffFrequency
+	var a0i = 1 / (1 + c)
+	return firstOrderFilter{
+		b0: float32(c * a0i),
+		b1: float32(-c * a0i),
+		a1: float32((1 - c) * a0i),
+	}
+}
+
+func (filter *firstOrderFilter) process(input float32) float32 {
+	var output = filter.b0*input + filter.b1*filter.previousInput - filter.a1*filter.previousOutput
+	filter.previousInput = input
+	filter.previousOutput = output
+	return output
+}
+
+type filterChain []firstOrderFilter
+
+func newConsoleFilterChain(sampleRate float64) filterChain {
+	return filterChain{
+		newHighPassFilter(sampleRate, HIGH_PASS_FILTER_1_FREQUENCY),
+		newHighPassFilter(sampleRate, HIGH_PASS_FILTER_2_FREQUENCY),
+		newLowPassFilter(sampleRate, LOW_PASS_FILTER_FREQUENCY),
+	}
+}
+
+func (chain filterChain) process(input float32) float32 {
+	for i := range chain {
+		input = chain[i].process(input)
+	}
+	return input
+}
@@ -0,0 +1,128 @@
+package apu
+
+import (
+	"nes-emulator/region"
+	"nes-emulator/savestate"
+)
+
+// https://www.nesdev.org/wiki/APU_Frame_Counter
+type frameCounter struct {
+	isFiveStepMode     bool
+	isIRQInhibited     bool
+	isInterruptPending bool
+	// CPU cycles elapsed since the beginning of the sequence
+	cycles int
+	steps  *frameCounterSteps
+}
+
+// Steps of the sequences expressed in CPU cycles
+// The 4-step sequence raises its interrupt flag on its last 3 cycles, from fourStepIRQ to fourStepReset
+type frameCounterSteps struct {
+	step1         int
+	step2         int
+	step3         int
+	fourStepIRQ   int
+	fourStep4     int
+	fourStepReset int
+	fiveStep5     int
+	fiveStepReset int
+}
+
+var ntscFrameCounterSteps = frameCounterSteps{
+	step1:         7457,
+	step2:         14913,
+	step3:         22371,
+	fourStepIRQ:   29828,
+	fourStep4:     29829,
+	fourStepReset: 29830,
+	fiveStep5:     37281,
+	fiveStepReset: 37282,
+}
+
+var palFrameCounterSteps = frameCounterSteps{
+	step1:         8313,
+	step2:         16627,
+	step3:         24939,
+	fourStepIRQ:   33252,
+	fourStep4:     33253,
+	fourStepReset: 33254,
+	fiveStep5:     41565,
+	fiveStepReset: 41566,
+}
+
+var frameCounterStepsTables = map[region.Region]*frameCounterSteps{
+	region.NTSC:  &ntscFrameCounterSteps,
+	region.PAL:   &palFrameCounterSteps,
+	region.DENDY: &ntscFrameCounterSteps,
+}
+
+func newFrameCounter() frameCounter {
+	return frameCounter{steps: &ntscFrameCounterSteps}
+}
+
+type frameEvents struct {
+	isQuarterFrame bool
+	isHalfFrame    bool
+}
+
+func (counter *frameCounter) write(data uint8) frameEvents {
+	// MI-- ----
+	counter.isFiveStepMode = data&0b1000_0000 != 0
+	counter.isIRQInhibited = data&0b0100_0000 != 0
+	if counter.isIRQInhibited {
+		counter.isInterruptPending = false
+	}
+	counter.cycles = 0
+	// Writing with the 5-step mode bit set immediately clocks all units
+	return frameEvents{
+		isQuarterFrame: counter.isFiveStepMode,
+		isHalfFrame:    counter.isFiveStepMode,
+	}
+}
+
+// Clocked every CPU cycle
+func (counter *frameCounter) clock() frameEvents {
+	counter.cycles += 1
+	var events = frameEvents{}
+	switch counter.cycles {
+	case counter.steps.step1, counter.steps.step3:
+		events.isQuarterFrame = true
+	case counter.steps.step2:
+		events.isQuarterFrame = true
+		events.isHalfFrame = true
+	case counter.steps.fourStepIRQ:
+		if !counter.isFiveStepMode {
+			counter.setInterrupt()
+		}
+	case counter.steps.fourStep4:
+		if !counter.isFiveStepMode {
+			events.isQuarterFrame = true
+			events.isHalfFrame = true
+			counter.setInterrupt()
+		}
+	case counter.steps.fourStepReset:
+		if !counter.isFiveStepMode {
+			counter.setInterrupt()
+			counter.cycles = 0
+		}
+	case counter.steps.fiveStep5:
+		events.isQuarterFrame = true
+		events.isHalfFrame = true
+	case counter.steps.fiveStepReset:
+		counter.cycles = 0
+	}
+	return events
+}
+
+func (counter *frameCounter) setInterrupt() {
+	if !counter.isIRQInhibited {
+		counter.isInterruptPending = true
+	}
+}
+
+func (counter *frameCounter) serializeState(state *savestate.Serializer) {
+	state.Bool(&counter.isFiveStepMode)
+	state.Bool(&counter.isIRQInhibited)
+	state.Bool(&counter.isInterruptPending)
+	state.Int(&counter.cycles)
+}
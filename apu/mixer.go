@@ -0,0 +1,23 @@
+package apu
+
+// https://www.nesdev.org/wiki/APU_Mixer
+// The non-linear mixing of the channels is approximated with lookup tables
+
+var pulseTable [31]float32
+var tndTable [203]float32
+
+func init() {
+	for i := 1; i < len(pulseTable); i++ {
+		pulseTable[i] = float32(95.52 / (8128.0/float64(i) + 100))
+	}
+	for i := 1; i < len(tndTable); i++ {
+		tndTable[i] = float32(163.67 / (24329.0/float64(i) + 100))
+	}
+}
+
+// Output is in range [0.0, 1.0]
+func mix(pulse1 uint8, pulse2 uint8, triangle uint8, noise uint8, dmc uint8) float32 {
+	var pulseOutput = pulseTable[pulse1+pulse2]
+	var tndOutput = tndTable[3*uint16(triangle)+2*uint16(noise)+uint16(dmc)]
+	return pulseOutput + tndOutput
+}
@@ -0,0 +1,74 @@
+package apu
+
+import "nes-emulator/savestate"
+
+// https://www.nesdev.org/wiki/APU_Noise
+type noiseChannel struct {
+	// Mode 1 produces short (93 steps) periodic noise
+	isShortMode   bool
+	shiftRegister uint16
+	timerPeriod   uint16
+	timerValue    uint16
+	lengthCounter lengthCounter
+	envelope      envelope
+	periodTable   *[16]uint16
+}
+
+func newNoiseChannel() noiseChannel {
+	// The shift register is set to 1 on power-up
+	return noiseChannel{shiftRegister: 1, timerPeriod: ntscNoisePeriodTable[0], periodTable: &ntscNoisePeriodTable}
+}
+
+// Registers
+
+func (noise *noiseChannel) writeControl(data uint8) {
+	// --LC VVVV
+	noise.lengthCounter.isHalted = data&0b0010_0000 != 0
+	noise.envelope.write(data)
+}
+
+func (noise *noiseChannel) writePeriod(data uint8) {
+	// M--- PPPP
+	noise.isShortMode = data&0b1000_0000 != 0
+	noise.timerPeriod = noise.periodTable[data&0b0000_1111]
+}
+
+func (noise *noiseChannel) writeLength(data uint8) {
+	// LLLL L---
+	noise.lengthCounter.load(data >> 3)
+	noise.envelope.isStartFlagSet = true
+}
+
+// Clocking
+
+// Clocked every CPU cycle
+func (noise *noiseChannel) clockTimer() {
+	if noise.timerValue > 0 {
+		noise.timerValue -= 1
+		return
+	}
+	noise.timerValue = noise.timerPeriod - 1
+	// Linear-feedback shift register : feedback is bit 0 XOR bit 6 (mode 1) or bit 1 (mode 0)
+	var otherBit = (noise.shiftRegister >> 1) & 1
+	if noise.isShortMode {
+		otherBit = (noise.shiftRegister >> 6) & 1
+	}
+	var feedback = (noise.shiftRegister & 1) ^ otherBit
+	noise.shiftRegister = (noise.shiftRegister >> 1) | (feedback << 14)
+}
+
+func (noise *noiseChannel) output() uint8 {
+	if noise.lengthCounter.value == 0 || noise.shiftRegister&1 != 0 {
+		return 0
+	}
+	return noise.envelope.output()
+}
+
+func (noise *noiseChannel) serializeState(state *savestate.Serializer) {
+	state.Bool(&noise.isShortMode)
+	state.Uint16(&noise.shiftRegister)
+	state.Uint16(&noise.timerPeriod)
+	state.Uint16(&noise.timerValue)
+	noise.lengthCounter.serializeState(state)
+	noise.envelope.serializeState(state)
+}
@@ -0,0 +1,126 @@
+package apu
+
+import "nes-emulator/savestate"
+
+// https://www.nesdev.org/wiki/APU_Pulse
+type pulseChannel struct {
+	// Pulse 1 and pulse 2 differ in the way the sweep unit negates the period
+	isFirstChannel bool
+	duty           uint8
+	sequencerStep  uint8
+	timerPeriod    uint16
+	timerValue     uint16
+	lengthCounter  lengthCounter
+	envelope       envelope
+	sweep          sweep
+}
+
+// https://www.nesdev.org/wiki/APU_Sweep
+type sweep struct {
+	isEnabled    bool
+	isNegated    bool
+	isReloadSet  bool
+	period       uint8
+	shift        uint8
+	dividerValue uint8
+}
+
+func newPulseChannel(isFirstChannel bool) pulseChannel {
+	return pulseChannel{isFirstChannel: isFirstChannel}
+}
+
+// Registers
+
+func (pulse *pulseChannel) writeControl(data uint8) {
+	// DDLC VVVV
+	pulse.duty = data >> 6
+	pulse.lengthCounter.isHalted = data&0b0010_0000 != 0
+	pulse.envelope.write(data)
+}
+
+func (pulse *pulseChannel) writeSweep(data uint8) {
+	// EPPP NSSS
+	pulse.sweep.isEnabled = data&0b1000_0000 != 0
+	pulse.sweep.period = (data >> 4) & 0b0000_0111
+	pulse.sweep.isNegated = data&0b0000_1000 != 0
+	pulse.sweep.shift = data & 0b0000_0111
+	pulse.sweep.isReloadSet = true
+}
+
+func (pulse *pulseChannel) writeTimerLow(data uint8) {
+	pulse.timerPeriod = (pulse.timerPeriod & 0xFF00) | uint16(data)
+}
+
+func (pulse *pulseChannel) writeTimerHigh(data uint8) {
+	// LLLL LTTT
+	pulse.timerPeriod = (pulse.timerPeriod & 0x00FF) | uint16(data&0b0000_0111)<<8
+	pulse.lengthCounter.load(data >> 3)
+	pulse.sequencerStep = 0
+	pulse.envelope.isStartFlagSet = true
+}
+
+// Clocking
+
+// Clocked every APU cycle (every other CPU cycle)
+func (pulse *pulseChannel) clockTimer() {
+	if pulse.timerValue == 0 {
+		pulse.timerValue = pulse.timerPeriod
+		pulse.sequencerStep = (pulse.sequencerStep + 1) & 0b0000_0111
+	} else {
+		pulse.timerValue -= 1
+	}
+}
+
+func (pulse *pulseChannel) sweepTargetPeriod() uint16 {
+	var change = pulse.timerPeriod >> pulse.sweep.shift
+	if !pulse.sweep.isNegated {
+		return pulse.timerPeriod + change
+	}
+	// Pulse 1 uses ones' complement while pulse 2 uses two's complement
+	if pulse.isFirstChannel {
+		change += 1
+	}
+	if change > pulse.timerPeriod {
+		return 0
+	}
+	return pulse.timerPeriod - change
+}
+
+func (pulse *pulseChannel) isMutedBySweep() bool {
+	return pulse.timerPeriod < 8 || pulse.sweepTargetPeriod() > 0x7FF
+}
+
+// Clocked by half frames
+func (pulse *pulseChannel) clockSweep() {
+	if pulse.sweep.dividerValue == 0 && pulse.sweep.isEnabled && pulse.sweep.shift > 0 && !pulse.isMutedBySweep() {
+		pulse.timerPeriod = pulse.sweepTargetPeriod()
+	}
+	if pulse.sweep.dividerValue == 0 || pulse.sweep.isReloadSet {
+		pulse.sweep.dividerValue = pulse.sweep.period
+		pulse.sweep.isReloadSet = false
+	} else {
+		pulse.sweep.dividerValue -= 1
+	}
+}
+
+func (pulse *pulseChannel) output() uint8 {
+	if pulse.lengthCounter.value == 0 || pulse.isMutedBySweep() || dutyTable[pulse.duty][pulse.sequencerStep] == 0 {
+		return 0
+	}
+	return pulse.envelope.output()
+}
+
+func (pulse *pulseChannel) serializeState(state *savestate.Serializer) {
+	state.Uint8(&pulse.duty)
+	state.Uint8(&pulse.sequencerStep)
+	state.Uint16(&pulse.timerPeriod)
+	state.Uint16(&pulse.timerValue)
+	pulse.lengthCounter.serializeState(state)
+	pulse.envelope.serializeState(state)
+	state.Bool(&pulse.sweep.isEnabled)
+	state.Bool(&pulse.sweep.isNegated)
+	state.Bool(&pulse.sweep.isReloadSet)
+	state.Uint8(&pulse.sweep.period)
+	state.Uint8(&pulse.sweep.shift)
+	state.Uint8(&pulse.sweep.dividerValue)
+}
@@ -0,0 +1,41 @@
+package apu
+
+// Resamples the mixer output (one value per CPU cycle) to the output sample rate
+// Mixer values are averaged over each output sample period, then go through the console filter chain
+type sampler struct {
+	sampleRate      int
+	cyclesPerSample float64
+	cycles          float64
+	outputSum       float64
+	outputCount     int
+	filters         filterChain
+	samples         []float32
+}
+
+// The APU produces one output value per CPU cycle
+func newSampler(sampleRate int, cpuFrequency float64) sampler {
+	return sampler{
+		sampleRate:      sampleRate,
+		cyclesPerSample: cpuFrequency / float64(sampleRate),
+		filters:         newConsoleFilterChain(float64(sampleRate)),
+	}
+}
+
+func (sampler *sampler) addOutput(output float32) {
+	sampler.outputSum += float64(output)
+	sampler.outputCount += 1
+	sampler.cycles += 1
+	if sampler.cycles >= sampler.cyclesPerSample {
+		sampler.cycles -= sampler.cyclesPerSample
+		var average = float32(sampler.outputSum / float64(sampler.outputCount))
+		sampler.samples = append(sampler.samples, sampler.filters.process(average))
+		sampler.outputSum = 0
+		sampler.outputCount = 0
+	}
+}
+
+func (sampler *sampler) takeSamples() []float32 {
+	var samples = sampler.samples
+	sampler.samples = nil
+	return samples
+}
@@ -0,0 +1,55 @@
+package apu
+
+import "nes-emulator/region"
+
+// https://www.nesdev.org/wiki/APU_Length_Counter
+var lengthTable = [32]uint8{
+	10, 254, 20, 2, 40, 4, 80, 6, 160, 8, 60, 10, 14, 12, 26, 14,
+	12, 16, 24, 18, 48, 20, 96, 22, 192, 24, 72, 26, 16, 28, 32, 30,
+}
+
+// https://www.nesdev.org/wiki/APU_Pulse
+var dutyTable = [4][8]uint8{
+	{0, 1, 0, 0, 0, 0, 0, 0}, // 12.5 %
+	{0, 1, 1, 0, 0, 0, 0, 0}, // 25 %
+	{0, 1, 1, 1, 1, 0, 0, 0}, // 50 %
+	{1, 0, 0, 1, 1, 1, 1, 1}, // 25 % negated
+}
+
+// https://www.nesdev.org/wiki/APU_Triangle
+var triangleTable = [32]uint8{
+	15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0,
+	0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+}
+
+// https://www.nesdev.org/wiki/APU_Noise
+// Periods are expressed in CPU cycles
+var ntscNoisePeriodTable = [16]uint16{
+	4, 8, 16, 32, 64, 96, 128, 160, 202, 254, 380, 508, 762, 1016, 2034, 4068,
+}
+
+var palNoisePeriodTable = [16]uint16{
+	4, 8, 14, 30, 60, 88, 118, 148, 188, 236, 354, 472, 708, 944, 1890, 3778,
+}
+
+var noisePeriodTables = map[region.Region]*[16]uint16{
+	region.NTSC:  &ntscNoisePeriodTable,
+	region.PAL:   &palNoisePeriodTable,
+	region.DENDY: &ntscNoisePeriodTable,
+}
+
+// https://www.nesdev.org/wiki/APU_DMC
+// Periods are expressed in CPU cycles
+var ntscDmcPeriodTable = [16]uint16{
+	428, 380, 340, 320, 286, 254, 226, 214, 190, 160, 142, 128, 106, 84, 72, 54,
+}
+
+var palDmcPeriodTable = [16]uint16{
+	398, 354, 316, 298, 276, 236, 210, 198, 176, 148, 132, 118, 98, 78, 66, 50,
+}
+
+var dmcPeriodTables = map[region.Region]*[16]uint16{
+	region.NTSC:  &ntscDmcPeriodTable,
+	region.PAL:   &palDmcPeriodTable,
+	region.DENDY: &ntscDmcPeriodTable,
+}
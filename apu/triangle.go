@@ -0,0 +1,84 @@
+package apu
+
+import "nes-emulator/savestate"
+
+// https://www.nesdev.org/wiki/APU_Triangle
+type triangleChannel struct {
+	sequencerStep uint8
+	timerPeriod   uint16
+	timerValue    uint16
+	lengthCounter lengthCounter
+	linearCounter linearCounter
+}
+
+// https://www.nesdev.org/wiki/APU_Triangle
+type linearCounter struct {
+	// Also used as the length counter halt flag
+	isControlFlagSet bool
+	isReloadFlagSet  bool
+	reloadValue      uint8
+	value            uint8
+}
+
+// Registers
+
+func (triangle *triangleChannel) writeLinearCounter(data uint8) {
+	// CRRR RRRR
+	triangle.linearCounter.isControlFlagSet = data&0b1000_0000 != 0
+	triangle.lengthCounter.isHalted = triangle.linearCounter.isControlFlagSet
+	triangle.linearCounter.reloadValue = data & 0b0111_1111
+}
+
+func (triangle *triangleChannel) writeTimerLow(data uint8) {
+	triangle.timerPeriod = (triangle.timerPeriod & 0xFF00) | uint16(data)
+}
+
+func (triangle *triangleChannel) writeTimerHigh(data uint8) {
+	// LLLL LTTT
+	triangle.timerPeriod = (triangle.timerPeriod & 0x00FF) | uint16(data&0b0000_0111)<<8
+	triangle.lengthCounter.load(data >> 3)
+	triangle.linearCounter.isReloadFlagSet = true
+}
+
+// Clocking
+
+// Clocked every CPU cycle
+func (triangle *triangleChannel) clockTimer() {
+	if triangle.timerValue > 0 {
+		triangle.timerValue -= 1
+		return
+	}
+	triangle.timerValue = triangle.timerPeriod
+	// The sequencer is only clocked when both counters are non-zero
+	if triangle.lengthCounter.value > 0 && triangle.linearCounter.value > 0 {
+		triangle.sequencerStep = (triangle.sequencerStep + 1) & 0b0001_1111
+	}
+}
+
+// Clocked by quarter frames
+func (triangle *triangleChannel) clockLinearCounter() {
+	if triangle.linearCounter.isReloadFlagSet {
+		triangle.linearCounter.value = triangle.linearCounter.reloadValue
+	} else if triangle.linearCounter.value > 0 {
+		triangle.linearCounter.value -= 1
+	}
+	if !triangle.linearCounter.isControlFlagSet {
+		triangle.linearCounter.isReloadFlagSet = false
+	}
+}
+
+func (triangle *triangleChannel) output() uint8 {
+	// The sequencer is simply halted when muted, so its current output value is kept
+	return triangleTable[triangle.sequencerStep]
+}
+
+func (triangle *triangleChannel) serializeState(state *savestate.Serializer) {
+	state.Uint8(&triangle.sequencerStep)
+	state.Uint16(&triangle.timerPeriod)
+	state.Uint16(&triangle.timerValue)
+	triangle.lengthCounter.serializeState(state)
+	state.Bool(&triangle.linearCounter.isControlFlagSet)
+	state.Bool(&triangle.linearCounter.isReloadFlagSet)
+	state.Uint8(&triangle.linearCounter.reloadValue)
+	state.Uint8(&triangle.linearCounter.value)
+}
@@ -0,0 +1,91 @@
+package apu
+
+import "nes-emulator/savestate"
+
+// Units shared between several channels
+
+// https://www.nesdev.org/wiki/APU_Length_Counter
+type lengthCounter struct {
+	isEnabled bool
+	isHalted  bool
+	value     uint8
+}
+
+func (counter *lengthCounter) load(index uint8) {
+	if counter.isEnabled {
+		counter.value = lengthTable[index&0b0001_1111]
+	}
+}
+
+func (counter *lengthCounter) setEnabled(isEnabled bool) {
+	counter.isEnabled = isEnabled
+	if !isEnabled {
+		counter.value = 0
+	}
+}
+
+// Clocked by half frames
+func (counter *lengthCounter) clock() {
+	if !counter.isHalted && counter.value > 0 {
+		counter.value -= 1
+	}
+}
+
+// https://www.nesdev.org/wiki/APU_Envelope
+type envelope struct {
+	isStartFlagSet   bool
+	isLooping        bool
+	isConstantVolume bool
+	// Used both as the constant volume and as the divider period
+	volume     uint8
+	divider    uint8
+	decayLevel uint8
+}
+
+func (envelope *envelope) write(data uint8) {
+	envelope.isLooping = data&0b0010_0000 != 0
+	envelope.isConstantVolume = data&0b0001_0000 != 0
+	envelope.volume = data & 0b0000_1111
+}
+
+// Clocked by quarter frames
+func (envelope *envelope) clock() {
+	if envelope.isStartFlagSet {
+		envelope.isStartFlagSet = false
+		envelope.decayLevel = 15
+		envelope.divider = envelope.volume
+		return
+	}
+	if envelope.divider > 0 {
+		envelope.divider -= 1
+		return
+	}
+	envelope.divider = envelope.volume
+	if envelope.decayLevel > 0 {
+		envelope.decayLevel -= 1
+	} else if envelope.isLooping {
+		envelope.decayLevel = 15
+	}
+}
+
+func (envelope *envelope) output() uint8 {
+	if envelope.isConstantVolume {
+		return envelope.volume
+	}
+	return envelope.decayLevel
+}
+
+func (counter *lengthCounter) serializeState(state *savestate.Serializer) {
+	state.Bool(&counter.isEnabled)
+	state.Bool(&counter.isHalted)
+	state.Uint8(&counter.value)
+}
+
+func (envelope *envelope) serializeState(state *savestate.Serializer) {
+	state.Bool(&envelope.isStartFlagSet)
+	state.Bool(&envelope.isLooping)
+	state.Bool(&envelope.isConstantVolume)
+	state.Uint8(&envelope.volume)
+	state.Uint8(&envelope.divider)
+	state.Uint8(&envelope.decayLevel)
+}
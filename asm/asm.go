@@ -0,0 +1,491 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"nes-emulator/cpu"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Assembles 6502 source written in the ca65 syntax, enough of it to read back the output of the disasm package
+// Statements, one per line :
+//   label:                 labels the current address
+//   NAME = expression      defines a constant
+//   mnemonic operand       official and unofficial instructions of cpu/opsCode.go
+//   .org, .byte, .word, .incbin, .setcpu (ignored)
+// Operands : #imm, zp, abs, a:abs (forces absolute addressing), zp/abs,x / ,y, (ind), (zp,x), (zp),y, a (accumulator)
+
+// Address of the code preceding the first .org
+const DEFAULT_ORIGIN uint16 = 0x8000
+
+type Options struct {
+	// Reads the files of .incbin directives, os.ReadFile when nil
+	ReadFile func(path string) ([]byte, error)
+}
+
+// Bytes assembled at consecutive addresses
+type Segment struct {
+	Origin uint16
+	Bytes  []uint8
+}
+
+type Program struct {
+	// Sorted by origin, they never overlap
+	Segments []Segment
+	// Labels and constants
+	Symbols map[string]int
+}
+
+type statement struct {
+	lineNumber int
+	label      string
+	// Constant defined by the statement, mnemonic and directive are then empty
+	constant string
+	// Upper case
+	mnemonic string
+	// Lower case, dot included
+	directive string
+	operand   string
+	// Decided by the first pass, so that addresses do not move in the second one
+	hexCode uint8
+	size    int
+}
+
+type assembler struct {
+	statements     []*statement
+	options        Options
+	symbols        map[string]int
+	programCounter int
+	segments       []Segment
+	isFinalPass    bool
+}
+
+func Assemble(source string, options Options) (*Program, error) {
+	if options.ReadFile == nil {
+		options.ReadFile = os.ReadFile
+	}
+	var assembler = assembler{options: options, symbols: make(map[string]int)}
+	for i, sourceLine := range strings.Split(source, "\n") {
+		var statement, err = parseLine(sourceLine)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		statement.lineNumber = i + 1
+		assembler.statements = append(assembler.statements, statement)
+	}
+	for _, isFinalPass := range []bool{false, true} {
+		assembler.isFinalPass = isFinalPass
+		assembler.programCounter = int(DEFAULT_ORIGIN)
+		assembler.segments = []Segment{{Origin: DEFAULT_ORIGIN}}
+		for _, statement := range assembler.statements {
+			if err := assembler.assembleStatement(statement); err != nil {
+				return nil, fmt.Errorf("line %d: %w", statement.lineNumber, err)
+			}
+		}
+	}
+	return assembler.program()
+}
+
+// .incbin paths are relative to the directory of the source file
+func AssembleFile(path string) (*Program, error) {
+	var source, err = os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var directory = filepath.Dir(path)
+	return Assemble(string(source), Options{
+		ReadFile: func(includedPath string) ([]byte, error) {
+			if !filepath.IsAbs(includedPath) {
+				includedPath = filepath.Join(directory, includedPath)
+			}
+			return os.ReadFile(includedPath)
+		},
+	})
+}
+
+// Bytes from the lowest to the highest assembled address, gaps between segments are filled with fill
+func (program *Program) Bytes(fill uint8) ([]uint8, uint16) {
+	if len(program.Segments) == 0 {
+		return nil, 0
+	}
+	var first = program.Segments[0]
+	var last = program.Segments[len(program.Segments)-1]
+	var bytes = make([]uint8, int(last.Origin)+len(last.Bytes)-int(first.Origin))
+	for i := range bytes {
+		bytes[i] = fill
+	}
+	for _, segment := range program.Segments {
+		copy(bytes[segment.Origin-first.Origin:], segment.Bytes)
+	}
+	return bytes, first.Origin
+}
+
+// Parsing
+
+func parseLine(sourceLine string) (*statement, error) {
+	var statement = &statement{}
+	var text = strings.TrimSpace(stripComment(sourceLine))
+	if colon := strings.Index(text, ":"); colon > 0 && isIdentifier(text[:colon]) {
+		statement.label = text[:colon]
+		text = strings.TrimSpace(text[colon+1:])
+	}
+	if text == "" {
+		return statement, nil
+	}
+	var name, operand = text, ""
+	if space := strings.IndexAny(text, " \t"); space >= 0 {
+		name, operand = text[:space], strings.TrimSpace(text[space:])
+	}
+	switch {
+	case strings.HasPrefix(operand, "=") && isIdentifier(name):
+		statement.constant = name
+		statement.operand = strings.TrimSpace(operand[1:])
+	case strings.HasPrefix(name, "."):
+		statement.directive = strings.ToLower(name)
+		statement.operand = operand
+	case opCodes[strings.ToUpper(name)] != nil:
+		statement.mnemonic = strings.ToUpper(name)
+		statement.operand = operand
+	default:
+		// NAME=expression, without spaces
+		if before, after, isConstant := strings.Cut(text, "="); isConstant && isIdentifier(strings.TrimSpace(before)) {
+			statement.constant = strings.TrimSpace(before)
+			statement.operand = strings.TrimSpace(after)
+			return statement, nil
+		}
+		return nil, fmt.Errorf("unknown instruction %q", name)
+	}
+	return statement, nil
+}
+
+func stripComment(sourceLine string) string {
+	var isQuoted = false
+	for i := 0; i < len(sourceLine); i++ {
+		switch sourceLine[i] {
+		case '"':
+			isQuoted = !isQuoted
+		case '\'':
+			// Character literal
+			if !isQuoted && i+2 < len(sourceLine) && sourceLine[i+2] == '\'' {
+				i += 2
+			}
+		case ';':
+			if !isQuoted {
+				return sourceLine[:i]
+			}
+		}
+	}
+	return sourceLine
+}
+
+// Splits the arguments of a directive on the commas outside of strings and parentheses
+func splitArguments(operand string) []string {
+	var arguments []string
+	var depth = 0
+	var isQuoted = false
+	var start = 0
+	for i := 0; i < len(operand); i++ {
+		switch operand[i] {
+		case '"':
+			isQuoted = !isQuoted
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if !isQuoted && depth == 0 {
+				arguments = append(arguments, strings.TrimSpace(operand[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(arguments, strings.TrimSpace(operand[start:]))
+}
+
+// Passes
+
+func (assembler *assembler) lookup(name string) (int, bool) {
+	var value, isDefined = assembler.symbols[name]
+	return value, isDefined
+}
+
+// Value of an expression, symbols not defined yet are only accepted in the first pass
+func (assembler *assembler) evaluate(expression string) (int, bool, error) {
+	var value, isKnown, err = evaluate(expression, assembler.programCounter, assembler.lookup)
+	if err != nil {
+		return 0, false, err
+	}
+	if !isKnown && assembler.isFinalPass {
+		return 0, false, fmt.Errorf("undefined symbol in %q", expression)
+	}
+	return value, isKnown, nil
+}
+
+func (assembler *assembler) define(name string, value int) error {
+	if _, isDefined := assembler.symbols[name]; isDefined && !assembler.isFinalPass {
+		return fmt.Errorf("symbol %s is already defined", name)
+	}
+	assembler.symbols[name] = value
+	return nil
+}
+
+func (assembler *assembler) assembleStatement(statement *statement) error {
+	if statement.label != "" {
+		if err := assembler.define(statement.label, assembler.programCounter); err != nil {
+			return err
+		}
+	}
+	switch {
+	case statement.constant != "":
+		var value, isKnown, err = assembler.evaluate(statement.operand)
+		if err != nil {
+			return err
+		}
+		// Constants referring to later labels are defined by the final pass
+		if isKnown || assembler.isFinalPass {
+			return assembler.define(statement.constant, value)
+		}
+		return nil
+	case statement.directive != "":
+		return assembler.assembleDirective(statement)
+	case statement.mnemonic != "":
+		return assembler.assembleInstruction(statement)
+	default:
+		return nil
+	}
+}
+
+func (assembler *assembler) emit(bytes ...uint8) error {
+	if assembler.programCounter+len(bytes) > 0x10000 {
+		return errors.New("code goes past the end of the address space")
+	}
+	var segment = &assembler.segments[len(assembler.segments)-1]
+	segment.Bytes = append(segment.Bytes, bytes...)
+	assembler.programCounter += len(bytes)
+	return nil
+}
+
+func (assembler *assembler) assembleDirective(statement *statement) error {
+	switch statement.directive {
+	case ".setcpu":
+		return nil
+	case ".org":
+		var origin, isKnown, err = assembler.evaluate(statement.operand)
+		if err != nil {
+			return err
+		}
+		// Addresses of the first pass would all be wrong
+		if !isKnown {
+			return fmt.Errorf("origin %q refers to symbols defined later", statement.operand)
+		}
+		if origin < 0 || origin > 0xFFFF {
+			return fmt.Errorf("origin $%X is out of the address space", origin)
+		}
+		assembler.programCounter = origin
+		assembler.segments = append(assembler.segments, Segment{Origin: uint16(origin)})
+		return nil
+	case ".byte", ".db":
+		for _, argument := range splitArguments(statement.operand) {
+			if len(argument) >= 2 && strings.HasPrefix(argument, "\"") && strings.HasSuffix(argument, "\"") {
+				if err := assembler.emit([]uint8(argument[1 : len(argument)-1])...); err != nil {
+					return err
+				}
+				continue
+			}
+			var value, isKnown, err = assembler.evaluate(argument)
+			if err != nil {
+				return err
+			}
+			if isKnown && (value < -0x80 || value > 0xFF) {
+				return fmt.Errorf("byte value %d is out of range", value)
+			}
+			if err := assembler.emit(uint8(value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ".word", ".dw", ".addr":
+		for _, argument := range splitArguments(statement.operand) {
+			var value, isKnown, err = assembler.evaluate(argument)
+			if err != nil {
+				return err
+			}
+			if isKnown && (value < -0x8000 || value > 0xFFFF) {
+				return fmt.Errorf("word value %d is out of range", value)
+			}
+			if err := assembler.emit(uint8(value), uint8(value>>8)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ".incbin":
+		var path = strings.Trim(statement.operand, "\"")
+		var content, err = assembler.options.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return assembler.emit(content...)
+	default:
+		return fmt.Errorf("unknown directive %s", statement.directive)
+	}
+}
+
+func (assembler *assembler) assembleInstruction(statement *statement) error {
+	if !assembler.isFinalPass {
+		var mode, err = assembler.addressingMode(statement)
+		if err != nil {
+			return err
+		}
+		statement.hexCode = opCodes[statement.mnemonic][mode]
+		var opCode, _ = cpu.DecodeOpCode(statement.hexCode)
+		statement.size = int(opCode.Size())
+	}
+	var opCode, _ = cpu.DecodeOpCode(statement.hexCode)
+	var value int
+	if statement.size > 1 {
+		var isKnown bool
+		var err error
+		value, isKnown, err = assembler.evaluate(operandExpression(statement.operand))
+		if err != nil {
+			return err
+		}
+		if isKnown {
+			if value, err = encodeOperand(opCode.AddressingMode(), value, assembler.programCounter); err != nil {
+				return err
+			}
+		}
+	}
+	switch statement.size {
+	case 1:
+		return assembler.emit(statement.hexCode)
+	case 2:
+		return assembler.emit(statement.hexCode, uint8(value))
+	default:
+		return assembler.emit(statement.hexCode, uint8(value), uint8(value>>8))
+	}
+}
+
+// Checks that the operand fits the addressing mode, branch targets are turned into offsets
+func encodeOperand(mode cpu.AddressingMode, value int, programCounter int) (int, error) {
+	switch mode {
+	case cpu.Relative:
+		var offset = value - (programCounter + 2)
+		if offset < -128 || offset > 127 {
+			return 0, fmt.Errorf("branch target $%04X is out of range", value)
+		}
+		return offset, nil
+	case cpu.Immediate:
+		if value < -0x80 || value > 0xFF {
+			return 0, fmt.Errorf("immediate value %d is out of range", value)
+		}
+	case cpu.ZeroPage, cpu.ZeroPageX, cpu.ZeroPageY, cpu.IndirectX, cpu.IndirectY:
+		if value < 0 || value > 0xFF {
+			return 0, fmt.Errorf("address $%X is not in the zero page", value)
+		}
+	default:
+		if value < 0 || value > 0xFFFF {
+			return 0, fmt.Errorf("address $%X is out of the address space", value)
+		}
+	}
+	return value, nil
+}
+
+// Expression of the operand, without the syntax of its addressing mode
+func operandExpression(operand string) string {
+	var expression = strings.TrimPrefix(operand, "#")
+	var lower = strings.ToLower(strings.ReplaceAll(expression, " ", ""))
+	switch {
+	case strings.HasSuffix(lower, ",x)"):
+		expression = expression[1:strings.LastIndex(expression, ",")]
+	case strings.HasSuffix(lower, "),y"):
+		expression = expression[1:strings.LastIndex(expression, ")")]
+	case strings.HasSuffix(lower, ",x"), strings.HasSuffix(lower, ",y"):
+		expression = expression[:strings.LastIndex(expression, ",")]
+	}
+	expression = strings.TrimSpace(expression)
+	for _, prefix := range []string{"a:", "A:", "z:", "Z:"} {
+		expression = strings.TrimPrefix(expression, prefix)
+	}
+	return expression
+}
+
+func (assembler *assembler) addressingMode(statement *statement) (cpu.AddressingMode, error) {
+	var modes = opCodes[statement.mnemonic]
+	var operand = statement.operand
+	var lower = strings.ToLower(strings.ReplaceAll(operand, " ", ""))
+	var pick = func(candidates ...cpu.AddressingMode) (cpu.AddressingMode, error) {
+		for _, mode := range candidates {
+			if hasMode(modes, mode) {
+				return mode, nil
+			}
+		}
+		return 0, fmt.Errorf("invalid addressing mode for %s %s", statement.mnemonic, operand)
+	}
+
+	switch {
+	case lower == "" || lower == "a":
+		return pick(cpu.Implied, cpu.Accumulator)
+	case strings.HasPrefix(lower, "#"):
+		return pick(cpu.Immediate)
+	case isBranch(statement.mnemonic):
+		return pick(cpu.Relative)
+	case strings.HasPrefix(lower, "(") && strings.HasSuffix(lower, ",x)"):
+		return pick(cpu.IndirectX)
+	case strings.HasPrefix(lower, "(") && strings.HasSuffix(lower, "),y"):
+		return pick(cpu.IndirectY)
+	case strings.HasPrefix(lower, "(") && strings.HasSuffix(lower, ")") && hasMode(modes, cpu.Indirect):
+		return pick(cpu.Indirect)
+	}
+
+	// Zero page addressing is used when the address is known to fit, unless a: forces absolute addressing
+	var value, isKnown, err = assembler.evaluate(operandExpression(operand))
+	if err != nil {
+		return 0, err
+	}
+	var expression = strings.TrimSpace(strings.TrimPrefix(operand, "#"))
+	var isZeroPage = isKnown && value >= 0 && value <= 0xFF && !strings.HasPrefix(strings.ToLower(expression), "a:")
+	if strings.HasPrefix(strings.ToLower(expression), "z:") {
+		isZeroPage = true
+	}
+	switch {
+	case strings.HasSuffix(lower, ",x"):
+		if isZeroPage {
+			return pick(cpu.ZeroPageX, cpu.AbsoluteX)
+		}
+		return pick(cpu.AbsoluteX)
+	case strings.HasSuffix(lower, ",y"):
+		if isZeroPage {
+			return pick(cpu.ZeroPageY, cpu.AbsoluteY)
+		}
+		return pick(cpu.AbsoluteY)
+	default:
+		if isZeroPage {
+			return pick(cpu.ZeroPage, cpu.Absolute)
+		}
+		return pick(cpu.Absolute)
+	}
+}
+
+func hasMode(modes map[cpu.AddressingMode]uint8, mode cpu.AddressingMode) bool {
+	var _, isDefined = modes[mode]
+	return isDefined
+}
+
+func (assembler *assembler) program() (*Program, error) {
+	var segments []Segment
+	for _, segment := range assembler.segments {
+		if len(segment.Bytes) > 0 {
+			segments = append(segments, segment)
+		}
+	}
+	sort.SliceStable(segments, func(i, j int) bool { return segments[i].Origin < segments[j].Origin })
+	for i := 1; i < len(segments); i++ {
+		if int(segments[i-1].Origin)+len(segments[i-1].Bytes) > int(segments[i].Origin) {
+			return nil, fmt.Errorf("code at $%04X overlaps code at $%04X", segments[i].Origin, segments[i-1].Origin)
+		}
+	}
+	return &Program{Segments: segments, Symbols: assembler.symbols}, nil
+}
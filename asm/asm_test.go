@@ -0,0 +1,234 @@
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"nes-emulator/bus"
+	"nes-emulator/disasm"
+	"os"
+	"testing"
+)
+
+func assemble(t *testing.T, source string) []uint8 {
+	var program, err = Assemble(source, Options{ReadFile: readTestFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bytes, _ = program.Bytes(0xFF)
+	return bytes
+}
+
+// Files of the .incbin directives
+func readTestFile(path string) ([]byte, error) {
+	if path == "data.bin" {
+		return []byte{0xDE, 0xAD}, nil
+	}
+	return nil, errors.New("no such file " + path)
+}
+
+func TestAssemble(t *testing.T) {
+	var tests = []struct {
+		name   string
+		source string
+		bytes  []uint8
+	}{
+		// Addressing modes
+		{"implied", "NOP", []uint8{0xEA}},
+		{"accumulator", "ASL A\nASL", []uint8{0x0A, 0x0A}},
+		{"immediate", "LDA #$10", []uint8{0xA9, 0x10}},
+		{"zero page", "LDA $10", []uint8{0xA5, 0x10}},
+		{"zero page of a 16-bit number", "LDA $0010", []uint8{0xA5, 0x10}},
+		{"absolute", "LDA $1234", []uint8{0xAD, 0x34, 0x12}},
+		{"absolute forced by a:", "LDA a:$10", []uint8{0xAD, 0x10, 0x00}},
+		{"zero page forced by z:", "LDA z:$10", []uint8{0xA5, 0x10}},
+		{"zero page,X", "LDA $10,X", []uint8{0xB5, 0x10}},
+		{"absolute,X forced by a:", "LDA a:$10,x", []uint8{0xBD, 0x10, 0x00}},
+		{"zero page,Y", "LDX $10,Y", []uint8{0xB6, 0x10}},
+		{"absolute,Y without zero page,Y", "LDA $10,Y", []uint8{0xB9, 0x10, 0x00}},
+		{"(zp),Y", "LDA ($10),Y", []uint8{0xB1, 0x10}},
+		{"(zp),Y with spaces", "lda ( $10 ), y", []uint8{0xB1, 0x10}},
+		{"(zp,X)", "LDA ($10,X)", []uint8{0xA1, 0x10}},
+		{"(ind)", "JMP ($1234)", []uint8{0x6C, 0x34, 0x12}},
+		{"parenthesized absolute", "JMP ($1200+$34)+1", []uint8{0x4C, 0x35, 0x12}},
+		{"zero page constant", "PTR = $20\nLDA (PTR),Y", []uint8{0xB1, 0x20}},
+		{"unofficial", "LAX $10\nDCP $1234,X", []uint8{0xA7, 0x10, 0xDF, 0x34, 0x12}},
+		{"unofficial alias", "SAX $10", []uint8{0x87, 0x10}},
+		// Forward references are assembled as absolute addresses
+		{"forward reference", "LDA later\nlater:", []uint8{0xAD, 0x03, 0x80}},
+		{"forward reference,X", "STA later,X\nlater:", []uint8{0x9D, 0x03, 0x80}},
+		{"constant of a later label", "TARGET = later+1\nJMP TARGET\nlater: NOP", []uint8{0x4C, 0x04, 0x80, 0xEA}},
+		{"backward branch", "loop: BNE loop", []uint8{0xD0, 0xFE}},
+		{"forward branch", "BEQ next\nNOP\nnext: RTS", []uint8{0xF0, 0x01, 0xEA, 0x60}},
+		{"branch to *", "BCC *+2", []uint8{0x90, 0x00}},
+		// Data
+		{".byte", ".byte 1, $FF, \"A;B\", 'c', -1 ; comment", []uint8{0x01, 0xFF, 'A', ';', 'B', 'c', 0xFF}},
+		{".word", ".word $1234, later\nlater:", []uint8{0x34, 0x12, 0x04, 0x80}},
+		{".incbin", ".incbin \"data.bin\"\n.byte 0", []uint8{0xDE, 0xAD, 0x00}},
+		{"gap between .org", ".org $8000\n.byte 1\n.org $8003\n.byte 2", []uint8{0x01, 0xFF, 0xFF, 0x02}},
+		{"low and high bytes of a label", "LDA #<later\nLDX #>later\n.org $9234\nlater:", []uint8{0xA9, 0x34, 0xA2, 0x92}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if assembled := assemble(t, test.source); !bytes.Equal(assembled, test.bytes) {
+				t.Errorf("assembled % X, want % X", assembled, test.bytes)
+			}
+		})
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	var tests = []struct {
+		name   string
+		source string
+	}{
+		{"branch out of range", ".org $8000\nBNE far\n.org $8100\nfar: NOP"},
+		{"backward branch out of range", "back: NOP\n.org $8100\nBEQ back"},
+		{"(zp),Y outside of the zero page", "LDA ($1234),Y"},
+		{"immediate out of range", "LDA #$100"},
+		{"byte out of range", ".byte 256"},
+		{"word out of range", ".word $10000"},
+		{"undefined symbol", "LDA nowhere"},
+		{"label defined twice", "here: NOP\nhere: NOP"},
+		{"invalid addressing mode", "STA #$10"},
+		{"unknown instruction", "FOO $10"},
+		{"unknown directive", ".foo"},
+		{"missing .incbin file", ".incbin \"missing.bin\""},
+		{"origin defined later", ".org start\nstart: NOP"},
+		{"overlapping code", ".org $8000\n.byte 1, 2\n.org $8001\n.byte 3"},
+		{"past the end of the address space", ".org $FFFF\n.word 0"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Assemble(test.source, Options{ReadFile: readTestFile}); err == nil {
+				t.Errorf("assembled without error")
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	var symbols = map[string]int{"label": 0x1234}
+	var lookup = func(name string) (int, bool) {
+		var value, isDefined = symbols[name]
+		return value, isDefined
+	}
+	var tests = []struct {
+		expression string
+		value      int
+	}{
+		{"1+2*3", 7},
+		{"(1+2)*3", 9},
+		{"10-2-3", 5},
+		{"1|2&3", 3},
+		{"6^3&1", 7},
+		{"1<<4|1", 17},
+		{"1+1<<2", 8},
+		{"$100>>4", 0x10},
+		{"<label", 0x34},
+		{">label", 0x12},
+		{"<label+1", 0x35},
+		{">label+1", 0x13},
+		{"<(label+$CC)", 0x00},
+		{"-1", -1},
+		{"~0&$FF", 0xFF},
+		{"%1010", 10},
+		{"'A'", 0x41},
+		{"*+2", 0xC002},
+		{"label / 2", 0x091A},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			var value, isKnown, err = evaluate(test.expression, 0xC000, lookup)
+			if err != nil || !isKnown || value != test.value {
+				t.Errorf("= %d, %v, %v, want %d", value, isKnown, err, test.value)
+			}
+		})
+	}
+	if _, isKnown, err := evaluate("later+1", 0xC000, lookup); err != nil || isKnown {
+		t.Errorf("symbol not defined yet reported as known, %v", err)
+	}
+	for _, expression := range []string{"1+", "(1", "1/0", "$G", "1 2"} {
+		if _, _, err := evaluate(expression, 0xC000, lookup); err == nil {
+			t.Errorf("%q evaluated without error", expression)
+		}
+	}
+}
+
+func TestINesImage(t *testing.T) {
+	var tests = []struct {
+		name          string
+		source        string
+		numberOfBanks int
+	}{
+		{"single bank", ".org $C000\nreset: JMP reset\n.org $FFFA\n.word reset, reset, reset", 1},
+		{"two banks", ".org $8000\nreset: JMP reset\n.org $FFFA\n.word reset, reset, reset", 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var program, err = Assemble(test.source, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var image, errorImage = program.INesImage()
+			if errorImage != nil {
+				t.Fatal(errorImage)
+			}
+			var rom, errorParse = bus.ParseRawRom(image)
+			if errorParse != nil {
+				t.Fatal(errorParse)
+			}
+			var prgRom = rom.PrgRom()
+			if len(prgRom) != test.numberOfBanks*bus.PRG_ROM_PAGE_SIZE {
+				t.Fatalf("PRG ROM of %d bytes", len(prgRom))
+			}
+			var reset = program.Symbols["reset"]
+			var start = reset - (0x10000 - len(prgRom))
+			if !bytes.Equal(prgRom[start:start+3], []uint8{0x4C, uint8(reset), uint8(reset >> 8)}) {
+				t.Errorf("JMP not at $%04X : % X", reset, prgRom[start:start+3])
+			}
+			if !bytes.Equal(prgRom[len(prgRom)-2:], []uint8{uint8(reset), uint8(reset >> 8)}) {
+				t.Errorf("IRQ vector % X", prgRom[len(prgRom)-2:])
+			}
+			if prgRom[start+3] != PRG_ROM_FILL {
+				t.Errorf("unset byte $%02X", prgRom[start+3])
+			}
+		})
+	}
+	for _, source := range []string{"", ".org $6000\nNOP"} {
+		var program, err = Assemble(source, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := program.INesImage(); err == nil {
+			t.Errorf("image of %q made without error", source)
+		}
+	}
+}
+
+// The source written by the disassembler assembles back to the same bytes
+func TestDisassemblyRoundTrip(t *testing.T) {
+	var raw, err = os.ReadFile("../resources/nestest.nes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rom, errorParse = bus.ParseRawRom(raw)
+	if errorParse != nil {
+		t.Fatal(errorParse)
+	}
+	var bank, origin, errorBank = disasm.PrgBank(rom, disasm.NumberOfPrgBanks(rom)-1)
+	if errorBank != nil {
+		t.Fatal(errorBank)
+	}
+	var source bytes.Buffer
+	if err := disasm.DisassemblePrgBank(&source, rom, disasm.NumberOfPrgBanks(rom)-1, nil); err != nil {
+		t.Fatal(err)
+	}
+	var program, errorAssemble = Assemble(source.String(), Options{})
+	if errorAssemble != nil {
+		t.Fatal(errorAssemble)
+	}
+	var assembled, assembledOrigin = program.Bytes(0xFF)
+	if assembledOrigin != origin || !bytes.Equal(assembled, bank) {
+		t.Errorf("assembled %d bytes at $%04X, want the %d bytes of the bank at $%04X", len(assembled), assembledOrigin, len(bank), origin)
+	}
+}
@@ -0,0 +1,223 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Expressions follow the ca65 syntax : https://cc65.github.io/doc/ca65.html#expressions
+// Numbers are $hex, %binary, decimal or 'c', * is the address of the current statement
+// Operators from the lowest precedence : |, ^, &, << >>, + -, * /, then the unary - ~ < (low byte) > (high byte)
+
+type expressionParser struct {
+	text  string
+	index int
+	// Resolves a symbol, the second value is false if it is not defined yet
+	lookup         func(name string) (int, bool)
+	programCounter int
+	// Set when a symbol is not defined yet : the value is then meaningless
+	isUnknown bool
+}
+
+// Value of the expression, known is false when it refers to symbols not defined yet
+func evaluate(text string, programCounter int, lookup func(name string) (int, bool)) (int, bool, error) {
+	var parser = expressionParser{text: text, lookup: lookup, programCounter: programCounter}
+	var value, err = parser.parseBinary(0)
+	if err != nil {
+		return 0, false, err
+	}
+	parser.skipSpaces()
+	if parser.index < len(parser.text) {
+		return 0, false, fmt.Errorf("unexpected %q in expression %q", parser.text[parser.index:], text)
+	}
+	return value, !parser.isUnknown, nil
+}
+
+var binaryOperators = [][]string{
+	{"|"},
+	{"^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/"},
+}
+
+func (parser *expressionParser) skipSpaces() {
+	for parser.index < len(parser.text) && (parser.text[parser.index] == ' ' || parser.text[parser.index] == '\t') {
+		parser.index++
+	}
+}
+
+func (parser *expressionParser) nextOperator(operators []string) string {
+	parser.skipSpaces()
+	for _, operator := range operators {
+		if strings.HasPrefix(parser.text[parser.index:], operator) {
+			return operator
+		}
+	}
+	return ""
+}
+
+func (parser *expressionParser) parseBinary(level int) (int, error) {
+	if level == len(binaryOperators) {
+		return parser.parseUnary()
+	}
+	var left, err = parser.parseBinary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		var operator = parser.nextOperator(binaryOperators[level])
+		if operator == "" {
+			return left, nil
+		}
+		parser.index += len(operator)
+		var right, err = parser.parseBinary(level + 1)
+		if err != nil {
+			return 0, err
+		}
+		switch operator {
+		case "|":
+			left |= right
+		case "^":
+			left ^= right
+		case "&":
+			left &= right
+		case "<<":
+			left <<= uint(right)
+		case ">>":
+			left >>= uint(right)
+		case "+":
+			left += right
+		case "-":
+			left -= right
+		case "*":
+			left *= right
+		case "/":
+			if right == 0 {
+				if parser.isUnknown {
+					return 0, nil
+				}
+				return 0, fmt.Errorf("division by zero in expression %q", parser.text)
+			}
+			left /= right
+		}
+	}
+}
+
+func (parser *expressionParser) parseUnary() (int, error) {
+	parser.skipSpaces()
+	if parser.index >= len(parser.text) {
+		return 0, fmt.Errorf("missing value in expression %q", parser.text)
+	}
+	var operator = parser.text[parser.index]
+	switch operator {
+	case '-', '~', '<', '>', '+':
+		parser.index++
+		var value, err = parser.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		switch operator {
+		case '-':
+			return -value, nil
+		case '~':
+			return ^value, nil
+		case '<':
+			return value & 0xFF, nil
+		case '>':
+			return (value >> 8) & 0xFF, nil
+		default:
+			return value, nil
+		}
+	default:
+		return parser.parsePrimary()
+	}
+}
+
+func (parser *expressionParser) parsePrimary() (int, error) {
+	var start = parser.index
+	var character = parser.text[parser.index]
+	switch {
+	case character == '(':
+		parser.index++
+		var value, err = parser.parseBinary(0)
+		if err != nil {
+			return 0, err
+		}
+		parser.skipSpaces()
+		if parser.index >= len(parser.text) || parser.text[parser.index] != ')' {
+			return 0, fmt.Errorf("missing ) in expression %q", parser.text)
+		}
+		parser.index++
+		return value, nil
+	case character == '*':
+		parser.index++
+		return parser.programCounter, nil
+	case character == '\'':
+		if parser.index+2 >= len(parser.text) || parser.text[parser.index+2] != '\'' {
+			return 0, fmt.Errorf("invalid character in expression %q", parser.text)
+		}
+		parser.index += 3
+		return int(parser.text[start+1]), nil
+	case character == '$' || character == '%':
+		parser.index++
+		var base = 16
+		if character == '%' {
+			base = 2
+		}
+		for parser.index < len(parser.text) && isAlphanumeric(parser.text[parser.index]) {
+			parser.index++
+		}
+		var value, err = strconv.ParseInt(parser.text[start+1:parser.index], base, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", parser.text[start:parser.index])
+		}
+		return int(value), nil
+	case character >= '0' && character <= '9':
+		for parser.index < len(parser.text) && isAlphanumeric(parser.text[parser.index]) {
+			parser.index++
+		}
+		var value, err = strconv.ParseInt(parser.text[start:parser.index], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", parser.text[start:parser.index])
+		}
+		return int(value), nil
+	case isIdentifierStart(character):
+		for parser.index < len(parser.text) && isIdentifierPart(parser.text[parser.index]) {
+			parser.index++
+		}
+		var value, isKnown = parser.lookup(parser.text[start:parser.index])
+		if !isKnown {
+			parser.isUnknown = true
+		}
+		return value, nil
+	default:
+		return 0, fmt.Errorf("unexpected %q in expression %q", parser.text[start:], parser.text)
+	}
+}
+
+func isIdentifierStart(character byte) bool {
+	return character == '_' || character == '@' || (character >= 'a' && character <= 'z') || (character >= 'A' && character <= 'Z')
+}
+
+func isIdentifierPart(character byte) bool {
+	return isIdentifierStart(character) || (character >= '0' && character <= '9')
+}
+
+func isAlphanumeric(character byte) bool {
+	return isIdentifierPart(character)
+}
+
+func isIdentifier(text string) bool {
+	if text == "" || !isIdentifierStart(text[0]) {
+		return false
+	}
+	for i := 1; i < len(text); i++ {
+		if !isIdentifierPart(text[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,37 @@
+package asm
+
+import (
+	"errors"
+	"nes-emulator/bus"
+)
+
+// https://www.nesdev.org/wiki/INES
+const INES_HEADER_SIZE int = 16
+
+// Value of the PRG ROM bytes the program does not set
+const PRG_ROM_FILL uint8 = 0xFF
+
+// iNES image of a NROM cartridge (mapper 0) holding the program, with CHR RAM
+// The program must fit in $8000-$FFFF and define its own vectors
+// It takes a single bank when it fits in $C000-$FFFF, which is then mirrored at $8000
+func (program *Program) INesImage() ([]uint8, error) {
+	var bytes, origin = program.Bytes(PRG_ROM_FILL)
+	if len(bytes) == 0 {
+		return nil, errors.New("program is empty")
+	}
+	var numberOfBanks = 2
+	if origin >= 0xC000 {
+		numberOfBanks = 1
+	} else if origin < 0x8000 {
+		return nil, errors.New("program does not fit in the PRG ROM ($8000-$FFFF)")
+	}
+	var prgRomSize = numberOfBanks * bus.PRG_ROM_PAGE_SIZE
+	var image = make([]uint8, INES_HEADER_SIZE+prgRomSize)
+	copy(image, []uint8{'N', 'E', 'S', 0x1A, uint8(numberOfBanks), 0})
+	var prgRom = image[INES_HEADER_SIZE:]
+	for i := range prgRom {
+		prgRom[i] = PRG_ROM_FILL
+	}
+	copy(prgRom[int(origin)-(0x10000-prgRomSize):], bytes)
+	return image, nil
+}
@@ -0,0 +1,65 @@
+package asm
+
+import (
+	"nes-emulator/cpu"
+	"strings"
+)
+
+// Opcodes by mnemonic and addressing mode, built from the opcode table of the CPU
+// Official opcodes take precedence : NOP and SBC #imm assemble to $EA and $E9
+var opCodes = buildOpCodes()
+
+// Other names of the unofficial operations : https://www.nesdev.org/wiki/CPU_unofficial_opcodes
+var operationAliases = map[string]string{
+	"ANC": "AAC",
+	"SAX": "AAX",
+	"ALR": "ASR",
+	"LXA": "ATX",
+	"AHX": "AXA",
+	"SHA": "AXA",
+	"SBX": "AXS",
+	"DCM": "DCP",
+	"ISB": "ISC",
+	"INS": "ISC",
+	"JAM": "KIL",
+	"HLT": "KIL",
+	"LAS": "LAR",
+	"SHX": "SXA",
+	"SHY": "SYA",
+	"ANE": "XAA",
+	"TAS": "XAS",
+	"SHS": "XAS",
+}
+
+func buildOpCodes() map[string]map[cpu.AddressingMode]uint8 {
+	var table = make(map[string]map[cpu.AddressingMode]uint8)
+	var add = func(mnemonic string, mode cpu.AddressingMode, hexCode uint8) {
+		if table[mnemonic] == nil {
+			table[mnemonic] = make(map[cpu.AddressingMode]uint8)
+		}
+		if _, isDefined := table[mnemonic][mode]; !isDefined {
+			table[mnemonic][mode] = hexCode
+		}
+	}
+	for _, isUnofficialPass := range []bool{false, true} {
+		for hexCode := 0; hexCode <= 0xFF; hexCode++ {
+			var opCode, isKnown = cpu.DecodeOpCode(uint8(hexCode))
+			if !isKnown || opCode.IsUnofficial() != isUnofficialPass {
+				continue
+			}
+			var operation = strings.TrimPrefix(string(opCode.Operation()), "*")
+			add(operation, opCode.AddressingMode(), uint8(hexCode))
+			// DOP and TOP are also written as NOP with an operand
+			add(opCode.Mnemonic(), opCode.AddressingMode(), uint8(hexCode))
+		}
+	}
+	for alias, operation := range operationAliases {
+		table[alias] = table[operation]
+	}
+	return table
+}
+
+func isBranch(mnemonic string) bool {
+	var _, isRelative = opCodes[mnemonic][cpu.Relative]
+	return isRelative
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"nes-emulator/asm"
+	"os"
+)
+
+func runAsmCommand(arguments []string) error {
+	var flags = flag.NewFlagSet("asm", flag.ExitOnError)
+	var outputPath = flags.String("o", "", "path of the assembled file")
+	var isINes = flags.Bool("ines", false, "write an iNES ROM (NROM, CHR RAM) instead of raw bytes")
+	var fill = flags.Uint("fill", 0xFF, "value of the bytes between two .org blocks of raw output")
+	flags.Parse(arguments)
+	if flags.NArg() != 1 || *outputPath == "" {
+		return errors.New("usage: asm -o output [-ines] source.s")
+	}
+
+	var program, errorAssemble = asm.AssembleFile(flags.Arg(0))
+	if errorAssemble != nil {
+		return errorAssemble
+	}
+	var output, origin = program.Bytes(uint8(*fill))
+	if *isINes {
+		var errorImage error
+		if output, errorImage = program.INesImage(); errorImage != nil {
+			return errorImage
+		}
+	}
+	if errorWrite := os.WriteFile(*outputPath, output, 0644); errorWrite != nil {
+		return errorWrite
+	}
+	fmt.Println(fmt.Sprintf("Assembled %d bytes from $%04X in %s", len(output), origin, *outputPath))
+	return nil
+}
@@ -3,33 +3,76 @@ package bus
 import (
 	"encoding/binary"
 	"fmt"
+	"nes-emulator/apu"
+	"nes-emulator/controller"
+	"nes-emulator/savestate"
 )
 
 const CPU_RAM_START uint16 = 0x0000
 const CPU_RAM_MIRRORS_END uint16 = 0x1FFF
+
+// 2 KiB of RAM, mirrored up to CPU_RAM_MIRRORS_END
+const CPU_RAM_SIZE uint16 = 0x0800
 const PPU_REGISTERS_START uint16 = 0x2000
 const PPU_REGISTERS_MIRRORS_END uint16 = 0x3FFF
-const PRG_ROM_START uint16 = 0x8000
-const PRG_ROM_END uint16 = 0xFFFF
+const APU_REGISTERS_START uint16 = 0x4000
+const APU_REGISTERS_END uint16 = 0x4013
+
+// Writes start the OAM DMA of the PPU
+const OAM_DMA uint16 = 0x4014
+const APU_STATUS uint16 = 0x4015
+const APU_FRAME_COUNTER uint16 = 0x4017
+
+// Writes to $4017 go to the APU frame counter, reads come from the second controller port
+const CONTROLLER_PORT_1 uint16 = 0x4016
+const CONTROLLER_PORT_2 uint16 = 0x4017
+
+// APU and I/O test registers, disabled on retail consoles
+const TEST_REGISTERS_START uint16 = 0x4018
+const TEST_REGISTERS_END uint16 = 0x401F
+const CARTRIDGE_START uint16 = 0x4020
+const CARTRIDGE_END uint16 = 0xFFFF
+
+// Number of CPU cycles stolen by a DMC sample fetch
+const DMC_DMA_CYCLES int = 4
 
 type Bus struct {
-	rom    *Rom
-	memory [0xffff]uint8
+	cartridge Cartridge
+	apu       *apu.APU
+	// Empty ports are nil
+	controllers [controller.NUMBER_OF_PORTS]controller.Device
+	memory      [0x10000]uint8
 	// More info on memory structure here : https://www.nesdev.org/wiki/CPU_memory_map
+	// Last value driven on the data bus, returned when reading write-only registers
+	// More info here : https://www.nesdev.org/wiki/Open_bus_behavior
+	openBus uint8
+	// Callbacks on accesses, see hooks.go
+	hooks hooks
+	// CPU cycles stolen by DMA, which the CPU must wait for
+	dmaStallCycles int
 }
 
 // Memory helpers
 
-func (bus *Bus) readPrgROM(address uint16) uint8 {
-	var unmirroredAddress = address - 0x8000
-	// Unmirroring if prgRom is of 16 KiB (we map 32 KiB addressing space)
-	if len(bus.rom.prgRom) == 0x4000 && address >= 0x4000 {
-		unmirroredAddress = address % 0x4000
+func (bus *Bus) MemoryRead(address uint16) uint8 {
+	var data = bus.memoryRead(address)
+	bus.openBus = data
+	if bus.hooks.isHooked(address, ACCESS_READ) {
+		bus.hooks.call(address, data, ACCESS_READ)
+	}
+	return data
+}
+
+// Read of an opcode by the CPU, which also runs the execute hooks
+func (bus *Bus) FetchOpCode(address uint16) uint8 {
+	var data = bus.MemoryRead(address)
+	if bus.hooks.isHooked(address, ACCESS_EXECUTE) {
+		bus.hooks.call(address, data, ACCESS_EXECUTE)
 	}
-	return bus.rom.prgRom[unmirroredAddress]
+	return data
 }
 
-func (bus *Bus) MemoryRead(address uint16) uint8 {
+func (bus *Bus) memoryRead(address uint16) uint8 {
 	var unmirroredAddress uint16
 	switch {
 	case CPU_RAM_START <= address && address <= CPU_RAM_MIRRORS_END:
@@ -38,22 +81,73 @@ func (bus *Bus) MemoryRead(address uint16) uint8 {
 	case PPU_REGISTERS_START <= address && address <= PPU_REGISTERS_MIRRORS_END:
 		unmirroredAddress = address & 0b00100000_00000111
 		return bus.memory[unmirroredAddress]
-	case PRG_ROM_START <= address && address <= PRG_ROM_END:
-		return bus.readPrgROM(address)
+	case APU_REGISTERS_START <= address && address <= APU_REGISTERS_END:
+		// APU channel registers are write-only
+		return bus.openBus
+	case address == OAM_DMA, TEST_REGISTERS_START <= address && address <= TEST_REGISTERS_END:
+		// Write-only or disabled : nothing drives the data bus
+		return bus.openBus
+	case address == APU_STATUS:
+		// Bit 5 is not driven by the APU
+		return bus.apu.ReadStatus() | (bus.openBus & 0b0010_0000)
+	case address == CONTROLLER_PORT_1:
+		return bus.readController(controller.PORT_1)
+	case address == CONTROLLER_PORT_2:
+		return bus.readController(controller.PORT_2)
+	case CARTRIDGE_START <= address && address <= CARTRIDGE_END:
+		if data, isDriven := bus.cartridge.Read(address); isDriven {
+			return data
+		}
+		return bus.openBus
 	default:
 		panic(fmt.Sprintf("Unsupported address %v", address))
 	}
 }
 
+// Value MemoryRead would return, without any side effect : the open bus, the hooks and the state of the devices
+// are left untouched, so that debuggers and tracers do not disturb the emulation
+func (bus *Bus) Peek(address uint16) uint8 {
+	switch {
+	case address == APU_STATUS:
+		return bus.apu.PeekStatus() | (bus.openBus & 0b0010_0000)
+	case address == CONTROLLER_PORT_1:
+		return bus.peekController(controller.PORT_1)
+	case address == CONTROLLER_PORT_2:
+		return bus.peekController(controller.PORT_2)
+	case CARTRIDGE_START <= address && address <= CARTRIDGE_END:
+		if data, isDriven := bus.cartridge.Peek(address); isDriven {
+			return data
+		}
+		return bus.openBus
+	default:
+		// RAM, PPU registers and open bus
+		return bus.memoryRead(address)
+	}
+}
+
 func (bus *Bus) MemoryWrite(address uint16, data uint8) {
+	bus.openBus = data
+	if bus.hooks.isHooked(address, ACCESS_WRITE) {
+		bus.hooks.call(address, data, ACCESS_WRITE)
+	}
 	var unmirroredAddress uint16
 	switch {
 	case CPU_RAM_START <= address && address <= CPU_RAM_MIRRORS_END:
 		unmirroredAddress = address & 0b00000111_11111111
 	case PPU_REGISTERS_START <= address && address <= PPU_REGISTERS_MIRRORS_END:
 		unmirroredAddress = address & 0b00100000_00000111
-	case PRG_ROM_START <= address && address <= PRG_ROM_END:
-		panic(fmt.Sprintf("Trying to write to address %v in PRG ROM", address))
+	case APU_REGISTERS_START <= address && address <= APU_REGISTERS_END, address == APU_STATUS, address == APU_FRAME_COUNTER:
+		bus.apu.WriteRegister(address, data)
+		return
+	case address == CONTROLLER_PORT_1:
+		bus.writeControllerStrobe(data&0b0000_0001 != 0)
+		return
+	case address == OAM_DMA, TEST_REGISTERS_START <= address && address <= TEST_REGISTERS_END:
+		// There is no PPU to copy sprites to yet, and the test registers are disabled
+		return
+	case CARTRIDGE_START <= address && address <= CARTRIDGE_END:
+		bus.cartridge.Write(address, data)
+		return
 	default:
 		panic(fmt.Sprintf("Unsupported address %v", address))
 	}
@@ -61,14 +155,13 @@ func (bus *Bus) MemoryWrite(address uint16, data uint8) {
 	bus.memory[unmirroredAddress] = data
 }
 
-// TODO : Some edge case here !
-// What if address is CPU_RAM_MIRRORS_END ?? This will bug a lot
+// 16-bit values are little endian, each byte goes through MemoryRead and MemoryWrite on its own :
+// across a region boundary ($07FF/$0800, $1FFF/$2000...) each byte gets the mirroring of its region,
+// and the high byte of $FFFF is read from $0000
 func (bus *Bus) MemoryReadU16(address uint16) uint16 {
 	return binary.LittleEndian.Uint16([]uint8{bus.MemoryRead(address), bus.MemoryRead(address + 1)})
 }
 
-// TODO : Some edge case here !
-// What if address is CPU_RAM_MIRRORS_END ?? This will bug a lot
 func (bus *Bus) MemoryWriteU16(address uint16, data uint16) {
 	bytes := make([]uint8, 2)
 	binary.LittleEndian.PutUint16(bytes, data)
@@ -76,13 +169,99 @@ func (bus *Bus) MemoryWriteU16(address uint16, data uint16) {
 	bus.MemoryWrite(address+1, bytes[1])
 }
 
-func NewBus() Bus {
+// Controllers
+
+func (bus *Bus) ConnectController(port int, device controller.Device) {
+	bus.controllers[port] = device
+}
+
+func (bus *Bus) readController(port int) uint8 {
+	// https://www.nesdev.org/wiki/Standard_controller#Output_($4016/$4017_read)
+	var data = bus.openBus &^ controller.DATA_LINES_MASK
+	if bus.controllers[port] != nil {
+		data |= bus.controllers[port].Read() & controller.DATA_LINES_MASK
+	}
+	return data
+}
+
+func (bus *Bus) peekController(port int) uint8 {
+	var data = bus.openBus &^ controller.DATA_LINES_MASK
+	if bus.controllers[port] != nil {
+		data |= bus.controllers[port].Peek() & controller.DATA_LINES_MASK
+	}
+	return data
+}
+
+func (bus *Bus) writeControllerStrobe(isStrobing bool) {
+	for _, device := range bus.controllers {
+		if device != nil {
+			device.WriteStrobe(isStrobing)
+		}
+	}
+}
+
+func NewBus(consoleAPU *apu.APU) Bus {
 	return Bus{
-		memory: [0xffff]uint8{},
+		apu:    consoleAPU,
+		memory: [0x10000]uint8{},
 	}
 }
 
+// Fills the RAM with the given pattern and clears the state of the data bus, as after a power cycle
+func (bus *Bus) PowerOn(ramFill RamFill, seed int64) {
+	bus.memory = [0x10000]uint8{}
+	ramFill.fill(bus.memory[CPU_RAM_START:CPU_RAM_SIZE], seed)
+	bus.openBus = 0
+	bus.dmaStallCycles = 0
+}
+
 func (bus *Bus) LoadRom(rom *Rom) {
-	bus.rom = rom
+	bus.LoadCartridge(rom)
+}
+
+func (bus *Bus) LoadCartridge(cartridge Cartridge) {
+	bus.cartridge = cartridge
+}
+
+// The cartridge is saved separately, as it is swapped when loading another game
+func (bus *Bus) SerializeState(state *savestate.Serializer) {
+	state.Bytes(bus.memory[:])
+	state.Uint8(&bus.openBus)
+	state.Int(&bus.dmaStallCycles)
+}
+
+// Clocking
+
+// Advances the devices connected to the bus by the number of CPU cycles elapsed
+func (bus *Bus) Tick(cycles int) {
+	for i := 0; i < cycles; i++ {
+		bus.apu.Clock()
+		if address, isNeeded := bus.apu.PendingDmcRead(); isNeeded {
+			// https://www.nesdev.org/wiki/APU_DMC#Memory_reader
+			bus.apu.LoadDmcSample(bus.dmaRead(address))
+			bus.dmaStallCycles += DMC_DMA_CYCLES
+		}
+	}
+}
+
+// Read of a DMC sample, which also runs the DMA hooks
+func (bus *Bus) dmaRead(address uint16) uint8 {
+	var data = bus.MemoryRead(address)
+	if bus.hooks.isHooked(address, ACCESS_DMA) {
+		bus.hooks.call(address, data, ACCESS_DMA)
+	}
+	return data
+}
+
+// Returns the CPU cycles stolen by DMA since the last call
+func (bus *Bus) TakeDmaStallCycles() int {
+	var cycles = bus.dmaStallCycles
+	bus.dmaStallCycles = 0
+	return cycles
+}
+
+// Interrupts
 
+func (bus *Bus) IsIRQPending() bool {
+	return bus.apu.IsIRQPending()
 }
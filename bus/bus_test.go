@@ -0,0 +1,242 @@
+package bus
+
+import (
+	"nes-emulator/apu"
+	"nes-emulator/controller"
+	"nes-emulator/savestate"
+	"testing"
+)
+
+// Cartridge backed by the whole cartridge space, to check what reaches it
+type testCartridge struct {
+	memory [0x10000]uint8
+}
+
+func (cartridge *testCartridge) Read(address uint16) (uint8, bool) {
+	return cartridge.memory[address], true
+}
+
+func (cartridge *testCartridge) Write(address uint16, data uint8) {
+	cartridge.memory[address] = data
+}
+
+func (cartridge *testCartridge) Peek(address uint16) (uint8, bool) {
+	return cartridge.memory[address], true
+}
+
+func (cartridge *testCartridge) SerializeState(state *savestate.Serializer) {
+}
+
+func newTestBus() (*Bus, *testCartridge) {
+	var consoleAPU = apu.NewAPU()
+	consoleAPU.PowerOn()
+	var bus = NewBus(&consoleAPU)
+	var cartridge = &testCartridge{}
+	bus.LoadCartridge(cartridge)
+	return &bus, cartridge
+}
+
+func TestMirroring(t *testing.T) {
+	var tests = []struct {
+		name    string
+		written uint16
+		read    uint16
+	}{
+		{"RAM", 0x0000, 0x0000},
+		{"RAM first mirror", 0x0012, 0x0812},
+		{"RAM second mirror", 0x0012, 0x1012},
+		{"RAM last mirror", 0x07FF, 0x1FFF},
+		{"RAM written through a mirror", 0x1834, 0x0034},
+		{"PPU registers", 0x2002, 0x2002},
+		{"PPU registers first mirror", 0x2003, 0x200B},
+		{"PPU registers last mirror", 0x2007, 0x3FFF},
+		{"PPU registers written through a mirror", 0x3FF9, 0x2001},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var bus, _ = newTestBus()
+			bus.MemoryWrite(test.written, 0x5A)
+			// Drive another value on the data bus, so that open bus can not pass for the mirror
+			bus.MemoryWrite(0x0100, 0x00)
+			if data := bus.MemoryRead(test.read); data != 0x5A {
+				t.Errorf("read $%02X at $%04X after writing $5A at $%04X", data, test.read, test.written)
+			}
+		})
+	}
+}
+
+func TestOpenBusRegisters(t *testing.T) {
+	var tests = []struct {
+		name    string
+		address uint16
+	}{
+		{"OAM DMA", OAM_DMA},
+		{"first test register", TEST_REGISTERS_START},
+		{"test register", 0x401A},
+		{"last test register", TEST_REGISTERS_END},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var bus, cartridge = newTestBus()
+			bus.MemoryWrite(test.address, 0x33)
+			// Writes are ignored : the last value on the data bus is read back, not the one written
+			bus.MemoryWrite(0x0100, 0xC7)
+			if data := bus.MemoryRead(test.address); data != 0xC7 {
+				t.Errorf("read $%02X at $%04X, want the open bus $C7", data, test.address)
+			}
+			if data := bus.Peek(test.address); data != 0xC7 {
+				t.Errorf("peeked $%02X at $%04X, want the open bus $C7", data, test.address)
+			}
+			if cartridge.memory[test.address] != 0 {
+				t.Errorf("write to $%04X reached the cartridge", test.address)
+			}
+		})
+	}
+}
+
+func TestU16(t *testing.T) {
+	var tests = []struct {
+		name    string
+		address uint16
+		// Addresses of the low and high bytes once unmirrored
+		low         uint16
+		high        uint16
+		isHighInRam bool
+	}{
+		{"RAM", 0x0010, 0x0010, 0x0011, true},
+		{"across the RAM mirrors", 0x07FF, 0x07FF, 0x0000, true},
+		{"across the last RAM mirror", 0x1FFF, 0x07FF, 0x2000, false},
+		{"cartridge", 0x8000, 0x8000, 0x8001, false},
+		{"wrap at $FFFF", 0xFFFF, 0xFFFF, 0x0000, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var bus, cartridge = newTestBus()
+			bus.MemoryWriteU16(test.address, 0xBEEF)
+			var lowByte = bus.memory[test.low]
+			if test.low >= CARTRIDGE_START {
+				lowByte = cartridge.memory[test.low]
+			}
+			var highByte = bus.memory[test.high]
+			if test.high >= CARTRIDGE_START {
+				highByte = cartridge.memory[test.high]
+			}
+			if lowByte != 0xEF || highByte != 0xBE {
+				t.Errorf("wrote $%02X at $%04X and $%02X at $%04X, want $EF and $BE", lowByte, test.low, highByte, test.high)
+			}
+			if data := bus.MemoryReadU16(test.address); data != 0xBEEF {
+				t.Errorf("read $%04X at $%04X, want $BEEF", data, test.address)
+			}
+			if test.isHighInRam && bus.MemoryRead(test.high) != 0xBE {
+				t.Errorf("high byte not read back from RAM at $%04X", test.high)
+			}
+		})
+	}
+}
+
+// Bus with a 16 KiB NROM cartridge whose PRG ROM bytes are their offset + 1
+func newRomBus(t *testing.T) *Bus {
+	var image = make([]uint8, 16+PRG_ROM_PAGE_SIZE)
+	copy(image, []uint8{'N', 'E', 'S', 0x1A, 1, 0})
+	for i := 0; i < PRG_ROM_PAGE_SIZE; i++ {
+		image[16+i] = uint8(i + 1)
+	}
+	var rom, err = ParseRawRom(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var consoleAPU = apu.NewAPU()
+	consoleAPU.PowerOn()
+	var bus = NewBus(&consoleAPU)
+	bus.LoadRom(rom)
+	return &bus
+}
+
+func TestRomAddressSpace(t *testing.T) {
+	var tests = []struct {
+		name    string
+		address uint16
+		// Value written before reading, nothing can be written to NROM
+		written uint8
+		data    uint16
+	}{
+		{"last test register and first cartridge address", 0x401F, 0x00, 0xC7C7},
+		{"unmapped cartridge space", 0x4020, 0x00, 0xC7C7},
+		{"PRG RAM space", 0x6000, 0x33, 0xC7C7},
+		{"across the start of the PRG ROM", 0x7FFF, 0x00, 0x01C7},
+		{"PRG ROM", 0x8000, 0x33, 0x0201},
+		{"PRG ROM mirror", 0xC000, 0x33, 0x0201},
+		{"across the end of the address space", 0xFFFF, 0x33, 0x0000},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var bus = newRomBus(t)
+			bus.MemoryWrite(test.address, test.written)
+			bus.MemoryWrite(test.address+1, test.written)
+			// Drive a value on the data bus, read back from unmapped addresses
+			bus.MemoryWrite(0x0000, 0x00)
+			bus.MemoryWrite(0x0100, 0xC7)
+			// Peeking sees what the CPU reads
+			var peeked = uint16(bus.Peek(test.address)) | uint16(bus.Peek(test.address+1))<<8
+			if peeked != test.data {
+				t.Errorf("peeked $%04X at $%04X, want $%04X", peeked, test.address, test.data)
+			}
+			if data := bus.MemoryReadU16(test.address); data != test.data {
+				t.Errorf("read $%04X at $%04X, want $%04X", data, test.address, test.data)
+			}
+		})
+	}
+}
+
+// The standard controller through $4016/$4017 : https://www.nesdev.org/wiki/Standard_controller
+func TestControllerPorts(t *testing.T) {
+	var bus, _ = newTestBus()
+	var joypad = controller.NewJoypad()
+	joypad.SetButtons(controller.BUTTON_A | controller.BUTTON_START | controller.BUTTON_RIGHT)
+	bus.ConnectController(controller.PORT_1, &joypad)
+
+	// While strobing, the state of A is reported again and again
+	bus.MemoryWrite(CONTROLLER_PORT_1, 0x01)
+	for i := 0; i < 3; i++ {
+		if data := bus.MemoryRead(CONTROLLER_PORT_1) & controller.DATA_LINES_MASK; data != 1 {
+			t.Fatalf("read %d while strobing returned $%02X, want A pressed", i, data)
+		}
+	}
+	joypad.SetButtons(controller.BUTTON_B)
+	if data := bus.MemoryRead(CONTROLLER_PORT_1) & controller.DATA_LINES_MASK; data != 0 {
+		t.Fatalf("read while strobing returned $%02X after releasing A", data)
+	}
+	joypad.SetButtons(controller.BUTTON_A | controller.BUTTON_START | controller.BUTTON_RIGHT)
+
+	// The buttons latched when the strobe ends come one by one, then 1s
+	// Bit 6 written drives the upper bits, which are open bus on reads
+	bus.MemoryWrite(CONTROLLER_PORT_1, 0x40)
+	joypad.SetButtons(0)
+	var want = []uint8{1, 0, 0, 1, 0, 0, 0, 1, 1, 1, 1}
+	for i, bit := range want {
+		if data := bus.MemoryRead(CONTROLLER_PORT_1); data != 0x40|bit {
+			t.Errorf("read %d returned $%02X, want $%02X", i, data, 0x40|bit)
+		}
+	}
+	// Peeking does not shift
+	if bus.Peek(CONTROLLER_PORT_1) != 0x41 || bus.Peek(CONTROLLER_PORT_1) != 0x41 {
+		t.Errorf("peeked $%02X after the 8 buttons", bus.Peek(CONTROLLER_PORT_1))
+	}
+
+	// Nothing drives the data lines of an empty port
+	bus.MemoryWrite(0x0100, 0xA5)
+	if data := bus.MemoryRead(CONTROLLER_PORT_2); data != 0xA0 {
+		t.Errorf("read $%02X from the empty port 2, want the open bus $A0", data)
+	}
+	// Both ports see the strobe
+	var secondJoypad = controller.NewJoypad()
+	secondJoypad.SetButtons(controller.BUTTON_B)
+	bus.ConnectController(controller.PORT_2, &secondJoypad)
+	bus.MemoryWrite(CONTROLLER_PORT_1, 0x01)
+	bus.MemoryWrite(CONTROLLER_PORT_1, 0x00)
+	for i, bit := range []uint8{0, 1, 0} {
+		if data := bus.MemoryRead(CONTROLLER_PORT_2); data != bit {
+			t.Errorf("read %d from port 2 returned $%02X, want $%02X", i, data, bit)
+		}
+	}
+}
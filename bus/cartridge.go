@@ -2,11 +2,27 @@ package bus
 
 import (
 	"bytes"
+	"crypto/md5"
 	"errors"
+	"nes-emulator/region"
+	"nes-emulator/savestate"
 )
 
 const PRG_ROM_PAGE_SIZE int = 16384
 const CHR_ROM_PAGE_SIZE int = 8192
+const PRG_ROM_START uint16 = 0x8000
+const PRG_ROM_END uint16 = 0xFFFF
+
+// Anything plugged in the cartridge space of the CPU memory map ($4020-$FFFF)
+type Cartridge interface {
+	// Returns false when nothing on the cartridge drives the data bus at address : the open bus is read instead
+	Read(address uint16) (uint8, bool)
+	Write(address uint16, data uint8)
+	// Values Read would return, without side effects such as clocking a mapper : for debuggers and tracers
+	Peek(address uint16) (uint8, bool)
+	// Banks, registers and RAM of the cartridge
+	SerializeState(state *savestate.Serializer)
+}
 
 type ScreenMirroring int
 
@@ -19,8 +35,9 @@ const (
 type Rom struct {
 	prgRom          []uint8
 	chrRom          []uint8
-	mapper          uint8
+	mapper          uint16
 	screenMirroring ScreenMirroring
+	region          region.Region
 }
 
 func ParseRawRom(raw []byte) (*Rom, error) {
@@ -33,10 +50,17 @@ func ParseRawRom(raw []byte) (*Rom, error) {
 	//var isBatteryBackedRAMEnabled = raw[6] & 0b0000_0010 != 0
 	var isTrainerEnabled = raw[6]&0b0000_0100 != 0
 	var isFourScreenEnabled = raw[6]&0b0000_1000 != 0
-	var mapper = (raw[6] >> 4) | (raw[7] & 0b1111_0000)
+	var mapper = uint16((raw[6] >> 4) | (raw[7] & 0b1111_0000))
 	// TODO : this does not work ??
 	//var isVerifiedINESV1 = raw[7]&0b0000_0011 == 0
-	var isINESV2 = raw[7]&0b0000_1100 != 0
+	// https://www.nesdev.org/wiki/NES_2.0#Identification
+	var isNES2 = raw[7]&0b0000_1100 == 0b0000_1000
+	var isINESV1 = raw[7]&0b0000_1100 == 0
+	// Only NES 2.0 reliably tells the region, iNES flags 9 is hardly ever set
+	var romRegion = region.NTSC
+	if !isNES2 && raw[9]&0b0000_0001 != 0 {
+		romRegion = region.PAL
+	}
 
 	/* SANITY CHECKS */
 
@@ -44,8 +68,27 @@ func ParseRawRom(raw []byte) (*Rom, error) {
 		return &Rom{}, errors.New("file is not in iNES file format (invalid tag)")
 	}
 
-	if isINESV2 {
-		return &Rom{}, errors.New("iNES v2 is not supported")
+	if !isINESV1 && !isNES2 {
+		return &Rom{}, errors.New("unknown iNES header version")
+	}
+
+	if isNES2 {
+		// https://www.nesdev.org/wiki/NES_2.0#Header
+		mapper |= uint16(raw[8]&0b0000_1111) << 8
+		if raw[9]&0b0000_1111 == 0x0F || raw[9]&0b1111_0000 == 0xF0 {
+			return &Rom{}, errors.New("NES 2.0 exponent-multiplier ROM sizes are not supported")
+		}
+		numberOfROMBanks |= int(raw[9]&0b0000_1111) << 8
+		numberOfVROMBanks |= int(raw[9]>>4) << 8
+		switch raw[12] & 0b0000_0011 {
+		case 1:
+			romRegion = region.PAL
+		case 3:
+			romRegion = region.DENDY
+		default:
+			// Multi-region games run on NTSC consoles too
+			romRegion = region.NTSC
+		}
 	}
 
 	//if isVerifiedINESV1 {
@@ -76,5 +119,69 @@ func ParseRawRom(raw []byte) (*Rom, error) {
 		chrRom:          raw[chrROMStart : chrROMStart+chrROMSize],
 		mapper:          mapper,
 		screenMirroring: screenMirroring,
+		region:          romRegion,
 	}, nil
 }
+
+// Program data of the cartridge, in 16 KiB banks (PRG_ROM_PAGE_SIZE)
+func (rom *Rom) PrgRom() []uint8 {
+	return rom.prgRom
+}
+
+// Graphics data of the cartridge, empty when it uses CHR RAM
+func (rom *Rom) ChrRom() []uint8 {
+	return rom.chrRom
+}
+
+// iNES mapper number
+func (rom *Rom) Mapper() uint16 {
+	return rom.mapper
+}
+
+// Region the game was made for, as told by its header
+func (rom *Rom) Region() region.Region {
+	return rom.region
+}
+
+// MD5 of the PRG and CHR ROM, identifying the game regardless of its header
+func (rom *Rom) Checksum() [16]byte {
+	var hash = md5.New()
+	hash.Write(rom.prgRom)
+	hash.Write(rom.chrRom)
+	var checksum [16]byte
+	copy(checksum[:], hash.Sum(nil))
+	return checksum
+}
+
+// NROM has neither registers nor RAM : the state of other mappers goes here
+func (rom *Rom) SerializeState(state *savestate.Serializer) {
+}
+
+// Memory helpers
+
+// NROM maps nothing below the PRG ROM
+func (rom *Rom) Read(address uint16) (uint8, bool) {
+	var offset, isMapped = rom.PrgRomOffset(address)
+	if !isMapped {
+		return 0, false
+	}
+	return rom.prgRom[offset], true
+}
+
+// Offset in the PRG ROM of the byte the CPU sees at address, false outside of the PRG ROM
+// A 16 KiB PRG ROM is mirrored in the 32 KiB of the address space
+func (rom *Rom) PrgRomOffset(address uint16) (int, bool) {
+	if address < PRG_ROM_START {
+		return 0, false
+	}
+	return int(address-PRG_ROM_START) % len(rom.prgRom), true
+}
+
+// Reading NROM has no side effects
+func (rom *Rom) Peek(address uint16) (uint8, bool) {
+	return rom.Read(address)
+}
+
+// NROM has neither registers nor PRG RAM : writes are ignored
+func (rom *Rom) Write(address uint16, data uint8) {
+}
@@ -0,0 +1,79 @@
+package bus
+
+// Callbacks on accesses to address ranges, for debuggers, cheat engines, achievements or profilers
+// Pages without any hook are skipped with a single table lookup
+
+type AccessKind int
+
+const (
+	ACCESS_READ AccessKind = 1 << iota
+	ACCESS_WRITE
+	// Opcode fetch by the CPU, which is also a read
+	ACCESS_EXECUTE
+	// Sample fetch of the DMC, which is also a read
+	ACCESS_DMA
+)
+
+// Called with the address accessed and the value read or about to be written
+type AccessHook func(address uint16, data uint8, kind AccessKind)
+
+// Identifies a hook to remove it
+type HookId int
+
+type hook struct {
+	id       HookId
+	start    uint16
+	end      uint16
+	kinds    AccessKind
+	callback AccessHook
+}
+
+type hooks struct {
+	list   []hook
+	nextId HookId
+	// Kinds of access hooked in each 256 bytes page
+	hookedPages [0x100]AccessKind
+}
+
+// Calls callback on every access of the given kinds between start and end, both included
+func (bus *Bus) AddHook(start uint16, end uint16, kinds AccessKind, callback AccessHook) HookId {
+	bus.hooks.nextId++
+	// The list is copied, so that hooks can be added or removed from a callback
+	var list = make([]hook, len(bus.hooks.list), len(bus.hooks.list)+1)
+	copy(list, bus.hooks.list)
+	bus.hooks.list = append(list, hook{id: bus.hooks.nextId, start: start, end: end, kinds: kinds, callback: callback})
+	bus.hooks.updatePages()
+	return bus.hooks.nextId
+}
+
+func (bus *Bus) RemoveHook(id HookId) {
+	var list = make([]hook, 0, len(bus.hooks.list))
+	for _, hook := range bus.hooks.list {
+		if hook.id != id {
+			list = append(list, hook)
+		}
+	}
+	bus.hooks.list = list
+	bus.hooks.updatePages()
+}
+
+func (hooks *hooks) updatePages() {
+	hooks.hookedPages = [0x100]AccessKind{}
+	for _, hook := range hooks.list {
+		for page := int(hook.start >> 8); page <= int(hook.end>>8); page++ {
+			hooks.hookedPages[page] |= hook.kinds
+		}
+	}
+}
+
+func (hooks *hooks) isHooked(address uint16, kind AccessKind) bool {
+	return hooks.hookedPages[address>>8]&kind != 0
+}
+
+func (hooks *hooks) call(address uint16, data uint8, kind AccessKind) {
+	for _, hook := range hooks.list {
+		if hook.kinds&kind != 0 && hook.start <= address && address <= hook.end {
+			hook.callback(address, data, kind)
+		}
+	}
+}
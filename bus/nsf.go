@@ -0,0 +1,350 @@
+package bus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"nes-emulator/savestate"
+	"strings"
+)
+
+// NES Sound Format, a rip of the music code and data of a game
+// More info here : https://www.nesdev.org/wiki/NSF and https://www.nesdev.org/wiki/NSFe
+
+const NSF_HEADER_SIZE int = 0x80
+const NSF_BANK_SIZE int = 0x1000
+const NSF_BANKSWITCH_START uint16 = 0x5FF8
+const NSF_BANKSWITCH_END uint16 = 0x5FFF
+const NSF_WRAM_START uint16 = 0x6000
+const NSF_WRAM_END uint16 = 0x7FFF
+
+// The player driver is an idle loop (JMP to itself) mapped in unused space
+// INIT and PLAY routines return to it, and the CPU spins there between two calls to PLAY
+const NSF_DRIVER_ADDRESS uint16 = 0x4100
+
+var nsfDriver = []uint8{0x4C, uint8(NSF_DRIVER_ADDRESS & 0xFF), uint8(NSF_DRIVER_ADDRESS >> 8)}
+
+// Default play rate of NTSC and PAL tunes, in microseconds
+const NSF_DEFAULT_NTSC_PLAY_SPEED uint16 = 16639
+const NSF_DEFAULT_PAL_PLAY_SPEED uint16 = 19997
+
+type ExpansionChips uint8
+
+const (
+	VRC6       ExpansionChips = 0b0000_0001
+	VRC7       ExpansionChips = 0b0000_0010
+	FDS        ExpansionChips = 0b0000_0100
+	MMC5       ExpansionChips = 0b0000_1000
+	NAMCO_163  ExpansionChips = 0b0001_0000
+	SUNSOFT_5B ExpansionChips = 0b0010_0000
+	VT02       ExpansionChips = 0b0100_0000
+	ALL_CHIPS  ExpansionChips = 0b0111_1111
+)
+
+var expansionChipNames = []struct {
+	chip ExpansionChips
+	name string
+}{
+	{VRC6, "VRC6"},
+	{VRC7, "VRC7"},
+	{FDS, "FDS"},
+	{MMC5, "MMC5"},
+	{NAMCO_163, "Namco 163"},
+	{SUNSOFT_5B, "Sunsoft 5B"},
+	{VT02, "VT02+"},
+}
+
+func (chips ExpansionChips) Has(chip ExpansionChips) bool {
+	return chips&chip != 0
+}
+
+func (chips ExpansionChips) String() string {
+	var names []string
+	for _, chipName := range expansionChipNames {
+		if chips.Has(chipName.chip) {
+			names = append(names, chipName.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+type Nsf struct {
+	Title     string
+	Artist    string
+	Copyright string
+	// Number of songs, songs are numbered from 0
+	NumberOfSongs int
+	StartingSong  int
+	LoadAddress   uint16
+	InitAddress   uint16
+	PlayAddress   uint16
+	// Play rates in microseconds
+	NtscPlaySpeed  uint16
+	PalPlaySpeed   uint16
+	IsPal          bool
+	IsDualRegion   bool
+	ExpansionChips ExpansionChips
+	// Optional NSFe metadata, durations are in milliseconds (-1 if unknown)
+	TrackLabels    []string
+	TrackDurations []int
+
+	isBankswitched bool
+	bankswitchInit [8]uint8
+	// Program data, padded so that it starts at the beginning of a 4 KiB bank
+	data  []uint8
+	banks [8]uint8
+	wram  [0x2000]uint8
+}
+
+func ParseRawNsf(raw []byte) (*Nsf, error) {
+	/* PARSING HEADERS */
+	if len(raw) < NSF_HEADER_SIZE || !bytes.Equal(raw[0:5], []byte{0x4E, 0x45, 0x53, 0x4D, 0x1A}) {
+		return &Nsf{}, errors.New("file is not in NSF file format (invalid tag)")
+	}
+	var nsf = &Nsf{
+		NumberOfSongs:  int(raw[0x06]),
+		StartingSong:   int(raw[0x07]) - 1,
+		LoadAddress:    binary.LittleEndian.Uint16(raw[0x08:0x0A]),
+		InitAddress:    binary.LittleEndian.Uint16(raw[0x0A:0x0C]),
+		PlayAddress:    binary.LittleEndian.Uint16(raw[0x0C:0x0E]),
+		Title:          parseNsfString(raw[0x0E:0x2E]),
+		Artist:         parseNsfString(raw[0x2E:0x4E]),
+		Copyright:      parseNsfString(raw[0x4E:0x6E]),
+		NtscPlaySpeed:  binary.LittleEndian.Uint16(raw[0x6E:0x70]),
+		PalPlaySpeed:   binary.LittleEndian.Uint16(raw[0x78:0x7A]),
+		IsPal:          raw[0x7A]&0b0000_0001 != 0,
+		IsDualRegion:   raw[0x7A]&0b0000_0010 != 0,
+		ExpansionChips: ExpansionChips(raw[0x7B]) & ALL_CHIPS,
+	}
+	copy(nsf.bankswitchInit[:], raw[0x70:0x78])
+
+	var programData = raw[NSF_HEADER_SIZE:]
+	// NSF2 may store metadata after the program data
+	var programDataLength = int(raw[0x7D]) | int(raw[0x7E])<<8 | int(raw[0x7F])<<16
+	if raw[0x05] >= 2 && programDataLength != 0 && programDataLength < len(programData) {
+		programData = programData[:programDataLength]
+	}
+
+	if err := nsf.setup(programData); err != nil {
+		return &Nsf{}, err
+	}
+	return nsf, nil
+}
+
+// NSFe is a chunk based alternative to NSF headers
+func ParseRawNsfe(raw []byte) (*Nsf, error) {
+	if len(raw) < 4 || !bytes.Equal(raw[0:4], []byte("NSFE")) {
+		return &Nsf{}, errors.New("file is not in NSFe file format (invalid tag)")
+	}
+	var nsf = &Nsf{
+		NumberOfSongs: 1,
+		NtscPlaySpeed: NSF_DEFAULT_NTSC_PLAY_SPEED,
+		PalPlaySpeed:  NSF_DEFAULT_PAL_PLAY_SPEED,
+	}
+	var programData []uint8
+	var hasInfo = false
+	var offset = 4
+	for {
+		if offset+8 > len(raw) {
+			return &Nsf{}, errors.New("NSFe file is truncated (missing NEND chunk)")
+		}
+		var chunkLength = int(binary.LittleEndian.Uint32(raw[offset : offset+4]))
+		var chunkId = string(raw[offset+4 : offset+8])
+		offset += 8
+		if chunkLength < 0 || offset+chunkLength > len(raw) {
+			return &Nsf{}, fmt.Errorf("NSFe chunk %s is truncated", chunkId)
+		}
+		var chunk = raw[offset : offset+chunkLength]
+		offset += chunkLength
+
+		switch chunkId {
+		case "INFO":
+			if len(chunk) < 8 {
+				return &Nsf{}, errors.New("NSFe INFO chunk is too short")
+			}
+			hasInfo = true
+			nsf.LoadAddress = binary.LittleEndian.Uint16(chunk[0:2])
+			nsf.InitAddress = binary.LittleEndian.Uint16(chunk[2:4])
+			nsf.PlayAddress = binary.LittleEndian.Uint16(chunk[4:6])
+			nsf.IsPal = chunk[6]&0b0000_0001 != 0
+			nsf.IsDualRegion = chunk[6]&0b0000_0010 != 0
+			nsf.ExpansionChips = ExpansionChips(chunk[7]) & ALL_CHIPS
+			if len(chunk) > 8 {
+				nsf.NumberOfSongs = int(chunk[8])
+			}
+			if len(chunk) > 9 {
+				nsf.StartingSong = int(chunk[9])
+			}
+		case "DATA":
+			programData = chunk
+		case "BANK":
+			copy(nsf.bankswitchInit[:], chunk)
+		case "RATE":
+			if len(chunk) >= 2 {
+				nsf.NtscPlaySpeed = binary.LittleEndian.Uint16(chunk[0:2])
+			}
+			if len(chunk) >= 4 {
+				nsf.PalPlaySpeed = binary.LittleEndian.Uint16(chunk[2:4])
+			}
+		case "auth":
+			var fields = parseNsfeStrings(chunk)
+			for i, field := range fields {
+				switch i {
+				case 0:
+					nsf.Title = field
+				case 1:
+					nsf.Artist = field
+				case 2:
+					nsf.Copyright = field
+				}
+			}
+		case "tlbl":
+			nsf.TrackLabels = parseNsfeStrings(chunk)
+		case "time":
+			nsf.TrackDurations = nil
+			for i := 0; i+4 <= len(chunk); i += 4 {
+				nsf.TrackDurations = append(nsf.TrackDurations, int(int32(binary.LittleEndian.Uint32(chunk[i:i+4]))))
+			}
+		case "NEND":
+			if !hasInfo || programData == nil {
+				return &Nsf{}, errors.New("NSFe file misses the INFO or DATA chunk")
+			}
+			if err := nsf.setup(programData); err != nil {
+				return &Nsf{}, err
+			}
+			return nsf, nil
+		default:
+			// Chunks starting with an uppercase letter are mandatory to understand
+			if 'A' <= chunkId[0] && chunkId[0] <= 'Z' {
+				return &Nsf{}, fmt.Errorf("NSFe chunk %s is not supported", chunkId)
+			}
+		}
+	}
+}
+
+func parseNsfString(raw []byte) string {
+	if end := bytes.IndexByte(raw, 0); end >= 0 {
+		raw = raw[:end]
+	}
+	return string(raw)
+}
+
+func parseNsfeStrings(raw []byte) []string {
+	var fields = strings.Split(string(raw), "\x00")
+	// The last string is null terminated too
+	if len(fields) > 0 && fields[len(fields)-1] == "" {
+		fields = fields[:len(fields)-1]
+	}
+	return fields
+}
+
+func (nsf *Nsf) setup(programData []uint8) error {
+	/* SANITY CHECKS */
+
+	if nsf.NumberOfSongs <= 0 {
+		return errors.New("NSF file contains no song")
+	}
+	if nsf.StartingSong < 0 || nsf.StartingSong >= nsf.NumberOfSongs {
+		nsf.StartingSong = 0
+	}
+	if nsf.ExpansionChips.Has(FDS) {
+		return errors.New("NSF files using the FDS expansion are not supported")
+	}
+	if nsf.NtscPlaySpeed == 0 {
+		nsf.NtscPlaySpeed = NSF_DEFAULT_NTSC_PLAY_SPEED
+	}
+	if nsf.PalPlaySpeed == 0 {
+		nsf.PalPlaySpeed = NSF_DEFAULT_PAL_PLAY_SPEED
+	}
+
+	/* Building memory */
+
+	for _, bank := range nsf.bankswitchInit {
+		if bank != 0 {
+			nsf.isBankswitched = true
+		}
+	}
+	var padding int
+	if nsf.isBankswitched {
+		padding = int(nsf.LoadAddress) & (NSF_BANK_SIZE - 1)
+	} else {
+		if nsf.LoadAddress < PRG_ROM_START {
+			return fmt.Errorf("NSF load address %04X is outside of PRG ROM", nsf.LoadAddress)
+		}
+		// Non bankswitched tunes are mapped linearly from $8000
+		padding = int(nsf.LoadAddress - PRG_ROM_START)
+	}
+	nsf.data = make([]uint8, padding+len(programData))
+	copy(nsf.data[padding:], programData)
+	nsf.Reset()
+	return nil
+}
+
+// Restores the initial bank mapping and clears the WRAM, must be done before initializing a song
+func (nsf *Nsf) Reset() {
+	for i := range nsf.banks {
+		if nsf.isBankswitched {
+			nsf.banks[i] = nsf.bankswitchInit[i]
+		} else {
+			nsf.banks[i] = uint8(i)
+		}
+	}
+	nsf.wram = [0x2000]uint8{}
+}
+
+func (nsf *Nsf) IsBankswitched() bool {
+	return nsf.isBankswitched
+}
+
+// Bank values to write in $5FF8-$5FFF when initializing a song
+func (nsf *Nsf) BankswitchInit() [8]uint8 {
+	return nsf.bankswitchInit
+}
+
+func (nsf *Nsf) SerializeState(state *savestate.Serializer) {
+	state.Bytes(nsf.banks[:])
+	state.Bytes(nsf.wram[:])
+}
+
+// Memory helpers
+
+func (nsf *Nsf) Read(address uint16) (uint8, bool) {
+	switch {
+	case NSF_DRIVER_ADDRESS <= address && int(address-NSF_DRIVER_ADDRESS) < len(nsfDriver):
+		return nsfDriver[address-NSF_DRIVER_ADDRESS], true
+	case NSF_WRAM_START <= address && address <= NSF_WRAM_END:
+		return nsf.wram[address-NSF_WRAM_START], true
+	case PRG_ROM_START <= address:
+		var slot = (address - PRG_ROM_START) >> 12
+		var offset = int(nsf.banks[slot])*NSF_BANK_SIZE + int(address&0x0FFF)
+		if offset >= len(nsf.data) {
+			return 0, true
+		}
+		return nsf.data[offset], true
+	default:
+		// Nothing is mapped there
+		return 0, false
+	}
+}
+
+// Reads have no side effects
+func (nsf *Nsf) Peek(address uint16) (uint8, bool) {
+	return nsf.Read(address)
+}
+
+func (nsf *Nsf) Write(address uint16, data uint8) {
+	switch {
+	case NSF_BANKSWITCH_START <= address && address <= NSF_BANKSWITCH_END:
+		if nsf.isBankswitched {
+			nsf.banks[address-NSF_BANKSWITCH_START] = data
+		}
+	case NSF_WRAM_START <= address && address <= NSF_WRAM_END:
+		nsf.wram[address-NSF_WRAM_START] = data
+	default:
+		// Writes to ROM or unmapped space are ignored
+	}
+}
@@ -0,0 +1,61 @@
+package bus
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Content of the RAM at power-on : real hardware is left with semi-random values that some games depend on
+type RamFill int
+
+const (
+	RAM_FILL_ZERO RamFill = iota
+	RAM_FILL_ONES
+	// Blocks of 4 bytes alternating between $00 and $FF, as FCEUX does
+	RAM_FILL_FCEUX
+	// Values drawn from a seeded generator, so that a run can be reproduced
+	RAM_FILL_RANDOM
+)
+
+var ramFillNames = map[RamFill]string{
+	RAM_FILL_ZERO:   "zero",
+	RAM_FILL_ONES:   "ff",
+	RAM_FILL_FCEUX:  "fceux",
+	RAM_FILL_RANDOM: "random",
+}
+
+func ParseRamFill(name string) (RamFill, error) {
+	for ramFill, ramFillName := range ramFillNames {
+		if ramFillName == name {
+			return ramFill, nil
+		}
+	}
+	return RAM_FILL_ZERO, fmt.Errorf("unknown RAM fill pattern %q (zero, ff, fceux or random)", name)
+}
+
+func (ramFill RamFill) String() string {
+	return ramFillNames[ramFill]
+}
+
+func (ramFill RamFill) fill(ram []uint8, seed int64) {
+	switch ramFill {
+	case RAM_FILL_ZERO:
+		for i := range ram {
+			ram[i] = 0x00
+		}
+	case RAM_FILL_ONES:
+		for i := range ram {
+			ram[i] = 0xFF
+		}
+	case RAM_FILL_FCEUX:
+		for i := range ram {
+			if i&0b0000_0100 != 0 {
+				ram[i] = 0xFF
+			} else {
+				ram[i] = 0x00
+			}
+		}
+	case RAM_FILL_RANDOM:
+		rand.New(rand.NewSource(seed)).Read(ram)
+	}
+}
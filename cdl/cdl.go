@@ -0,0 +1,108 @@
+package cdl
+
+import (
+	"fmt"
+	"io"
+)
+
+// Code/Data Logger files of FCEUX : one byte of flags per byte of PRG ROM, followed by one per byte of CHR ROM
+// https://fceux.com/web/help/CodeDataLogger.html
+
+// Flags of the PRG ROM bytes
+const (
+	PRG_CODE uint8 = 0b0000_0001
+	PRG_DATA uint8 = 0b0000_0010
+	// Bits 13-14 of the CPU address the byte was last accessed at : its 8 KiB window, $8000, $A000, $C000 or $E000
+	PRG_WINDOW_MASK uint8 = 0b0000_1100
+	// Code jumped to through JMP ($xxxx), data read through (zp,X) or (zp),Y
+	PRG_INDIRECT_CODE uint8 = 0b0001_0000
+	PRG_INDIRECT_DATA uint8 = 0b0010_0000
+	// Sample played by the DMC
+	PRG_PCM_AUDIO uint8 = 0b0100_0000
+)
+
+// Flags of the CHR ROM bytes
+const (
+	CHR_RENDERED uint8 = 0b0000_0001
+	// Read by the program through PPUDATA
+	CHR_READ uint8 = 0b0000_0010
+)
+
+type Log struct {
+	prg []uint8
+	chr []uint8
+}
+
+func NewLog(prgSize int, chrSize int) *Log {
+	return &Log{prg: make([]uint8, prgSize), chr: make([]uint8, chrSize)}
+}
+
+// Reads a .cdl file logged on a ROM of the given PRG and CHR ROM sizes
+func Read(input io.Reader, prgSize int, chrSize int) (*Log, error) {
+	var content, err = io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) != prgSize+chrSize {
+		return nil, fmt.Errorf("code/data log of %d bytes does not match a ROM of %d bytes of PRG and %d bytes of CHR",
+			len(content), prgSize, chrSize)
+	}
+	var log = NewLog(prgSize, chrSize)
+	copy(log.prg, content[:prgSize])
+	copy(log.chr, content[prgSize:])
+	return log, nil
+}
+
+// Writes the log in the .cdl format of FCEUX
+// Only the PRG ROM flags are filled by Logger : without a PPU, no CHR ROM byte is ever rendered or read through
+// PPUDATA, so the CHR ROM flags are written as loaded, empty unless they come from another emulator
+func (log *Log) Write(output io.Writer) error {
+	if _, err := output.Write(log.prg); err != nil {
+		return err
+	}
+	_, err := output.Write(log.chr)
+	return err
+}
+
+// Flags of each PRG ROM byte
+func (log *Log) Prg() []uint8 {
+	return log.prg
+}
+
+// Flags of each CHR ROM byte
+func (log *Log) Chr() []uint8 {
+	return log.chr
+}
+
+// Adds flags to the PRG ROM byte at offset, accessed by the CPU at address
+func (log *Log) MarkPrg(offset int, address uint16, flags uint8) {
+	log.prg[offset] = log.prg[offset]&^PRG_WINDOW_MASK | flags | uint8(address>>11)&PRG_WINDOW_MASK
+}
+
+func (log *Log) MarkChr(offset int, flags uint8) {
+	log.chr[offset] |= flags
+}
+
+func (log *Log) IsCode(offset int) bool {
+	return log.prg[offset]&PRG_CODE != 0
+}
+
+// Bytes logged as data and never executed
+func (log *Log) IsData(offset int) bool {
+	return log.prg[offset]&(PRG_DATA|PRG_PCM_AUDIO) != 0 && !log.IsCode(offset)
+}
+
+// Number of PRG ROM bytes logged as code, as data only, and never accessed
+func (log *Log) PrgCounts() (code int, data int, unknown int) {
+	for offset := range log.prg {
+		switch {
+		case log.IsCode(offset):
+			code++
+		case log.IsData(offset):
+			data++
+		default:
+			unknown++
+		}
+	}
+	return code, data, unknown
+}
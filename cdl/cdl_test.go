@@ -0,0 +1,111 @@
+package cdl_test
+
+import (
+	"bytes"
+	"nes-emulator/bus"
+	"nes-emulator/cdl"
+	"nes-emulator/nes_console"
+	"testing"
+)
+
+// Runs from $C000, the single PRG bank being mirrored at $8000 and $C000
+var program = map[int][]uint8{
+	0x00: {0xA2, 0x00},       // LDX #$00
+	0x02: {0xBD, 0x20, 0xC0}, // LDA $C020,X
+	0x05: {0xA9, 0x22},       // LDA #$22
+	0x07: {0x85, 0x10},       // STA $10
+	0x09: {0xA9, 0x80},       // LDA #$80
+	0x0B: {0x85, 0x11},       // STA $11
+	0x0D: {0xA0, 0x00},       // LDY #$00
+	0x0F: {0xB1, 0x10},       // LDA ($10),Y : reads $8022
+	0x11: {0x6C, 0x24, 0xC0}, // JMP ($C024)
+	0x18: {0x4C, 0x18, 0xC0}, // JMP $C018
+	0x20: {0x11},
+	0x22: {0x33},
+	0x24: {0x18, 0xC0},
+}
+
+// Bits of the 8 KiB windows $8000 and $C000
+const WINDOW_8000 uint8 = 0b0000_0000
+const WINDOW_C000 uint8 = 0b0000_1000
+
+func runProgram(t *testing.T) (*cdl.Log, *bus.Rom) {
+	var image = make([]uint8, 16+bus.PRG_ROM_PAGE_SIZE+bus.CHR_ROM_PAGE_SIZE)
+	copy(image, []uint8{'N', 'E', 'S', 0x1A, 1, 1})
+	for offset, bytes := range program {
+		copy(image[16+offset:], bytes)
+	}
+	var rom, err = bus.ParseRawRom(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var console = nes_console.NewConsole()
+	console.LoadRom(rom)
+	console.PowerOn()
+	var log = cdl.NewLog(len(rom.PrgRom()), len(rom.ChrRom()))
+	if err := console.StartCodeDataLogger(log); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 12; i++ {
+		if err := console.StepInstruction(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return log, rom
+}
+
+func TestPrgFlags(t *testing.T) {
+	var log, _ = runProgram(t)
+	var tests = []struct {
+		name   string
+		offset int
+		flags  uint8
+	}{
+		{"opcode", 0x00, cdl.PRG_CODE | WINDOW_C000},
+		{"immediate operand", 0x01, cdl.PRG_CODE | WINDOW_C000},
+		{"absolute operand", 0x04, cdl.PRG_CODE | WINDOW_C000},
+		{"last instruction before the indirect jump", 0x13, cdl.PRG_CODE | WINDOW_C000},
+		{"never reached", 0x14, 0},
+		{"indirect jump target", 0x18, cdl.PRG_CODE | cdl.PRG_INDIRECT_CODE | WINDOW_C000},
+		{"data read by LDA abs,X", 0x20, cdl.PRG_DATA | WINDOW_C000},
+		{"never read", 0x21, 0},
+		{"data read by LDA (zp),Y through $8000", 0x22, cdl.PRG_DATA | cdl.PRG_INDIRECT_DATA | WINDOW_8000},
+		{"pointer low byte of the indirect jump", 0x24, cdl.PRG_DATA | WINDOW_C000},
+		{"pointer high byte of the indirect jump", 0x25, cdl.PRG_DATA | WINDOW_C000},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if flags := log.Prg()[test.offset]; flags != test.flags {
+				t.Errorf("flags %08b at offset $%04X, want %08b", flags, test.offset, test.flags)
+			}
+		})
+	}
+	if !log.IsData(0x22) || log.IsData(0x18) || !log.IsCode(0x18) {
+		t.Errorf("IsData or IsCode disagree with the flags")
+	}
+	// 23 bytes of instructions, 4 bytes of data, the rest of the bank is never accessed
+	if code, data, unknown := log.PrgCounts(); code != 23 || data != 4 || unknown != bus.PRG_ROM_PAGE_SIZE-27 {
+		t.Errorf("PrgCounts() = %d, %d, %d", code, data, unknown)
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	var log, rom = runProgram(t)
+	var file bytes.Buffer
+	if err := log.Write(&file); err != nil {
+		t.Fatal(err)
+	}
+	if file.Len() != len(rom.PrgRom())+len(rom.ChrRom()) {
+		t.Fatalf("wrote %d bytes", file.Len())
+	}
+	var read, err = cdl.Read(bytes.NewReader(file.Bytes()), len(rom.PrgRom()), len(rom.ChrRom()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read.Prg(), log.Prg()) || !bytes.Equal(read.Chr(), log.Chr()) {
+		t.Errorf("read log differs from the written one")
+	}
+	if _, err := cdl.Read(bytes.NewReader(file.Bytes()), len(rom.PrgRom()), 0); err == nil {
+		t.Errorf("log of another ROM size was read")
+	}
+}
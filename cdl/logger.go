@@ -0,0 +1,82 @@
+package cdl
+
+import (
+	"nes-emulator/bus"
+	"nes-emulator/cpu"
+)
+
+// Fills a Log with the instructions executed by the CPU and the DMC samples fetched through the bus
+// CHR ROM bytes are never flagged : the PPU is not emulated yet
+type Logger struct {
+	log *Log
+	rom *bus.Rom
+	bus *bus.Bus
+	// Hook of the DMC sample fetches
+	dmaHook bus.HookId
+}
+
+// The log must have the PRG and CHR ROM sizes of rom
+func NewLogger(log *Log, rom *bus.Rom) *Logger {
+	return &Logger{log: log, rom: rom}
+}
+
+func (logger *Logger) Log() *Log {
+	return logger.log
+}
+
+// Starts logging the DMC sample fetches, the instructions executed are passed to LogInstruction
+func (logger *Logger) Attach(consoleBus *bus.Bus) {
+	logger.bus = consoleBus
+	logger.dmaHook = consoleBus.AddHook(bus.PRG_ROM_START, bus.PRG_ROM_END, bus.ACCESS_DMA, logger.logDmaRead)
+}
+
+func (logger *Logger) Detach() {
+	if logger.bus != nil {
+		logger.bus.RemoveHook(logger.dmaHook)
+		logger.bus = nil
+	}
+}
+
+// Flags the bytes of the instruction as code, and its operand as data when it reads memory
+func (logger *Logger) LogInstruction(instruction cpu.ExecutedInstruction) {
+	var opCode = instruction.OpCode
+	for i := uint16(0); i < opCode.Size(); i++ {
+		logger.mark(instruction.Address+i, PRG_CODE)
+	}
+	switch {
+	case opCode.Operation() == cpu.JMP && opCode.AddressingMode() == cpu.Indirect:
+		// The pointer is data, the target is reached indirectly
+		var pointer = uint16(logger.peek(instruction.Address+1)) | uint16(logger.peek(instruction.Address+2))<<8
+		logger.mark(pointer, PRG_DATA)
+		// The high byte of the pointer is not incremented
+		logger.mark(pointer&0xFF00|uint16(uint8(pointer)+1), PRG_DATA)
+		logger.mark(instruction.OperandAddress, PRG_INDIRECT_CODE)
+	case opCode.ReadsOperand():
+		var flags = PRG_DATA
+		if mode := opCode.AddressingMode(); mode == cpu.IndirectX || mode == cpu.IndirectY || mode == cpu.ZeroPageIndirect {
+			flags |= PRG_INDIRECT_DATA
+		}
+		logger.mark(instruction.OperandAddress, flags)
+	}
+}
+
+func (logger *Logger) logDmaRead(address uint16, data uint8, kind bus.AccessKind) {
+	logger.mark(address, PRG_PCM_AUDIO)
+}
+
+// Addresses outside of the PRG ROM are ignored
+func (logger *Logger) mark(address uint16, flags uint8) {
+	if offset, isMapped := logger.rom.PrgRomOffset(address); isMapped {
+		logger.log.MarkPrg(offset, address, flags)
+	}
+}
+
+func (logger *Logger) peek(address uint16) uint8 {
+	if offset, isMapped := logger.rom.PrgRomOffset(address); isMapped {
+		return logger.rom.PrgRom()[offset]
+	}
+	if logger.bus != nil {
+		return logger.bus.Peek(address)
+	}
+	return 0
+}
@@ -0,0 +1,39 @@
+package controller
+
+// Controller ports, read through $4016 and $4017
+// More info here : https://www.nesdev.org/wiki/Input_devices
+
+const PORT_1 int = 0
+const PORT_2 int = 1
+const NUMBER_OF_PORTS int = 2
+
+// Only the 5 lowest bits of $4016 / $4017 are driven by the devices, others are open bus
+const DATA_LINES_MASK uint8 = 0b0001_1111
+
+// Anything plugged in a controller port
+type Device interface {
+	// Writes to $4016 bit 0 (OUT0) are seen by the devices of both ports
+	WriteStrobe(isStrobing bool)
+	// Returns the value of the data lines D0-D4
+	Read() uint8
+	// Value Read would return, without shifting to the next bit : for debuggers and tracers
+	Peek() uint8
+}
+
+// State of the 8 buttons of a standard controller, in the order they are reported
+type Buttons uint8
+
+const (
+	BUTTON_A      Buttons = 0b0000_0001
+	BUTTON_B      Buttons = 0b0000_0010
+	BUTTON_SELECT Buttons = 0b0000_0100
+	BUTTON_START  Buttons = 0b0000_1000
+	BUTTON_UP     Buttons = 0b0001_0000
+	BUTTON_DOWN   Buttons = 0b0010_0000
+	BUTTON_LEFT   Buttons = 0b0100_0000
+	BUTTON_RIGHT  Buttons = 0b1000_0000
+)
+
+func (buttons Buttons) IsPressed(button Buttons) bool {
+	return buttons&button != 0
+}
@@ -0,0 +1,57 @@
+package controller
+
+import "nes-emulator/savestate"
+
+// https://www.nesdev.org/wiki/Standard_controller
+type Joypad struct {
+	buttons Buttons
+	// While strobing, the shift register is continuously reloaded with the buttons state
+	isStrobing    bool
+	shiftRegister uint8
+}
+
+func NewJoypad() Joypad {
+	return Joypad{}
+}
+
+func (joypad *Joypad) SetButtons(buttons Buttons) {
+	joypad.buttons = buttons
+	if joypad.isStrobing {
+		joypad.shiftRegister = uint8(buttons)
+	}
+}
+
+func (joypad *Joypad) Buttons() Buttons {
+	return joypad.buttons
+}
+
+func (joypad *Joypad) WriteStrobe(isStrobing bool) {
+	joypad.isStrobing = isStrobing
+	if isStrobing {
+		joypad.shiftRegister = uint8(joypad.buttons)
+	}
+}
+
+// Reports the buttons one by one on D0 : A, B, Select, Start, Up, Down, Left, Right
+func (joypad *Joypad) Read() uint8 {
+	if joypad.isStrobing {
+		return uint8(joypad.buttons & BUTTON_A)
+	}
+	var data = joypad.shiftRegister & 0b0000_0001
+	// Official controllers report 1 once all buttons have been read
+	joypad.shiftRegister = joypad.shiftRegister>>1 | 0b1000_0000
+	return data
+}
+
+func (joypad *Joypad) Peek() uint8 {
+	if joypad.isStrobing {
+		return uint8(joypad.buttons & BUTTON_A)
+	}
+	return joypad.shiftRegister & 0b0000_0001
+}
+
+func (joypad *Joypad) SerializeState(state *savestate.Serializer) {
+	state.Uint8((*uint8)(&joypad.buttons))
+	state.Bool(&joypad.isStrobing)
+	state.Uint8(&joypad.shiftRegister)
+}
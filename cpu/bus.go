@@ -0,0 +1,19 @@
+package cpu
+
+// Everything the CPU is connected to : the console bus, or a flat RAM to test instructions in isolation
+type Bus interface {
+	MemoryRead(address uint16) uint8
+	MemoryWrite(address uint16, data uint8)
+	// Reads without side effects, for the trace
+	Peek(address uint16) uint8
+	// Advances the other devices by the number of CPU cycles elapsed
+	Tick(cycles int)
+	// CPU cycles stolen by DMA since the last call
+	TakeDmaStallCycles() int
+	IsIRQPending() bool
+}
+
+// Implemented by buses which tell opcode fetches apart from other reads, to run execute hooks for instance
+type OpCodeFetcher interface {
+	FetchOpCode(address uint16) uint8
+}
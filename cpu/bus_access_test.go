@@ -0,0 +1,140 @@
+package cpu_test
+
+import (
+	"nes-emulator/cpu"
+	"nes-emulator/singlestep"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func read(address uint16, data uint8) singlestep.Access {
+	return singlestep.Access{Address: address, Data: data}
+}
+
+func write(address uint16, data uint8) singlestep.Access {
+	return singlestep.Access{Address: address, Data: data, IsWrite: true}
+}
+
+// Accesses of every cycle, from https://www.nesdev.org/6502_cpu.txt
+func TestBusAccesses(t *testing.T) {
+	var tests = []struct {
+		name        string
+		instruction []uint8
+		registers   cpu.Registers
+		memory      map[uint16]uint8
+		accesses    []singlestep.Access
+	}{
+		// Read-modify-write instructions write the unmodified value back before the result
+		{"INC zp", []uint8{0xE6, 0x10}, cpu.Registers{}, map[uint16]uint8{0x0010: 0x41},
+			[]singlestep.Access{read(0x0200, 0xE6), read(0x0201, 0x10), read(0x0010, 0x41), write(0x0010, 0x41), write(0x0010, 0x42)}},
+		{"ASL abs,X", []uint8{0x1E, 0x34, 0x12}, cpu.Registers{X: 0x01}, map[uint16]uint8{0x1235: 0x81},
+			[]singlestep.Access{read(0x0200, 0x1E), read(0x0201, 0x34), read(0x0202, 0x12), read(0x1235, 0x81),
+				read(0x1235, 0x81), write(0x1235, 0x81), write(0x1235, 0x02)}},
+		{"DCP zp (unofficial)", []uint8{0xC7, 0x10}, cpu.Registers{}, map[uint16]uint8{0x0010: 0x00},
+			[]singlestep.Access{read(0x0200, 0xC7), read(0x0201, 0x10), read(0x0010, 0x00), write(0x0010, 0x00), write(0x0010, 0xFF)}},
+		// Indexed reads spend a cycle reading the address of the wrong page when a page is crossed
+		{"LDA abs,X", []uint8{0xBD, 0x00, 0x12}, cpu.Registers{X: 0x01}, map[uint16]uint8{0x1201: 0x55},
+			[]singlestep.Access{read(0x0200, 0xBD), read(0x0201, 0x00), read(0x0202, 0x12), read(0x1201, 0x55)}},
+		{"LDA abs,X page crossed", []uint8{0xBD, 0xFF, 0x12}, cpu.Registers{X: 0x01}, map[uint16]uint8{0x1300: 0x55},
+			[]singlestep.Access{read(0x0200, 0xBD), read(0x0201, 0xFF), read(0x0202, 0x12), read(0x1200, 0x00), read(0x1300, 0x55)}},
+		{"LDA (zp),Y page crossed", []uint8{0xB1, 0x80}, cpu.Registers{Y: 0x20}, map[uint16]uint8{0x1310: 0x55},
+			[]singlestep.Access{read(0x0200, 0xB1), read(0x0201, 0x80), read(0x0080, 0xF0), read(0x0081, 0x12),
+				read(0x1210, 0x00), read(0x1310, 0x55)}},
+		// Writes always spend that cycle
+		{"STA abs,X", []uint8{0x9D, 0x00, 0x12}, cpu.Registers{A: 0x66, X: 0x01}, nil,
+			[]singlestep.Access{read(0x0200, 0x9D), read(0x0201, 0x00), read(0x0202, 0x12), read(0x1201, 0x00), write(0x1201, 0x66)}},
+		{"LDA zp,X", []uint8{0xB5, 0xF0}, cpu.Registers{X: 0x20}, map[uint16]uint8{0x0010: 0x55},
+			[]singlestep.Access{read(0x0200, 0xB5), read(0x0201, 0xF0), read(0x00F0, 0x00), read(0x0010, 0x55)}},
+		// Stack instructions read the byte after the opcode, and pulls read the top of the stack before incrementing
+		{"PHA", []uint8{0x48}, cpu.Registers{A: 0x66}, nil,
+			[]singlestep.Access{read(0x0200, 0x48), read(0x0201, 0x00), write(0x01FD, 0x66)}},
+		{"PLA", []uint8{0x68}, cpu.Registers{}, map[uint16]uint8{0x01FE: 0x77},
+			[]singlestep.Access{read(0x0200, 0x68), read(0x0201, 0x00), read(0x01FD, 0x00), read(0x01FE, 0x77)}},
+		{"JSR", []uint8{0x20, 0x34, 0x12}, cpu.Registers{}, nil,
+			[]singlestep.Access{read(0x0200, 0x20), read(0x0201, 0x34), read(0x01FD, 0x00), write(0x01FD, 0x02),
+				write(0x01FC, 0x02), read(0x0202, 0x12)}},
+		{"RTS", []uint8{0x60}, cpu.Registers{}, map[uint16]uint8{0x01FE: 0x33, 0x01FF: 0x12},
+			[]singlestep.Access{read(0x0200, 0x60), read(0x0201, 0x00), read(0x01FD, 0x00), read(0x01FE, 0x33),
+				read(0x01FF, 0x12), read(0x1233, 0x00)}},
+		// BRK pushes the address after its padding byte and the flags with B set, then jumps through the IRQ vector
+		{"BRK", []uint8{0x00, 0xEA}, cpu.Registers{Status: 0b1000_0001}, map[uint16]uint8{0xFFFE: 0x34, 0xFFFF: 0x12},
+			[]singlestep.Access{read(0x0200, 0x00), read(0x0201, 0xEA), write(0x01FD, 0x02), write(0x01FC, 0x02),
+				write(0x01FB, 0b1011_0001), read(0xFFFE, 0x34), read(0xFFFF, 0x12)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var flatBus = &singlestep.FlatBus{}
+			for address, data := range test.memory {
+				flatBus.Poke(address, data)
+			}
+			runInstructionOn(t, flatBus, test.instruction, test.registers)
+			if accesses := flatBus.TakeAccesses(); !reflect.DeepEqual(accesses, test.accesses) {
+				t.Errorf("accesses\n%+v\nwant\n%+v", accesses, test.accesses)
+			}
+		})
+	}
+}
+
+func TestBrk(t *testing.T) {
+	var variants = []struct {
+		name      string
+		options   []cpu.Option
+		isDecimal bool
+	}{
+		{"2A03", nil, true},
+		// The 65C02 also clears the decimal flag
+		{"65C02", []cpu.Option{cpu.WithVariant(cpu.CMOS_65C02)}, false},
+	}
+	for _, variant := range variants {
+		t.Run(variant.name, func(t *testing.T) {
+			var flatBus = &singlestep.FlatBus{}
+			flatBus.Poke(0xFFFE, 0x34)
+			flatBus.Poke(0xFFFF, 0x12)
+			var testCPU, cycles = runInstructionOn(t, flatBus, []uint8{0x00}, cpu.Registers{Status: 0b0010_1000}, variant.options...)
+			var registers = testCPU.Registers()
+			if registers.ProgramCounter != 0x1234 || registers.StackPointer != 0xFA || cycles != 7 {
+				t.Errorf("PC=$%04X SP=$%02X after %d cycles, want PC=$1234 SP=$FA after 7 cycles", registers.ProgramCounter, registers.StackPointer, cycles)
+			}
+			if !testCPU.Flag(cpu.INTERRUPT_DISABLE_FLAG) || testCPU.Flag(cpu.DECIMAL_FLAG) != variant.isDecimal {
+				t.Errorf("flags %08b after BRK", registers.Status)
+			}
+		})
+	}
+
+	// The nestest runner stops on BRK instead
+	var flatBus = &singlestep.FlatBus{}
+	flatBus.Poke(PROGRAM_START, 0x00)
+	var testCPU = cpu.NewCPU(flatBus, cpu.WithStopOnBrk(true))
+	testCPU.SetTraceEnabled(false)
+	testCPU.SetRegisters(cpu.Registers{ProgramCounter: PROGRAM_START, StackPointer: 0xFD})
+	if testCPU.Step() {
+		t.Errorf("BRK did not stop the CPU")
+	}
+	if registers := testCPU.Registers(); registers.ProgramCounter != PROGRAM_START || registers.StackPointer != 0xFD {
+		t.Errorf("BRK stopping the CPU moved it to PC=$%04X SP=$%02X", registers.ProgramCounter, registers.StackPointer)
+	}
+}
+
+// A halted CPU keeps Step running so that the clock goes on, Run must still return
+func TestRunStopsOnKil(t *testing.T) {
+	var flatBus = &singlestep.FlatBus{}
+	flatBus.Poke(PROGRAM_START, 0xEA)   // NOP
+	flatBus.Poke(PROGRAM_START+1, 0x02) // KIL
+	var testCPU = cpu.NewCPU(flatBus)
+	testCPU.SetTraceEnabled(false)
+	testCPU.SetRegisters(cpu.Registers{ProgramCounter: PROGRAM_START, StackPointer: 0xFD})
+	var done = make(chan bool)
+	go func() {
+		testCPU.Run()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run() did not return after KIL")
+	}
+	if !testCPU.IsHalted() || testCPU.ProgramCounter() != PROGRAM_START+1 {
+		t.Errorf("halted %v at $%04X, want halted at $%04X", testCPU.IsHalted(), testCPU.ProgramCounter(), PROGRAM_START+1)
+	}
+}
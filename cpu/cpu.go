@@ -3,13 +3,19 @@ package cpu
 import (
 	"encoding/binary"
 	"fmt"
-	"nes-emulator/bus"
+	"nes-emulator/savestate"
 	"strings"
 )
 
 const STACK_BASE uint16 = 0x0100
 const STACK_RESET uint8 = 0xfd
 
+// https://www.nesdev.org/wiki/CPU_interrupts
+const IRQ_VECTOR uint16 = 0xFFFE
+
+// The reset sequence takes 7 cycles before the first instruction is fetched
+const RESET_CYCLES uint64 = 7
+
 type CPU struct {
 	registerA    uint8
 	registerX    uint8
@@ -32,7 +38,25 @@ type CPU struct {
 	// |+-------- Overflow
 	// +--------- Negative
 	programCounter uint16
-	bus            *bus.Bus
+	bus            Bus
+	// Same as bus, nil when the bus does not implement OpCodeFetcher
+	opCodeFetcher OpCodeFetcher
+	// Total of CPU cycles elapsed, used to clock the other devices of the bus
+	cycles uint64
+	// Prints a nestest-like log line before each instruction
+	isTraceEnabled bool
+	// Set by KIL : no instruction is executed until reset, while the clock keeps running
+	isHalted    bool
+	haltHandler func(address uint16)
+	// Called after each instruction, for code/data loggers and profilers
+	instructionHandler func(instruction ExecutedInstruction)
+	// Behaviour of the unstable unofficial opcodes, see options.go
+	unstableMagic       uint8
+	isHighByteCorrupted bool
+	// BRK stops the CPU instead of calling the IRQ handler, see options.go
+	isStoppedByBrk bool
+	// Chip emulated, see variant.go
+	variant Variant
 }
 
 // Generic helpers
@@ -75,20 +99,39 @@ func (cpu *CPU) setZeroFlagAndNegativeFlagForResult(result uint8) {
 
 // Memory helpers
 
+// Each bus access takes a cycle, during which the other devices are clocked
+// The CPU accesses the bus on every cycle : https://www.nesdev.org/6502_cpu.txt
 func (cpu *CPU) memoryRead(address uint16) uint8 {
-	return cpu.bus.MemoryRead(address)
+	var data = cpu.bus.MemoryRead(address)
+	cpu.tick(1)
+	return data
 }
 
 func (cpu *CPU) memoryWrite(address uint16, data uint8) {
 	cpu.bus.MemoryWrite(address, data)
+	cpu.tick(1)
+}
+
+func (cpu *CPU) fetchOpCode() uint8 {
+	if cpu.opCodeFetcher == nil {
+		return cpu.memoryRead(cpu.programCounter)
+	}
+	var data = cpu.opCodeFetcher.FetchOpCode(cpu.programCounter)
+	cpu.tick(1)
+	return data
 }
 
 func (cpu *CPU) memoryReadU16(address uint16) uint16 {
-	return cpu.bus.MemoryReadU16(address)
+	return binary.LittleEndian.Uint16([]uint8{cpu.memoryRead(address), cpu.memoryRead(address + 1)})
 }
 
-func (cpu *CPU) memoryWriteU16(address uint16, data uint16) {
-	cpu.bus.MemoryWriteU16(address, data)
+// Reads for the trace, without spending cycles nor side effects on the devices
+func (cpu *CPU) peekMemory(address uint16) uint8 {
+	return cpu.bus.Peek(address)
+}
+
+func (cpu *CPU) peekMemoryU16(address uint16) uint16 {
+	return binary.LittleEndian.Uint16([]uint8{cpu.peekMemory(address), cpu.peekMemory(address + 1)})
 }
 
 // Stack helpers
@@ -117,8 +160,18 @@ func (cpu *CPU) pullStackU16() uint16 {
 	return binary.LittleEndian.Uint16(bytes)
 }
 
+// Pulling spends a cycle reading the top of the stack before incrementing the stack pointer
+func (cpu *CPU) readStackTop() {
+	cpu.memoryRead(STACK_BASE + uint16(cpu.stackPointer))
+}
+
+func isPageCrossed(address1 uint16, address2 uint16) bool {
+	return address1&0xFF00 != address2&0xFF00
+}
+
 // This does not get the operand but the address of the operand, which will be the retrieved using memory read
-func (cpu *CPU) getOperandAddress(mode AddressingMode, opCodeProgramCounter uint16) uint16 {
+// Used by the trace, it reads memory without spending cycles
+func (cpu *CPU) traceOperandAddress(mode AddressingMode, opCodeProgramCounter uint16) uint16 {
 	// Program counter is where the opCode is located
 	switch mode {
 	case Implied:
@@ -128,7 +181,7 @@ func (cpu *CPU) getOperandAddress(mode AddressingMode, opCodeProgramCounter uint
 	case Immediate:
 		return opCodeProgramCounter + 1
 	case Relative:
-		var offset = cpu.memoryRead(opCodeProgramCounter + 1)
+		var offset = cpu.peekMemory(opCodeProgramCounter + 1)
 		if !isNegative(offset) {
 			return opCodeProgramCounter + uint16(offset) + 2
 		} else {
@@ -137,45 +190,145 @@ func (cpu *CPU) getOperandAddress(mode AddressingMode, opCodeProgramCounter uint
 	case ZeroPage:
 		// It's only a 8 bits address with Zero Page, so you can only get an address in the first 256 memory cells
 		// But it's faster !
-		return uint16(cpu.memoryRead(opCodeProgramCounter + 1))
+		return uint16(cpu.peekMemory(opCodeProgramCounter + 1))
 	case ZeroPageX:
-		var pos = cpu.memoryRead(opCodeProgramCounter + 1)
+		var pos = cpu.peekMemory(opCodeProgramCounter + 1)
 		return uint16(pos + cpu.registerX)
 	case ZeroPageY:
-		var pos = cpu.memoryRead(opCodeProgramCounter + 1)
+		var pos = cpu.peekMemory(opCodeProgramCounter + 1)
 		return uint16(pos + cpu.registerY)
 	case Absolute:
-		return cpu.memoryReadU16(opCodeProgramCounter + 1)
+		return cpu.peekMemoryU16(opCodeProgramCounter + 1)
 	case AbsoluteX:
-		var pos = cpu.memoryReadU16(opCodeProgramCounter + 1)
+		var pos = cpu.peekMemoryU16(opCodeProgramCounter + 1)
 		return pos + uint16(cpu.registerX)
 	case AbsoluteY:
-		var pos = cpu.memoryReadU16(opCodeProgramCounter + 1)
+		var pos = cpu.peekMemoryU16(opCodeProgramCounter + 1)
 		return pos + uint16(cpu.registerY)
 	case Indirect:
-		var ref = cpu.memoryReadU16(opCodeProgramCounter + 1)
+		var ref = cpu.peekMemoryU16(opCodeProgramCounter + 1)
 		// Bug with page boundary:
 		// If we try to read the end of a page X and the beginning of a page X + 1
 		// Instead JMP will read the end of the page X and the beginning of the page X
-		if ref&0x00FF == 0x00FF {
+		// The 65C02 fixed it
+		if ref&0x00FF == 0x00FF && cpu.variant != CMOS_65C02 {
 			var pageBeginning = ref & 0xFF00
-			return binary.LittleEndian.Uint16([]uint8{cpu.memoryRead(ref), cpu.memoryRead(pageBeginning)})
+			return binary.LittleEndian.Uint16([]uint8{cpu.peekMemory(ref), cpu.peekMemory(pageBeginning)})
 		} else {
-			return cpu.memoryReadU16(ref)
+			return cpu.peekMemoryU16(ref)
 		}
 	case IndirectX:
-		var base = cpu.memoryRead(opCodeProgramCounter + 1)
+		var base = cpu.peekMemory(opCodeProgramCounter + 1)
 		// Cannot use cpu.memoryRead16 as we need to wrap the address !
-		return binary.LittleEndian.Uint16([]uint8{cpu.memoryRead(uint16(base + cpu.registerX)), cpu.memoryRead(uint16(base + cpu.registerX + 1))})
+		return binary.LittleEndian.Uint16([]uint8{cpu.peekMemory(uint16(base + cpu.registerX)), cpu.peekMemory(uint16(base + cpu.registerX + 1))})
 	case IndirectY:
-		var base = cpu.memoryRead(opCodeProgramCounter + 1)
+		var base = cpu.peekMemory(opCodeProgramCounter + 1)
 		// Cannot use cpu.memoryRead16 as we need to wrap the address !
-		return binary.LittleEndian.Uint16([]uint8{cpu.memoryRead(uint16(base)), cpu.memoryRead(uint16(base + 1))}) + uint16(cpu.registerY)
+		var pos = binary.LittleEndian.Uint16([]uint8{cpu.peekMemory(uint16(base)), cpu.peekMemory(uint16(base + 1))})
+		return pos + uint16(cpu.registerY)
+	case ZeroPageIndirect:
+		var base = cpu.peekMemory(opCodeProgramCounter + 1)
+		return binary.LittleEndian.Uint16([]uint8{cpu.peekMemory(uint16(base)), cpu.peekMemory(uint16(base + 1))})
+	case AbsoluteIndexedIndirect:
+		var ref = cpu.peekMemoryU16(opCodeProgramCounter+1) + uint16(cpu.registerX)
+		return cpu.peekMemoryU16(ref)
 	default:
 		panic(fmt.Sprintf("addressing mode %v is not supported", mode))
 	}
 }
 
+// Fetches the operand and computes its address with the bus accesses of the 6502, dummy reads included
+// https://www.nesdev.org/6502_cpu.txt
+func (cpu *CPU) fetchOperandAddress(opCode OpCode) uint16 {
+	var programCounter = cpu.programCounter
+	switch opCode.addressingMode {
+	case Implied, Accumulator:
+		// The byte following the opcode is read and ignored, except by the single cycle NOPs of the 65C02
+		if opCode.cycles != 1 {
+			cpu.memoryRead(programCounter + 1)
+		}
+		return 0
+	case Immediate:
+		return programCounter + 1
+	case Relative:
+		var offset = cpu.memoryRead(programCounter + 1)
+		return programCounter + 2 + uint16(int8(offset))
+	case ZeroPage:
+		return uint16(cpu.memoryRead(programCounter + 1))
+	case ZeroPageX, ZeroPageY:
+		var base = cpu.memoryRead(programCounter + 1)
+		// The base address is read while the index is added
+		cpu.memoryRead(cpu.dummyReadAddress(opCode, uint16(base)))
+		if opCode.addressingMode == ZeroPageX {
+			return uint16(base + cpu.registerX)
+		}
+		return uint16(base + cpu.registerY)
+	case Absolute:
+		return cpu.memoryReadU16(programCounter + 1)
+	case AbsoluteX:
+		return cpu.indexAddress(opCode, cpu.memoryReadU16(programCounter+1), cpu.registerX)
+	case AbsoluteY:
+		return cpu.indexAddress(opCode, cpu.memoryReadU16(programCounter+1), cpu.registerY)
+	case Indirect:
+		var pointer = cpu.memoryReadU16(programCounter + 1)
+		if cpu.variant == CMOS_65C02 {
+			// The 65C02 spends a cycle to increment the whole pointer
+			cpu.memoryRead(programCounter + 2)
+			return cpu.memoryReadU16(pointer)
+		}
+		// The high byte of the pointer is not incremented : JMP ($xxFF) reads its target from $xxFF and $xx00
+		var low = cpu.memoryRead(pointer)
+		var high = cpu.memoryRead(pointer&0xFF00 | uint16(uint8(pointer)+1))
+		return uint16(high)<<8 | uint16(low)
+	case IndirectX:
+		var pointer = cpu.memoryRead(programCounter + 1)
+		cpu.memoryRead(cpu.dummyReadAddress(opCode, uint16(pointer)))
+		pointer += cpu.registerX
+		// The pointer wraps around the zero page
+		var low = cpu.memoryRead(uint16(pointer))
+		var high = cpu.memoryRead(uint16(pointer + 1))
+		return uint16(high)<<8 | uint16(low)
+	case IndirectY:
+		var pointer = cpu.memoryRead(programCounter + 1)
+		var low = cpu.memoryRead(uint16(pointer))
+		var high = cpu.memoryRead(uint16(pointer + 1))
+		return cpu.indexAddress(opCode, uint16(high)<<8|uint16(low), cpu.registerY)
+	case ZeroPageIndirect:
+		var pointer = cpu.memoryRead(programCounter + 1)
+		var low = cpu.memoryRead(uint16(pointer))
+		var high = cpu.memoryRead(uint16(pointer + 1))
+		return uint16(high)<<8 | uint16(low)
+	case AbsoluteIndexedIndirect:
+		var pointer = cpu.memoryReadU16(programCounter + 1)
+		cpu.memoryRead(programCounter + 2)
+		return cpu.memoryReadU16(pointer + uint16(cpu.registerX))
+	default:
+		panic(fmt.Sprintf("addressing mode %v is not supported", opCode.addressingMode))
+	}
+}
+
+// The index is added to the low byte first : the address is read before the carry reaches the high byte
+// Reads only spend that cycle when a page is crossed, since the address read is then wrong
+func (cpu *CPU) indexAddress(opCode OpCode, base uint16, index uint8) uint16 {
+	var address = base + uint16(index)
+	var isCycleSpent = isPageCrossed(base, address) || !isReadOperation(opCode.operation)
+	if cpu.variant == CMOS_65C02 && isShiftOperation(opCode.operation) {
+		isCycleSpent = isPageCrossed(base, address)
+	}
+	if isCycleSpent {
+		cpu.memoryRead(cpu.dummyReadAddress(opCode, base&0xFF00|address&0x00FF))
+	}
+	return address
+}
+
+// The 65C02 reads the last byte of the instruction again instead of a possibly invalid address
+func (cpu *CPU) dummyReadAddress(opCode OpCode, address uint16) uint16 {
+	if cpu.variant == CMOS_65C02 {
+		return cpu.programCounter + opCode.Size() - 1
+	}
+	return address
+}
+
 // Helpers for Ops Code operations
 
 // http://www.righto.com/2012/12/the-6502-overflow-flag-explained.html
@@ -192,19 +345,75 @@ func (cpu *CPU) addWithCarry(a uint8, b uint8, carry bool) (uint8, bool, bool) {
 
 func (cpu *CPU) branch(cpuStepInfos *StepInfos, condition bool) {
 	if condition {
-		cpu.programCounter = cpuStepInfos.operandAddress
+		// Taking the branch costs a read of the next instruction, and one more if it lands on another page
+		// as the high byte of the target is fixed after its low byte
+		var nextInstruction = cpu.programCounter + getNumberOfBytesReadForOperation(Relative)
+		cpu.memoryRead(nextInstruction)
+		if isPageCrossed(nextInstruction, cpuStepInfos.operandAddress) {
+			cpu.memoryRead(nextInstruction&0xFF00 | cpuStepInfos.operandAddress&0x00FF)
+		}
+		cpu.jumpTo(cpuStepInfos, cpuStepInfos.operandAddress)
+	}
+}
+
+// Read-modify-write instructions write the unmodified value back while they compute the result
+// The 65C02 reads it again instead
+func (cpu *CPU) readForModify(address uint16) uint8 {
+	var operand = cpu.memoryRead(address)
+	if cpu.variant == CMOS_65C02 {
+		cpu.memoryRead(address)
+	} else {
+		cpu.memoryWrite(address, operand)
+	}
+	return operand
+}
+
+// Jumping to the current instruction is valid (infinite loops), so the new program counter must be flagged
+func (cpu *CPU) jumpTo(cpuStepInfos *StepInfos, address uint16) {
+	cpu.programCounter = address
+	cpuStepInfos.hasJumped = true
+}
+
+// Interrupts
+
+// https://www.nesdev.org/wiki/CPU_interrupts
+func (cpu *CPU) interrupt(vector uint16) {
+	// The next instruction is fetched twice but not executed
+	cpu.memoryRead(cpu.programCounter)
+	cpu.memoryRead(cpu.programCounter)
+	cpu.enterInterruptHandler(vector, cpu.programCounter, false)
+}
+
+// Pushes the return address and the flags, then jumps to the handler of the vector
+// The B flag tells BRK from IRQ and NMI : https://www.nesdev.org/wiki/Status_flags#The_B_flag
+func (cpu *CPU) enterInterruptHandler(vector uint16, returnAddress uint16, isBreak bool) {
+	cpu.pushStackU16(returnAddress)
+	var status = cpu.statusFlags | uint8(BREAK_2_FLAG)
+	if isBreak {
+		status |= uint8(BREAK_FLAG)
+	} else {
+		status &= ^uint8(BREAK_FLAG)
 	}
+	cpu.pushStack(status)
+	cpu.setFlagToValue(INTERRUPT_DISABLE_FLAG, true)
+	if cpu.variant == CMOS_65C02 {
+		cpu.setFlagToValue(DECIMAL_FLAG, false)
+	}
+	cpu.programCounter = cpu.memoryReadU16(vector)
+}
+
+// Clocking
+
+func (cpu *CPU) tick(cycles int) {
+	cpu.cycles += uint64(cycles)
+	cpu.bus.Tick(cycles)
 }
 
 // Ops code operations
 
 func (cpu *CPU) adc(cpuStepInfos *StepInfos) {
 	var operand = cpu.memoryRead(cpuStepInfos.operandAddress)
-	result, hasCarry, hasOverflow := cpu.addWithCarry(cpu.registerA, operand, cpu.isFlagSet(CARRY_FLAG))
-	cpu.registerA = result
-	cpu.setFlagToValue(CARRY_FLAG, hasCarry)
-	cpu.setFlagToValue(OVERFLOW_FLAG, hasOverflow)
-	cpu.setZeroFlagAndNegativeFlagForResult(cpu.registerA)
+	cpu.addToAccumulator(cpuStepInfos, operand)
 }
 
 func (cpu *CPU) and(cpuStepInfos *StepInfos) {
@@ -219,7 +428,7 @@ func (cpu *CPU) asl(cpuStepInfos *StepInfos) {
 		cpu.registerA = cpu.registerA << 1
 		cpu.setZeroFlagAndNegativeFlagForResult(cpu.registerA)
 	} else {
-		var operand = cpu.memoryRead(cpuStepInfos.operandAddress)
+		var operand = cpu.readForModify(cpuStepInfos.operandAddress)
 		cpu.setFlagToValue(CARRY_FLAG, operand&0b1000_0000 != 0)
 		var result = operand << 1
 		cpu.memoryWrite(cpuStepInfos.operandAddress, result)
@@ -243,6 +452,10 @@ func (cpu *CPU) bit(cpuStepInfos *StepInfos) {
 	var operand = cpu.memoryRead(cpuStepInfos.operandAddress)
 	var result = operand & cpu.registerA
 	cpu.setFlagToValue(ZERO_FLAG, result == 0)
+	// BIT #immediate of the 65C02 only sets Z
+	if cpuStepInfos.opCode.addressingMode == Immediate {
+		return
+	}
 	cpu.setFlagToValue(NEGATIVE_FLAG, isNegative(operand))
 	cpu.setFlagToValue(OVERFLOW_FLAG, operand&0b0100_0000 != 0)
 }
@@ -259,6 +472,12 @@ func (cpu *CPU) bpl(cpuStepInfos *StepInfos) {
 	cpu.branch(cpuStepInfos, !cpu.isFlagSet(NEGATIVE_FLAG))
 }
 
+// The padding byte following BRK has been read as the operand of an implied instruction, the return address skips it
+func (cpu *CPU) brk(cpuStepInfos *StepInfos) {
+	cpu.enterInterruptHandler(IRQ_VECTOR, cpu.programCounter+2, true)
+	cpuStepInfos.hasJumped = true
+}
+
 func (cpu *CPU) bvc(cpuStepInfos *StepInfos) {
 	cpu.branch(cpuStepInfos, !cpu.isFlagSet(OVERFLOW_FLAG))
 }
@@ -284,7 +503,10 @@ func (cpu *CPU) clv(cpuStepInfos *StepInfos) {
 }
 
 func (cpu *CPU) compare(cpuStepInfos *StepInfos, compareWith uint8) {
-	var operand = cpu.memoryRead(cpuStepInfos.operandAddress)
+	cpu.compareValues(compareWith, cpu.memoryRead(cpuStepInfos.operandAddress))
+}
+
+func (cpu *CPU) compareValues(compareWith uint8, operand uint8) {
 	var result = compareWith - operand
 	cpu.setZeroFlagAndNegativeFlagForResult(result)
 	cpu.setFlagToValue(CARRY_FLAG, compareWith >= operand)
@@ -303,7 +525,12 @@ func (cpu *CPU) cpy(cpuStepInfos *StepInfos) {
 }
 
 func (cpu *CPU) dec(cpuStepInfos *StepInfos) {
-	var operand = cpu.memoryRead(cpuStepInfos.operandAddress)
+	if cpuStepInfos.opCode.addressingMode == Accumulator {
+		cpu.registerA -= 1
+		cpu.setZeroFlagAndNegativeFlagForResult(cpu.registerA)
+		return
+	}
+	var operand = cpu.readForModify(cpuStepInfos.operandAddress)
 	var result = operand - 1
 	cpu.memoryWrite(cpuStepInfos.operandAddress, result)
 	cpu.setZeroFlagAndNegativeFlagForResult(result)
@@ -326,7 +553,12 @@ func (cpu *CPU) eor(cpuStepInfos *StepInfos) {
 }
 
 func (cpu *CPU) inc(cpuStepInfos *StepInfos) {
-	var operand = cpu.memoryRead(cpuStepInfos.operandAddress)
+	if cpuStepInfos.opCode.addressingMode == Accumulator {
+		cpu.registerA += 1
+		cpu.setZeroFlagAndNegativeFlagForResult(cpu.registerA)
+		return
+	}
+	var operand = cpu.readForModify(cpuStepInfos.operandAddress)
 	var result = operand + 1
 	cpu.memoryWrite(cpuStepInfos.operandAddress, result)
 	cpu.setZeroFlagAndNegativeFlagForResult(result)
@@ -344,12 +576,17 @@ func (cpu *CPU) iny(cpuStepInfos *StepInfos) {
 
 func (cpu *CPU) jmp(cpuStepInfos *StepInfos) {
 	// TODO : some shady shit is done here in the tutorial, wtf ??
-	cpu.programCounter = cpuStepInfos.operandAddress
+	cpu.jumpTo(cpuStepInfos, cpuStepInfos.operandAddress)
 }
 
+// The high byte of the target is fetched last, once the return address is pushed
 func (cpu *CPU) jsr(cpuStepInfos *StepInfos) {
+	var low = cpu.memoryRead(cpu.programCounter + 1)
+	cpu.readStackTop()
 	cpu.pushStackU16(cpu.programCounter + getNumberOfBytesReadForOperation(cpuStepInfos.opCode.addressingMode) - 1)
-	cpu.programCounter = cpuStepInfos.operandAddress
+	var high = cpu.memoryRead(cpu.programCounter + 2)
+	cpuStepInfos.operandAddress = uint16(high)<<8 | uint16(low)
+	cpu.jumpTo(cpuStepInfos, cpuStepInfos.operandAddress)
 }
 
 func (cpu *CPU) lda(cpuStepInfos *StepInfos) {
@@ -376,7 +613,7 @@ func (cpu *CPU) lsr(cpuStepInfos *StepInfos) {
 		cpu.registerA = cpu.registerA >> 1
 		cpu.setZeroFlagAndNegativeFlagForResult(cpu.registerA)
 	} else {
-		var operand = cpu.memoryRead(cpuStepInfos.operandAddress)
+		var operand = cpu.readForModify(cpuStepInfos.operandAddress)
 		cpu.setFlagToValue(CARRY_FLAG, operand&0b0000_0001 != 0)
 		var result = operand >> 1
 		cpu.memoryWrite(cpuStepInfos.operandAddress, result)
@@ -384,7 +621,12 @@ func (cpu *CPU) lsr(cpuStepInfos *StepInfos) {
 	}
 }
 
-func (cpu *CPU) nop(cpuStepInfos *StepInfos) {}
+// NOPs of the 65C02 with an operand read it
+func (cpu *CPU) nop(cpuStepInfos *StepInfos) {
+	if cpuStepInfos.opCode.addressingMode != Implied {
+		cpu.memoryRead(cpuStepInfos.operandAddress)
+	}
+}
 
 func (cpu *CPU) ora(cpuStepInfos *StepInfos) {
 	var operand = cpu.memoryRead(cpuStepInfos.operandAddress)
@@ -402,11 +644,13 @@ func (cpu *CPU) php(cpuStepInfos *StepInfos) {
 }
 
 func (cpu *CPU) pla(cpuStepInfos *StepInfos) {
+	cpu.readStackTop()
 	cpu.registerA = cpu.pullStack()
 	cpu.setZeroFlagAndNegativeFlagForResult(cpu.registerA)
 }
 
 func (cpu *CPU) plp(cpuStepInfos *StepInfos) {
+	cpu.readStackTop()
 	cpu.statusFlags = cpu.pullStack()
 	cpu.setFlagToValue(BREAK_FLAG, false)
 	cpu.setFlagToValue(BREAK_2_FLAG, true)
@@ -422,7 +666,7 @@ func (cpu *CPU) rol(cpuStepInfos *StepInfos) {
 		cpu.registerA = (cpu.registerA << 1) | carryMask
 		cpu.setZeroFlagAndNegativeFlagForResult(cpu.registerA)
 	} else {
-		var operand = cpu.memoryRead(cpuStepInfos.operandAddress)
+		var operand = cpu.readForModify(cpuStepInfos.operandAddress)
 		cpu.setFlagToValue(CARRY_FLAG, operand&0b1000_0000 != 0)
 		var result = operand<<1 | carryMask
 		cpu.memoryWrite(cpuStepInfos.operandAddress, result)
@@ -440,7 +684,7 @@ func (cpu *CPU) ror(cpuStepInfos *StepInfos) {
 		cpu.registerA = (cpu.registerA >> 1) | carryMask
 		cpu.setZeroFlagAndNegativeFlagForResult(cpu.registerA)
 	} else {
-		var operand = cpu.memoryRead(cpuStepInfos.operandAddress)
+		var operand = cpu.readForModify(cpuStepInfos.operandAddress)
 		cpu.setFlagToValue(CARRY_FLAG, operand&0b0000_0001 != 0)
 		var result = operand>>1 | carryMask
 		cpu.memoryWrite(cpuStepInfos.operandAddress, result)
@@ -449,24 +693,24 @@ func (cpu *CPU) ror(cpuStepInfos *StepInfos) {
 }
 
 func (cpu *CPU) rti(cpuStepInfos *StepInfos) {
+	cpu.readStackTop()
 	cpu.statusFlags = cpu.pullStack()
 	cpu.setFlagToValue(BREAK_FLAG, false)
 	cpu.setFlagToValue(BREAK_2_FLAG, true)
-	cpu.programCounter = cpu.pullStackU16()
+	cpu.jumpTo(cpuStepInfos, cpu.pullStackU16())
 }
 
 func (cpu *CPU) rts(cpuStepInfos *StepInfos) {
-	cpu.programCounter = cpu.pullStackU16() + 1
+	cpu.readStackTop()
+	var returnAddress = cpu.pullStackU16()
+	// The program counter is incremented past the JSR during an extra cycle
+	cpu.memoryRead(returnAddress)
+	cpu.jumpTo(cpuStepInfos, returnAddress+1)
 }
 
 func (cpu *CPU) sbc(cpuStepInfos *StepInfos) {
 	var operand = cpu.memoryRead(cpuStepInfos.operandAddress)
-	// Result calculated is A-M-(1-C) = A + (256 - M) - 1 + C = A + (255 - M) + C
-	result, hasCarry, hasOverflow := cpu.addWithCarry(cpu.registerA, 255-operand, cpu.isFlagSet(CARRY_FLAG))
-	cpu.registerA = result
-	cpu.setFlagToValue(CARRY_FLAG, hasCarry)
-	cpu.setFlagToValue(OVERFLOW_FLAG, hasOverflow)
-	cpu.setZeroFlagAndNegativeFlagForResult(cpu.registerA)
+	cpu.subtractFromAccumulator(cpuStepInfos, operand)
 }
 
 func (cpu *CPU) sec(cpuStepInfos *StepInfos) {
@@ -522,6 +766,52 @@ func (cpu *CPU) tya(cpuStepInfos *StepInfos) {
 	cpu.setZeroFlagAndNegativeFlagForResult(cpu.registerA)
 }
 
+/***********************/
+/* 65C02 OPCODES
+/***********************/
+
+func (cpu *CPU) bra(cpuStepInfos *StepInfos) {
+	cpu.branch(cpuStepInfos, true)
+}
+
+func (cpu *CPU) phx(cpuStepInfos *StepInfos) {
+	cpu.pushStack(cpu.registerX)
+}
+
+func (cpu *CPU) phy(cpuStepInfos *StepInfos) {
+	cpu.pushStack(cpu.registerY)
+}
+
+func (cpu *CPU) plx(cpuStepInfos *StepInfos) {
+	cpu.readStackTop()
+	cpu.registerX = cpu.pullStack()
+	cpu.setZeroFlagAndNegativeFlagForResult(cpu.registerX)
+}
+
+func (cpu *CPU) ply(cpuStepInfos *StepInfos) {
+	cpu.readStackTop()
+	cpu.registerY = cpu.pullStack()
+	cpu.setZeroFlagAndNegativeFlagForResult(cpu.registerY)
+}
+
+func (cpu *CPU) stz(cpuStepInfos *StepInfos) {
+	cpu.memoryWrite(cpuStepInfos.operandAddress, 0)
+}
+
+// Z is set from A & M, then the bits of A are cleared in M
+func (cpu *CPU) trb(cpuStepInfos *StepInfos) {
+	var operand = cpu.readForModify(cpuStepInfos.operandAddress)
+	cpu.setFlagToValue(ZERO_FLAG, operand&cpu.registerA == 0)
+	cpu.memoryWrite(cpuStepInfos.operandAddress, operand&^cpu.registerA)
+}
+
+// Z is set from A & M, then the bits of A are set in M
+func (cpu *CPU) tsb(cpuStepInfos *StepInfos) {
+	var operand = cpu.readForModify(cpuStepInfos.operandAddress)
+	cpu.setFlagToValue(ZERO_FLAG, operand&cpu.registerA == 0)
+	cpu.memoryWrite(cpuStepInfos.operandAddress, operand|cpu.registerA)
+}
+
 /***********************/
 /* UNDOCUMENTED OPCODES
 // TODO: some of them could probably be simplified (like those combining operations could reuse basic operations)
@@ -564,43 +854,48 @@ func (cpu *CPU) asr(cpuStepInfos *StepInfos) {
 
 func (cpu *CPU) atx(cpuStepInfos *StepInfos) {
 	var operand = cpu.memoryRead(cpuStepInfos.operandAddress)
-	cpu.registerA = operand & cpu.registerA
+	cpu.registerA = (cpu.registerA | cpu.unstableMagic) & operand
 	cpu.registerX = cpu.registerA
 	cpu.setZeroFlagAndNegativeFlagForResult(cpu.registerX)
 }
 
 func (cpu *CPU) axa(cpuStepInfos *StepInfos) {
-	var result = cpu.registerA & cpu.registerX & uint8(cpuStepInfos.operandAddress>>8)
-	cpu.memoryWrite(cpuStepInfos.operandAddress, result)
+	cpu.storeAndHighByte(cpuStepInfos, cpu.registerA&cpu.registerX, cpu.registerY)
 }
 
+// Also known as SBX : X = (A & X) - immediate, flags set as CMP does
 func (cpu *CPU) axs(cpuStepInfos *StepInfos) {
-	cpu.memoryWrite(cpuStepInfos.operandAddress, cpu.registerA)
-	cpu.memoryWrite(cpuStepInfos.operandAddress, cpu.registerX)
+	var operand = cpu.memoryRead(cpuStepInfos.operandAddress)
+	var value = cpu.registerA & cpu.registerX
+	cpu.compareValues(value, operand)
+	cpu.registerX = value - operand
 }
 
 func (cpu *CPU) dcp(cpuStepInfos *StepInfos) {
-	var operand = cpu.memoryRead(cpuStepInfos.operandAddress)
+	var operand = cpu.readForModify(cpuStepInfos.operandAddress)
 	cpu.memoryWrite(cpuStepInfos.operandAddress, operand-1)
-	cpu.compare(cpuStepInfos, cpu.registerA)
+	cpu.compareValues(cpu.registerA, operand-1)
 }
 
-func (cpu *CPU) dop(cpuStepInfos *StepInfos) {}
+// The operand is read and ignored
+func (cpu *CPU) dop(cpuStepInfos *StepInfos) {
+	cpu.memoryRead(cpuStepInfos.operandAddress)
+}
 
 func (cpu *CPU) isc(cpuStepInfos *StepInfos) {
-	var operand = cpu.memoryRead(cpuStepInfos.operandAddress)
+	var operand = cpu.readForModify(cpuStepInfos.operandAddress)
 	var result = operand + 1
 	cpu.memoryWrite(cpuStepInfos.operandAddress, result)
-	// Result calculated is A-M-(1-C) = A + (256 - M) - 1 + C = A + (255 - M) + C
-	var resultAdd, hasCarry, hasOverflow = cpu.addWithCarry(cpu.registerA, 255-result, cpu.isFlagSet(CARRY_FLAG))
-	cpu.registerA = resultAdd
-	cpu.setFlagToValue(CARRY_FLAG, hasCarry)
-	cpu.setFlagToValue(OVERFLOW_FLAG, hasOverflow)
-	cpu.setZeroFlagAndNegativeFlagForResult(cpu.registerA)
+	cpu.subtractFromAccumulator(cpuStepInfos, result)
 }
 
+// The CPU locks up, the program counter stays on the KIL instruction
 func (cpu *CPU) kil(cpuStepInfos *StepInfos) {
-	panic(fmt.Sprintf("kil opcode executed (hex code : %X)", cpuStepInfos.opHexCode))
+	cpu.isHalted = true
+	cpu.jumpTo(cpuStepInfos, cpu.programCounter)
+	if cpu.haltHandler != nil {
+		cpu.haltHandler(cpu.programCounter)
+	}
 }
 
 func (cpu *CPU) lar(cpuStepInfos *StepInfos) {
@@ -624,7 +919,7 @@ func (cpu *CPU) rla(cpuStepInfos *StepInfos) {
 	if cpu.isFlagSet(CARRY_FLAG) {
 		carryMask = 0b0000_0001
 	}
-	var operand = cpu.memoryRead(cpuStepInfos.operandAddress)
+	var operand = cpu.readForModify(cpuStepInfos.operandAddress)
 	cpu.setFlagToValue(CARRY_FLAG, operand&0b1000_0000 != 0)
 	var result = operand<<1 | carryMask
 	cpu.memoryWrite(cpuStepInfos.operandAddress, result)
@@ -637,20 +932,15 @@ func (cpu *CPU) rra(cpuStepInfos *StepInfos) {
 	if cpu.isFlagSet(CARRY_FLAG) {
 		carryMask = 0b1000_0000
 	}
-	var operand = cpu.memoryRead(cpuStepInfos.operandAddress)
+	var operand = cpu.readForModify(cpuStepInfos.operandAddress)
 	cpu.setFlagToValue(CARRY_FLAG, operand&0b0000_0001 != 0)
 	var result = operand>>1 | carryMask
 	cpu.memoryWrite(cpuStepInfos.operandAddress, result)
-	cpu.setZeroFlagAndNegativeFlagForResult(result)
-	resultAdd, hasCarry, hasOverflow := cpu.addWithCarry(cpu.registerA, result, cpu.isFlagSet(CARRY_FLAG))
-	cpu.registerA = resultAdd
-	cpu.setFlagToValue(CARRY_FLAG, hasCarry)
-	cpu.setFlagToValue(OVERFLOW_FLAG, hasOverflow)
-	cpu.setZeroFlagAndNegativeFlagForResult(cpu.registerA)
+	cpu.addToAccumulator(cpuStepInfos, result)
 }
 
 func (cpu *CPU) slo(cpuStepInfos *StepInfos) {
-	var operand = cpu.memoryRead(cpuStepInfos.operandAddress)
+	var operand = cpu.readForModify(cpuStepInfos.operandAddress)
 	cpu.setFlagToValue(CARRY_FLAG, operand&0b1000_0000 != 0)
 	var result = operand << 1
 	cpu.memoryWrite(cpuStepInfos.operandAddress, result)
@@ -659,7 +949,7 @@ func (cpu *CPU) slo(cpuStepInfos *StepInfos) {
 }
 
 func (cpu *CPU) sre(cpuStepInfos *StepInfos) {
-	var operand = cpu.memoryRead(cpuStepInfos.operandAddress)
+	var operand = cpu.readForModify(cpuStepInfos.operandAddress)
 	cpu.setFlagToValue(CARRY_FLAG, operand&0b0000_0001 != 0)
 	var result = operand >> 1
 	cpu.memoryWrite(cpuStepInfos.operandAddress, result)
@@ -668,26 +958,39 @@ func (cpu *CPU) sre(cpuStepInfos *StepInfos) {
 }
 
 func (cpu *CPU) sxa(cpuStepInfos *StepInfos) {
-	var result = (uint8(cpuStepInfos.operandAddress>>8) + 1) & cpu.registerX
-	cpu.memoryWrite(cpuStepInfos.operandAddress, result)
+	cpu.storeAndHighByte(cpuStepInfos, cpu.registerX, cpu.registerY)
 }
 
 func (cpu *CPU) sya(cpuStepInfos *StepInfos) {
-	var result = (uint8(cpuStepInfos.operandAddress>>8) + 1) & cpu.registerY
-	cpu.memoryWrite(cpuStepInfos.operandAddress, result)
+	cpu.storeAndHighByte(cpuStepInfos, cpu.registerY, cpu.registerX)
 }
 
-func (cpu *CPU) top(cpuStepInfos *StepInfos) {}
+func (cpu *CPU) top(cpuStepInfos *StepInfos) {
+	cpu.memoryRead(cpuStepInfos.operandAddress)
+}
 
 func (cpu *CPU) xaa(cpuStepInfos *StepInfos) {
 	// Unreliable Opcode : https://www.nesdev.org/wiki/Visual6502wiki/6502_Opcode_8B_(XAA,_ANE)
-	panic(fmt.Sprintf("xaa opcode executed (hex code : %X)", cpuStepInfos.opHexCode))
+	var operand = cpu.memoryRead(cpuStepInfos.operandAddress)
+	cpu.registerA = (cpu.registerA | cpu.unstableMagic) & cpu.registerX & operand
+	cpu.setZeroFlagAndNegativeFlagForResult(cpu.registerA)
 }
 
 func (cpu *CPU) xas(cpuStepInfos *StepInfos) {
 	cpu.stackPointer = cpu.registerA & cpu.registerX
-	var result = (uint8(cpuStepInfos.operandAddress>>8) + 1) & cpu.stackPointer
-	cpu.memoryWrite(cpuStepInfos.operandAddress, result)
+	cpu.storeAndHighByte(cpuStepInfos, cpu.stackPointer, cpu.registerY)
+}
+
+// Stores value & (high byte of the base address + 1), index being the register added to the base address
+// https://www.nesdev.org/wiki/CPU_unofficial_opcodes#Unstable_opcodes
+func (cpu *CPU) storeAndHighByte(cpuStepInfos *StepInfos, value uint8, index uint8) {
+	var base = cpuStepInfos.operandAddress - uint16(index)
+	var result = value & (uint8(base>>8) + 1)
+	var address = cpuStepInfos.operandAddress
+	if cpu.isHighByteCorrupted && isPageCrossed(base, address) {
+		address = uint16(result)<<8 | address&0x00FF
+	}
+	cpu.memoryWrite(address, result)
 }
 
 /***********************/
@@ -696,218 +999,387 @@ func (cpu *CPU) xas(cpuStepInfos *StepInfos) {
 
 // Load program and reset CPU
 
-func NewCPU(consoleBus *bus.Bus) CPU {
+func NewCPU(consoleBus Bus, options ...Option) CPU {
 	var cpu = CPU{
-		registerA:      0,
-		registerX:      0,
-		registerY:      0,
-		statusFlags:    0b00100100,
-		stackPointer:   STACK_RESET,
-		programCounter: 0,
-		bus:            consoleBus,
+		registerA:           0,
+		registerX:           0,
+		registerY:           0,
+		statusFlags:         0b00100100,
+		stackPointer:        STACK_RESET,
+		programCounter:      0,
+		bus:                 consoleBus,
+		isTraceEnabled:      true,
+		unstableMagic:       DEFAULT_UNSTABLE_MAGIC,
+		isHighByteCorrupted: true,
+	}
+	if fetcher, isFetcher := consoleBus.(OpCodeFetcher); isFetcher {
+		cpu.opCodeFetcher = fetcher
+	}
+	for _, option := range options {
+		option(&cpu)
 	}
 	return cpu
 }
 
-func (cpu *CPU) Reset() {
+// Cold boot : registers go back to their power-up values
+// https://www.nesdev.org/wiki/CPU_power_up_state
+func (cpu *CPU) PowerOn() {
 	cpu.registerA = 0
 	cpu.registerX = 0
 	cpu.registerY = 0
 	cpu.statusFlags = 0b00100100
 	cpu.stackPointer = STACK_RESET
 	cpu.programCounter = 0xC000 //cpu.memoryReadU16(0xFFFC) uncomment when PPU is implemented
+	cpu.cycles = RESET_CYCLES
+	cpu.isHalted = false
+}
+
+// Reset button : registers are kept, except the stack pointer decremented by the 3 suppressed pushes
+// of the reset sequence and the interrupt disable flag
+func (cpu *CPU) Reset() {
+	cpu.stackPointer -= 3
+	cpu.setFlagToValue(INTERRUPT_DISABLE_FLAG, true)
+	if cpu.variant == CMOS_65C02 {
+		cpu.setFlagToValue(DECIMAL_FLAG, false)
+	}
+	cpu.programCounter = 0xC000 //cpu.memoryReadU16(0xFFFC) uncomment when PPU is implemented
+	cpu.isHalted = false
+	cpu.tick(int(RESET_CYCLES))
+}
+
+func (cpu *CPU) SerializeState(state *savestate.Serializer) {
+	state.Uint8(&cpu.registerA)
+	state.Uint8(&cpu.registerX)
+	state.Uint8(&cpu.registerY)
+	state.Uint8(&cpu.stackPointer)
+	state.Uint8(&cpu.statusFlags)
+	state.Uint16(&cpu.programCounter)
+	state.Uint64(&cpu.cycles)
+	state.Bool(&cpu.isHalted)
+}
+
+// Trace line of the instruction at the program counter, in the same format as the trace printed while running
+func (cpu *CPU) TraceNextInstruction() string {
+	var opHexCode = cpu.peekMemory(cpu.programCounter)
+	var opCode, isKnown = DecodeVariantOpCode(cpu.variant, opHexCode)
+	if !isKnown {
+		return fmt.Sprintf("%04X  %02X        ???", cpu.programCounter, opHexCode)
+	}
+	return formatCPUState(cpu, &StepInfos{
+		opHexCode:      opHexCode,
+		opCode:         opCode,
+		operandAddress: cpu.traceOperandAddress(opCode.addressingMode, cpu.programCounter),
+	})
+}
+
+func (cpu *CPU) SetTraceEnabled(isTraceEnabled bool) {
+	cpu.isTraceEnabled = isTraceEnabled
+}
+
+func (cpu *CPU) ProgramCounter() uint16 {
+	return cpu.programCounter
+}
+
+func (cpu *CPU) Cycles() uint64 {
+	return cpu.cycles
+}
+
+// Whether KIL halted the CPU, only a reset or a power cycle resumes execution
+func (cpu *CPU) IsHalted() bool {
+	return cpu.isHalted
+}
+
+// The handler is called with the address of the KIL instruction when it halts the CPU
+func (cpu *CPU) SetHaltHandler(handler func(address uint16)) {
+	cpu.haltHandler = handler
+}
+
+// Prepares the CPU as if a JSR to address had been executed, with A and X set as parameters
+// The subroutine has returned once the program counter reaches returnAddress
+func (cpu *CPU) CallSubroutine(address uint16, returnAddress uint16, registerA uint8, registerX uint8) {
+	// RTS adds one to the address pulled from the stack
+	// The stack is written without spending cycles, the CPU did not execute anything
+	cpu.bus.MemoryWrite(STACK_BASE+uint16(cpu.stackPointer), uint8((returnAddress-1)>>8))
+	cpu.bus.MemoryWrite(STACK_BASE+uint16(cpu.stackPointer-1), uint8(returnAddress-1))
+	cpu.stackPointer -= 2
+	cpu.registerA = registerA
+	cpu.registerX = registerX
+	cpu.programCounter = address
+}
+
+// Instruction reported to the instruction handler once executed
+type ExecutedInstruction struct {
+	// Address of the opcode
+	Address uint16
+	OpCode  OpCode
+	// Address of the operand, target of jumps and branches, as computed by the addressing mode
+	OperandAddress uint16
+	// Cycles spent since the previous instruction : those of the instruction, and of the DMA stalls
+	// and the interrupt sequence before it
+	Cycles uint64
+	// The instruction is the first of an IRQ handler
+	IsInterrupted bool
+}
+
+// The handler is called after each instruction, nil removes it
+func (cpu *CPU) SetInstructionHandler(handler func(instruction ExecutedInstruction)) {
+	cpu.instructionHandler = handler
 }
 
 type StepInfos struct {
 	opHexCode      uint8
 	opCode         OpCode
 	operandAddress uint16
+	hasJumped      bool
 }
 
+// Executes instructions until the program stops, or KIL halts the CPU
 func (cpu *CPU) Run() {
-	for {
-		var opHexCode = cpu.memoryRead(cpu.programCounter)
-		var programCounterBeforeOperation = cpu.programCounter
-		var opCode = matchOpHexCodeWithOpCode(opHexCode)
-		var operandAddress = cpu.getOperandAddress(opCode.addressingMode, cpu.programCounter)
-		var stepInfos = &StepInfos{
-			opHexCode:      opHexCode,
-			opCode:         opCode,
-			operandAddress: operandAddress,
-		}
-		printCPUState(cpu, stepInfos)
-		switch opCode.operation {
-		case ADC:
-			cpu.adc(stepInfos)
-		case AND:
-			cpu.and(stepInfos)
-		case ASL:
-			cpu.asl(stepInfos)
-		case BCC:
-			cpu.bcc(stepInfos)
-		case BCS:
-			cpu.bcs(stepInfos)
-		case BEQ:
-			cpu.beq(stepInfos)
-		case BIT:
-			cpu.bit(stepInfos)
-		case BMI:
-			cpu.bmi(stepInfos)
-		case BNE:
-			cpu.bne(stepInfos)
-		case BPL:
-			cpu.bpl(stepInfos)
-		case BRK:
-			return
-		case BVS:
-			cpu.bvs(stepInfos)
-		case BVC:
-			cpu.bvc(stepInfos)
-		case CLC:
-			cpu.clc(stepInfos)
-		case CLD:
-			cpu.cld(stepInfos)
-		case CLI:
-			cpu.cli(stepInfos)
-		case CLV:
-			cpu.clv(stepInfos)
-		case CMP:
-			cpu.cmp(stepInfos)
-		case CPX:
-			cpu.cpx(stepInfos)
-		case CPY:
-			cpu.cpy(stepInfos)
-		case DEC:
-			cpu.dec(stepInfos)
-		case DEX:
-			cpu.dex(stepInfos)
-		case DEY:
-			cpu.dey(stepInfos)
-		case EOR:
-			cpu.eor(stepInfos)
-		case INC:
-			cpu.inc(stepInfos)
-		case INX:
-			cpu.inx(stepInfos)
-		case INY:
-			cpu.iny(stepInfos)
-		case JMP:
-			cpu.jmp(stepInfos)
-		case JSR:
-			cpu.jsr(stepInfos)
-		case LDA:
-			cpu.lda(stepInfos)
-		case LDX:
-			cpu.ldx(stepInfos)
-		case LDY:
-			cpu.ldy(stepInfos)
-		case LSR:
-			cpu.lsr(stepInfos)
-		case NOP:
-			cpu.nop(stepInfos)
-		case ORA:
-			cpu.ora(stepInfos)
-		case PHA:
-			cpu.pha(stepInfos)
-		case PHP:
-			cpu.php(stepInfos)
-		case PLA:
-			cpu.pla(stepInfos)
-		case PLP:
-			cpu.plp(stepInfos)
-		case ROL:
-			cpu.rol(stepInfos)
-		case ROR:
-			cpu.ror(stepInfos)
-		case RTI:
-			cpu.rti(stepInfos)
-		case RTS:
-			cpu.rts(stepInfos)
-		case SBC:
-			cpu.sbc(stepInfos)
-		case SEC:
-			cpu.sec(stepInfos)
-		case SED:
-			cpu.sed(stepInfos)
-		case SEI:
-			cpu.sei(stepInfos)
-		case STA:
-			cpu.sta(stepInfos)
-		case STX:
-			cpu.stx(stepInfos)
-		case STY:
-			cpu.sty(stepInfos)
-		case TAX:
-			cpu.tax(stepInfos)
-		case TAY:
-			cpu.tay(stepInfos)
-		case TSX:
-			cpu.tsx(stepInfos)
-		case TXA:
-			cpu.txa(stepInfos)
-		case TXS:
-			cpu.txs(stepInfos)
-		case TYA:
-			cpu.tya(stepInfos)
-		/***********************/
-		/* UNDOCUMENTED OPCODES
-		/***********************/
-		case _AAC:
-			cpu.aac(stepInfos)
-		case _AAX:
-			cpu.aax(stepInfos)
-		case _ARR:
-			cpu.arr(stepInfos)
-		case _ASR:
-			cpu.asr(stepInfos)
-		case _ATX:
-			cpu.atx(stepInfos)
-		case _AXA:
-			cpu.axa(stepInfos)
-		case _AXS:
-			cpu.axs(stepInfos)
-		case _DCP:
-			cpu.dcp(stepInfos)
-		case _DOP:
-			cpu.dop(stepInfos)
-		case _ISC:
-			cpu.isc(stepInfos)
-		case _KIL:
-			cpu.kil(stepInfos)
-		case _LAR:
-			cpu.lar(stepInfos)
-		case _LAX:
-			cpu.lax(stepInfos)
-		case _NOP:
-			cpu.nop(stepInfos)
-		case _RLA:
-			cpu.rla(stepInfos)
-		case _RRA:
-			cpu.rra(stepInfos)
-		case _SBC:
-			cpu.sbc(stepInfos)
-		case _SLO:
-			cpu.slo(stepInfos)
-		case _SRE:
-			cpu.sre(stepInfos)
-		case _SXA:
-			cpu.sxa(stepInfos)
-		case _SYA:
-			cpu.sya(stepInfos)
-		case _TOP:
-			cpu.top(stepInfos)
-		case _XAA:
-			cpu.xaa(stepInfos)
-		case _XAS:
-			cpu.xas(stepInfos)
-		default:
-			panic(fmt.Sprintf("operation %v is unsupported", opCode.operation))
+	for cpu.Step() && !cpu.isHalted {
+	}
+}
+
+// Executes a single instruction, returns false when the program stopped (BRK, see WithStopOnBrk)
+// A halted CPU only spends a cycle
+func (cpu *CPU) Step() bool {
+	if cpu.isHalted {
+		cpu.tick(1)
+		return true
+	}
+	var stepStartCycles = cpu.cycles
+	// Wait for DMA transfers which stole the bus during the last instruction
+	if stallCycles := cpu.bus.TakeDmaStallCycles(); stallCycles > 0 {
+		cpu.tick(stallCycles)
+	}
+	var isInterrupted = cpu.bus.IsIRQPending() && !cpu.isFlagSet(INTERRUPT_DISABLE_FLAG)
+	if isInterrupted {
+		cpu.interrupt(IRQ_VECTOR)
+	}
+	var startCycles = cpu.cycles
+	var address = cpu.programCounter
+	var opHexCode = cpu.fetchOpCode()
+	var opCode = matchOpHexCodeWithOpCode(cpu.variant, opHexCode)
+	var stepInfos = &StepInfos{
+		opHexCode: opHexCode,
+		opCode:    opCode,
+	}
+	if cpu.isTraceEnabled {
+		stepInfos.operandAddress = cpu.traceOperandAddress(opCode.addressingMode, cpu.programCounter)
+		fmt.Println(formatCPUState(cpu, stepInfos))
+	}
+	// JSR fetches its operand itself, in the middle of the stack accesses
+	if opCode.operation != JSR {
+		stepInfos.operandAddress = cpu.fetchOperandAddress(opCode)
+	}
+	switch opCode.operation {
+	case ADC:
+		cpu.adc(stepInfos)
+	case AND:
+		cpu.and(stepInfos)
+	case ASL:
+		cpu.asl(stepInfos)
+	case BCC:
+		cpu.bcc(stepInfos)
+	case BCS:
+		cpu.bcs(stepInfos)
+	case BEQ:
+		cpu.beq(stepInfos)
+	case BIT:
+		cpu.bit(stepInfos)
+	case BMI:
+		cpu.bmi(stepInfos)
+	case BNE:
+		cpu.bne(stepInfos)
+	case BPL:
+		cpu.bpl(stepInfos)
+	case BRK:
+		if cpu.isStoppedByBrk {
+			return false
 		}
-		// No jump or branch has occurred
-		if programCounterBeforeOperation == cpu.programCounter {
-			cpu.programCounter += getNumberOfBytesReadForOperation(opCode.addressingMode)
+		cpu.brk(stepInfos)
+	case BVS:
+		cpu.bvs(stepInfos)
+	case BVC:
+		cpu.bvc(stepInfos)
+	case CLC:
+		cpu.clc(stepInfos)
+	case CLD:
+		cpu.cld(stepInfos)
+	case CLI:
+		cpu.cli(stepInfos)
+	case CLV:
+		cpu.clv(stepInfos)
+	case CMP:
+		cpu.cmp(stepInfos)
+	case CPX:
+		cpu.cpx(stepInfos)
+	case CPY:
+		cpu.cpy(stepInfos)
+	case DEC:
+		cpu.dec(stepInfos)
+	case DEX:
+		cpu.dex(stepInfos)
+	case DEY:
+		cpu.dey(stepInfos)
+	case EOR:
+		cpu.eor(stepInfos)
+	case INC:
+		cpu.inc(stepInfos)
+	case INX:
+		cpu.inx(stepInfos)
+	case INY:
+		cpu.iny(stepInfos)
+	case JMP:
+		cpu.jmp(stepInfos)
+	case JSR:
+		cpu.jsr(stepInfos)
+	case LDA:
+		cpu.lda(stepInfos)
+	case LDX:
+		cpu.ldx(stepInfos)
+	case LDY:
+		cpu.ldy(stepInfos)
+	case LSR:
+		cpu.lsr(stepInfos)
+	case NOP:
+		cpu.nop(stepInfos)
+	case ORA:
+		cpu.ora(stepInfos)
+	case PHA:
+		cpu.pha(stepInfos)
+	case PHP:
+		cpu.php(stepInfos)
+	case PLA:
+		cpu.pla(stepInfos)
+	case PLP:
+		cpu.plp(stepInfos)
+	case ROL:
+		cpu.rol(stepInfos)
+	case ROR:
+		cpu.ror(stepInfos)
+	case RTI:
+		cpu.rti(stepInfos)
+	case RTS:
+		cpu.rts(stepInfos)
+	case SBC:
+		cpu.sbc(stepInfos)
+	case SEC:
+		cpu.sec(stepInfos)
+	case SED:
+		cpu.sed(stepInfos)
+	case SEI:
+		cpu.sei(stepInfos)
+	case STA:
+		cpu.sta(stepInfos)
+	case STX:
+		cpu.stx(stepInfos)
+	case STY:
+		cpu.sty(stepInfos)
+	case TAX:
+		cpu.tax(stepInfos)
+	case TAY:
+		cpu.tay(stepInfos)
+	case TSX:
+		cpu.tsx(stepInfos)
+	case TXA:
+		cpu.txa(stepInfos)
+	case TXS:
+		cpu.txs(stepInfos)
+	case TYA:
+		cpu.tya(stepInfos)
+	/***********************/
+	/* 65C02 OPCODES
+	/***********************/
+	case BRA:
+		cpu.bra(stepInfos)
+	case PHX:
+		cpu.phx(stepInfos)
+	case PHY:
+		cpu.phy(stepInfos)
+	case PLX:
+		cpu.plx(stepInfos)
+	case PLY:
+		cpu.ply(stepInfos)
+	case STZ:
+		cpu.stz(stepInfos)
+	case TRB:
+		cpu.trb(stepInfos)
+	case TSB:
+		cpu.tsb(stepInfos)
+	/***********************/
+	/* UNDOCUMENTED OPCODES
+	/***********************/
+	case _AAC:
+		cpu.aac(stepInfos)
+	case _AAX:
+		cpu.aax(stepInfos)
+	case _ARR:
+		cpu.arr(stepInfos)
+	case _ASR:
+		cpu.asr(stepInfos)
+	case _ATX:
+		cpu.atx(stepInfos)
+	case _AXA:
+		cpu.axa(stepInfos)
+	case _AXS:
+		cpu.axs(stepInfos)
+	case _DCP:
+		cpu.dcp(stepInfos)
+	case _DOP:
+		cpu.dop(stepInfos)
+	case _ISC:
+		cpu.isc(stepInfos)
+	case _KIL:
+		cpu.kil(stepInfos)
+	case _LAR:
+		cpu.lar(stepInfos)
+	case _LAX:
+		cpu.lax(stepInfos)
+	case _NOP:
+		cpu.nop(stepInfos)
+	case _RLA:
+		cpu.rla(stepInfos)
+	case _RRA:
+		cpu.rra(stepInfos)
+	case _SBC:
+		cpu.sbc(stepInfos)
+	case _SLO:
+		cpu.slo(stepInfos)
+	case _SRE:
+		cpu.sre(stepInfos)
+	case _SXA:
+		cpu.sxa(stepInfos)
+	case _SYA:
+		cpu.sya(stepInfos)
+	case _TOP:
+		cpu.top(stepInfos)
+	case _XAA:
+		cpu.xaa(stepInfos)
+	case _XAS:
+		cpu.xas(stepInfos)
+	default:
+		panic(fmt.Sprintf("operation %v is unsupported", opCode.operation))
+	}
+	// The reserved NOPs of the 65C02 take the cycles of the instruction they stand for, like $5C which takes 8
+	if opCode.operation == NOP {
+		for cpu.cycles-startCycles < uint64(opCode.cycles) {
+			cpu.memoryRead(cpu.programCounter + opCode.Size() - 1)
 		}
 	}
+	// No jump or branch has occurred
+	if !stepInfos.hasJumped {
+		cpu.programCounter += getNumberOfBytesReadForOperation(opCode.addressingMode)
+	}
+	if cpu.instructionHandler != nil {
+		cpu.instructionHandler(ExecutedInstruction{
+			Address:        address,
+			OpCode:         opCode,
+			OperandAddress: stepInfos.operandAddress,
+			Cycles:         cpu.cycles - stepStartCycles,
+			IsInterrupted:  isInterrupted,
+		})
+	}
+	return true
 }
 
 // TODO : change illegal opcode to match those
@@ -927,10 +1399,10 @@ func convertOperationForPrinting(operation Operation) string {
 }
 
 // Must be run at the beginning of the loop
-func printCPUState(cpu *CPU, cpuStepInfos *StepInfos) {
+func formatCPUState(cpu *CPU, cpuStepInfos *StepInfos) string {
 	var builder = strings.Builder{}
-	var param1 = cpu.memoryRead(cpu.programCounter + 1)
-	var param2 = cpu.memoryRead(cpu.programCounter + 2)
+	var param1 = cpu.peekMemory(cpu.programCounter + 1)
+	var param2 = cpu.peekMemory(cpu.programCounter + 2)
 	var bytesReadForAddressing = getNumberOfBytesReadForOperation(cpuStepInfos.opCode.addressingMode)
 
 	// Program Counter
@@ -940,9 +1412,9 @@ func printCPUState(cpu *CPU, cpuStepInfos *StepInfos) {
 	var hexOpCodeTrace string
 	switch bytesReadForAddressing {
 	case 3:
-		hexOpCodeTrace = fmt.Sprintf("%02X %02X %02X", cpuStepInfos.opHexCode, cpu.memoryRead(cpu.programCounter+1), cpu.memoryRead(cpu.programCounter+2))
+		hexOpCodeTrace = fmt.Sprintf("%02X %02X %02X", cpuStepInfos.opHexCode, cpu.peekMemory(cpu.programCounter+1), cpu.peekMemory(cpu.programCounter+2))
 	case 2:
-		hexOpCodeTrace = fmt.Sprintf("%02X %02X", cpuStepInfos.opHexCode, cpu.memoryRead(cpu.programCounter+1))
+		hexOpCodeTrace = fmt.Sprintf("%02X %02X", cpuStepInfos.opHexCode, cpu.peekMemory(cpu.programCounter+1))
 	case 1:
 		hexOpCodeTrace = fmt.Sprintf("%02X", cpuStepInfos.opHexCode)
 	}
@@ -969,28 +1441,33 @@ func printCPUState(cpu *CPU, cpuStepInfos *StepInfos) {
 		// Branching instruction
 		addressingTrace = fmt.Sprintf("$%04X", cpuStepInfos.operandAddress)
 	case ZeroPage:
-		addressingTrace = fmt.Sprintf("$%02X = %02X", param1, cpu.memoryRead(cpuStepInfos.operandAddress))
+		addressingTrace = fmt.Sprintf("$%02X = %02X", param1, cpu.peekMemory(cpuStepInfos.operandAddress))
 	case ZeroPageX:
-		addressingTrace = fmt.Sprintf("$%02X,X @ %02X = %02X", param1, cpuStepInfos.operandAddress, cpu.memoryRead(cpuStepInfos.operandAddress))
+		addressingTrace = fmt.Sprintf("$%02X,X @ %02X = %02X", param1, cpuStepInfos.operandAddress, cpu.peekMemory(cpuStepInfos.operandAddress))
 	case ZeroPageY:
-		addressingTrace = fmt.Sprintf("$%02X,Y @ %02X = %02X", param1, cpuStepInfos.operandAddress, cpu.memoryRead(cpuStepInfos.operandAddress))
+		addressingTrace = fmt.Sprintf("$%02X,Y @ %02X = %02X", param1, cpuStepInfos.operandAddress, cpu.peekMemory(cpuStepInfos.operandAddress))
 	case Absolute:
 		if cpuStepInfos.opCode.operation == JMP || cpuStepInfos.opCode.operation == JSR {
 			addressingTrace = fmt.Sprintf("$%02X%02X", param2, param1)
 		} else {
-			addressingTrace = fmt.Sprintf("$%02X%02X = %02X", param2, param1, cpu.memoryRead(cpuStepInfos.operandAddress))
+			addressingTrace = fmt.Sprintf("$%02X%02X = %02X", param2, param1, cpu.peekMemory(cpuStepInfos.operandAddress))
 		}
 	case AbsoluteX:
-		addressingTrace = fmt.Sprintf("$%02X%02X,X @ %04X = %02X", param2, param1, cpuStepInfos.operandAddress, cpu.memoryRead(cpuStepInfos.operandAddress))
+		addressingTrace = fmt.Sprintf("$%02X%02X,X @ %04X = %02X", param2, param1, cpuStepInfos.operandAddress, cpu.peekMemory(cpuStepInfos.operandAddress))
 	case AbsoluteY:
-		addressingTrace = fmt.Sprintf("$%02X%02X,Y @ %04X = %02X", param2, param1, cpuStepInfos.operandAddress, cpu.memoryRead(cpuStepInfos.operandAddress))
+		addressingTrace = fmt.Sprintf("$%02X%02X,Y @ %04X = %02X", param2, param1, cpuStepInfos.operandAddress, cpu.peekMemory(cpuStepInfos.operandAddress))
 	case Indirect:
 		// JMP
 		addressingTrace = fmt.Sprintf("($%02X%02X) = %04X", param2, param1, cpuStepInfos.operandAddress)
 	case IndirectX:
-		addressingTrace = fmt.Sprintf("($%02X,X) @ %02X = %04X = %02X", param1, param1+cpu.registerX, cpuStepInfos.operandAddress, cpu.memoryRead(cpuStepInfos.operandAddress))
+		addressingTrace = fmt.Sprintf("($%02X,X) @ %02X = %04X = %02X", param1, param1+cpu.registerX, cpuStepInfos.operandAddress, cpu.peekMemory(cpuStepInfos.operandAddress))
 	case IndirectY:
-		addressingTrace = fmt.Sprintf("($%02X),Y = %04X @ %04X = %02X", param1, cpuStepInfos.operandAddress-uint16(cpu.registerY), cpuStepInfos.operandAddress, cpu.memoryRead(cpuStepInfos.operandAddress))
+		addressingTrace = fmt.Sprintf("($%02X),Y = %04X @ %04X = %02X", param1, cpuStepInfos.operandAddress-uint16(cpu.registerY), cpuStepInfos.operandAddress, cpu.peekMemory(cpuStepInfos.operandAddress))
+	case ZeroPageIndirect:
+		addressingTrace = fmt.Sprintf("($%02X) = %04X = %02X", param1, cpuStepInfos.operandAddress, cpu.peekMemory(cpuStepInfos.operandAddress))
+	case AbsoluteIndexedIndirect:
+		// JMP
+		addressingTrace = fmt.Sprintf("($%02X%02X,X) = %04X", param2, param1, cpuStepInfos.operandAddress)
 	default:
 		panic(fmt.Sprintf("addressing mode %v is not supported for tracing", cpuStepInfos.opCode.addressingMode))
 	}
@@ -1000,5 +1477,5 @@ func printCPUState(cpu *CPU, cpuStepInfos *StepInfos) {
 	builder.WriteString(fmt.Sprintf("A:%02X X:%02X Y:%02X P:%02X SP:%02X", cpu.registerA, cpu.registerX, cpu.registerY, cpu.statusFlags, cpu.stackPointer))
 	// TODO : CPU and PPU cycles
 
-	fmt.Println(builder.String())
+	return builder.String()
 }
@@ -0,0 +1,96 @@
+package cpu
+
+// ADC and SBC with the decimal flag set, on the variants which have a decimal mode
+// http://www.6502.org/tutorials/decimal_mode.html#A
+
+func (cpu *CPU) isDecimalMode() bool {
+	return cpu.variant != RICOH_2A03 && cpu.isFlagSet(DECIMAL_FLAG)
+}
+
+// A = A + M + C, used by ADC and RRA
+func (cpu *CPU) addToAccumulator(cpuStepInfos *StepInfos, operand uint8) {
+	var carry = cpu.isFlagSet(CARRY_FLAG)
+	var result, hasCarry, hasOverflow = cpu.addWithCarry(cpu.registerA, operand, carry)
+	if !cpu.isDecimalMode() {
+		cpu.registerA = result
+		cpu.setFlagToValue(CARRY_FLAG, hasCarry)
+		cpu.setFlagToValue(OVERFLOW_FLAG, hasOverflow)
+		cpu.setZeroFlagAndNegativeFlagForResult(cpu.registerA)
+		return
+	}
+
+	var low = int(cpu.registerA&0x0F) + int(operand&0x0F) + carryValue(carry)
+	if low >= 0x0A {
+		low = ((low + 0x06) & 0x0F) + 0x10
+	}
+	var sum = int(cpu.registerA&0xF0) + int(operand&0xF0) + low
+	// N and V are computed before the high digit is adjusted
+	var signedSum = int(int8(cpu.registerA&0xF0)) + int(int8(operand&0xF0)) + low
+	var isSumNegative = sum&0x80 != 0
+	if sum >= 0xA0 {
+		sum += 0x60
+	}
+	cpu.registerA = uint8(sum)
+	cpu.setFlagToValue(CARRY_FLAG, sum >= 0x100)
+	cpu.setFlagToValue(OVERFLOW_FLAG, signedSum < -128 || signedSum > 127)
+	if cpu.variant == CMOS_65C02 {
+		cpu.setZeroFlagAndNegativeFlagForResult(cpu.registerA)
+		cpu.spendDecimalCycle(cpuStepInfos)
+	} else {
+		// Z is the one of the binary addition
+		cpu.setFlagToValue(ZERO_FLAG, result == 0)
+		cpu.setFlagToValue(NEGATIVE_FLAG, isSumNegative)
+	}
+}
+
+// A = A - M - (1 - C), used by SBC and ISC
+func (cpu *CPU) subtractFromAccumulator(cpuStepInfos *StepInfos, operand uint8) {
+	var carry = cpu.isFlagSet(CARRY_FLAG)
+	// Result calculated is A-M-(1-C) = A + (256 - M) - 1 + C = A + (255 - M) + C
+	var result, hasCarry, hasOverflow = cpu.addWithCarry(cpu.registerA, 255-operand, carry)
+	// C and V are always those of the binary subtraction, N and Z too on the NMOS 6502
+	cpu.setFlagToValue(CARRY_FLAG, hasCarry)
+	cpu.setFlagToValue(OVERFLOW_FLAG, hasOverflow)
+	cpu.setZeroFlagAndNegativeFlagForResult(result)
+	if !cpu.isDecimalMode() {
+		cpu.registerA = result
+		return
+	}
+
+	var low = int(cpu.registerA&0x0F) - int(operand&0x0F) + carryValue(carry) - 1
+	var difference int
+	if cpu.variant == CMOS_65C02 {
+		difference = int(cpu.registerA) - int(operand) + carryValue(carry) - 1
+		if difference < 0 {
+			difference -= 0x60
+		}
+		if low < 0 {
+			difference -= 0x06
+		}
+	} else {
+		if low < 0 {
+			low = ((low - 0x06) & 0x0F) - 0x10
+		}
+		difference = int(cpu.registerA&0xF0) - int(operand&0xF0) + low
+		if difference < 0 {
+			difference -= 0x60
+		}
+	}
+	cpu.registerA = uint8(difference)
+	if cpu.variant == CMOS_65C02 {
+		cpu.setZeroFlagAndNegativeFlagForResult(cpu.registerA)
+		cpu.spendDecimalCycle(cpuStepInfos)
+	}
+}
+
+func carryValue(carry bool) int {
+	if carry {
+		return 1
+	}
+	return 0
+}
+
+// The 65C02 spends an extra cycle fixing the flags, reading the last byte of the instruction again
+func (cpu *CPU) spendDecimalCycle(cpuStepInfos *StepInfos) {
+	cpu.memoryRead(cpu.programCounter + cpuStepInfos.opCode.Size() - 1)
+}
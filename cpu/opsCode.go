@@ -1,6 +1,9 @@
 package cpu
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // Addressing modes
 
@@ -20,18 +23,21 @@ const (
 	Indirect
 	IndirectX
 	IndirectY
+	// 65C02 only : (zp) and JMP (abs,X)
+	ZeroPageIndirect
+	AbsoluteIndexedIndirect
 )
 
 func getNumberOfBytesReadForOperation(addressingMode AddressingMode) uint16 {
 	switch addressingMode {
 	case Implied, Accumulator:
 		return 1
-	case Relative, Immediate, ZeroPage, ZeroPageX, ZeroPageY, IndirectX, IndirectY:
+	case Relative, Immediate, ZeroPage, ZeroPageX, ZeroPageY, IndirectX, IndirectY, ZeroPageIndirect:
 		return 2
-	case Indirect, Absolute, AbsoluteX, AbsoluteY:
+	case Indirect, Absolute, AbsoluteX, AbsoluteY, AbsoluteIndexedIndirect:
 		return 3
 	default:
-		panic(fmt.Sprintf("addressing mode %v is unsupported for get number of bytes read"))
+		panic(fmt.Sprintf("addressing mode %v is unsupported for get number of bytes read", addressingMode))
 	}
 }
 
@@ -95,6 +101,19 @@ const (
 	TXS           = "TXS"
 	TYA           = "TYA"
 	/***********************/
+	/* 65C02 OPCODES
+	/* http://www.6502.org/tutorials/65c02opcodes.html
+	*/
+	/***********************/
+	BRA = "BRA"
+	PHX = "PHX"
+	PHY = "PHY"
+	PLX = "PLX"
+	PLY = "PLY"
+	STZ = "STZ"
+	TRB = "TRB"
+	TSB = "TSB"
+	/***********************/
 	/* UNDOCUMENTED OPCODES
 	/* https://www.nesdev.org/undocumented_opcodes.txt
 	/* https://www.nesdev.org/wiki/Programming_with_unofficial_opcodes
@@ -126,6 +145,27 @@ const (
 	_XAS = "*XAS"
 )
 
+// Operations only reading their operand : indexing across a page boundary costs them an extra cycle
+// Stores and read-modify-write operations always spend that cycle, it is included in their base cycles
+func isReadOperation(operation Operation) bool {
+	switch operation {
+	case ADC, AND, BIT, CMP, EOR, LDA, LDX, LDY, ORA, SBC, _LAR, _LAX, _TOP:
+		return true
+	default:
+		return false
+	}
+}
+
+// Indexed shifts and rotations of the 65C02 only spend the indexing cycle when a page is crossed
+func isShiftOperation(operation Operation) bool {
+	switch operation {
+	case ASL, LSR, ROL, ROR:
+		return true
+	default:
+		return false
+	}
+}
+
 type OpCode struct {
 	operation      Operation
 	addressingMode AddressingMode
@@ -133,7 +173,7 @@ type OpCode struct {
 }
 
 // https://www.nesdev.org/obelisk-6502-guide/reference.html
-// TODO : cycles miss pages crossed / branching taken considerations
+// Cycles are base cycles, the number of bus accesses the CPU makes when no page is crossed and no branch is taken
 var hexToOpsCode = map[uint8]OpCode{
 	// ADC
 	0x69: {operation: ADC, addressingMode: Immediate, cycles: 2},
@@ -476,8 +516,51 @@ var hexToOpsCode = map[uint8]OpCode{
 	0x9B: {operation: _XAS, addressingMode: AbsoluteY, cycles: 5},
 }
 
-func matchOpHexCodeWithOpCode(hexCode uint8) OpCode {
+func DecodeOpCode(hexCode uint8) (OpCode, bool) {
 	var opsCode, ok = hexToOpsCode[hexCode]
+	return opsCode, ok
+}
+
+// Unofficial operations are prefixed with "*"
+func (opCode OpCode) Operation() Operation {
+	return opCode.operation
+}
+
+func (opCode OpCode) AddressingMode() AddressingMode {
+	return opCode.addressingMode
+}
+
+// Size of the instruction in bytes, opcode included
+func (opCode OpCode) Size() uint16 {
+	return getNumberOfBytesReadForOperation(opCode.addressingMode)
+}
+
+func (opCode OpCode) IsUnofficial() bool {
+	return strings.HasPrefix(string(opCode.operation), "*")
+}
+
+// Whether the instruction reads its operand from memory : loads, arithmetic, comparisons, read-modify-write
+// Immediate operands are part of the instruction, jumps and stores do not read their operand
+func (opCode OpCode) ReadsOperand() bool {
+	switch opCode.addressingMode {
+	case Implied, Accumulator, Immediate, Relative:
+		return false
+	}
+	switch opCode.operation {
+	case JMP, JSR, STA, STX, STY, STZ, _AAX, _AXA, _SXA, _SYA, _XAS:
+		return false
+	default:
+		return true
+	}
+}
+
+// Name of the operation as written in traces, without the "*" of unofficial ones
+func (opCode OpCode) Mnemonic() string {
+	return strings.TrimPrefix(convertOperationForPrinting(opCode.operation), "*")
+}
+
+func matchOpHexCodeWithOpCode(variant Variant, hexCode uint8) OpCode {
+	var opsCode, ok = DecodeVariantOpCode(variant, hexCode)
 	if !ok {
 		panic(fmt.Sprintf("hex code %v is unsupported", hexCode))
 	}
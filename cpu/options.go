@@ -0,0 +1,41 @@
+package cpu
+
+// Behaviour of the unstable unofficial opcodes, which differs between chips and even between runs on the same chip
+// https://www.nesdev.org/wiki/CPU_unofficial_opcodes
+// https://www.nesdev.org/wiki/Visual6502wiki/6502_Opcode_8B_(XAA,_ANE)
+
+// Value ORed with A by XAA and ATX (LXA), $EE on most chips analysed with visual6502
+const DEFAULT_UNSTABLE_MAGIC uint8 = 0xEE
+
+type Option func(*CPU)
+
+// Sets the "magic" constant of XAA (A = (A | magic) & X & immediate) and ATX (A = X = (A | magic) & immediate)
+func WithUnstableMagic(magic uint8) Option {
+	return func(cpu *CPU) {
+		cpu.unstableMagic = magic
+	}
+}
+
+// AXA (SHA), SXA (SHX), SYA (SHY) and XAS (TAS) store a value ANDed with the high byte of the address plus one
+// When indexing crosses a page, the real chip also uses that value as the high byte of the address written to
+// Disabling the corruption writes to the indexed address, as some emulators do
+func WithHighByteCorruption(isEnabled bool) Option {
+	return func(cpu *CPU) {
+		cpu.isHighByteCorrupted = isEnabled
+	}
+}
+
+// Selects the chip emulated, RICOH_2A03 by default
+func WithVariant(variant Variant) Option {
+	return func(cpu *CPU) {
+		cpu.variant = variant
+	}
+}
+
+// BRK stops the CPU : Step returns false without pushing anything, as test ROMs such as nestest expect
+// when run without a PPU, disabled by default
+func WithStopOnBrk(isEnabled bool) Option {
+	return func(cpu *CPU) {
+		cpu.isStoppedByBrk = isEnabled
+	}
+}
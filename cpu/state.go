@@ -0,0 +1,122 @@
+package cpu
+
+// Programmer-visible registers
+type Registers struct {
+	A              uint8
+	X              uint8
+	Y              uint8
+	StackPointer   uint8
+	Status         uint8
+	ProgramCounter uint16
+}
+
+// Everything a test suite or a debugger needs to check or restore the CPU, without parsing traces
+type State struct {
+	Registers
+	// Cycles elapsed since power on, the reset sequence included
+	Cycles   uint64
+	IsHalted bool
+}
+
+func (cpu *CPU) Registers() Registers {
+	return Registers{
+		A:              cpu.registerA,
+		X:              cpu.registerX,
+		Y:              cpu.registerY,
+		StackPointer:   cpu.stackPointer,
+		Status:         cpu.statusFlags,
+		ProgramCounter: cpu.programCounter,
+	}
+}
+
+func (cpu *CPU) SetRegisters(registers Registers) {
+	cpu.registerA = registers.A
+	cpu.registerX = registers.X
+	cpu.registerY = registers.Y
+	cpu.stackPointer = registers.StackPointer
+	cpu.statusFlags = registers.Status
+	cpu.programCounter = registers.ProgramCounter
+}
+
+func (cpu *CPU) State() State {
+	return State{
+		Registers: cpu.Registers(),
+		Cycles:    cpu.cycles,
+		IsHalted:  cpu.isHalted,
+	}
+}
+
+// The devices of the bus are not clocked to catch up with the new cycle count
+func (cpu *CPU) SetState(state State) {
+	cpu.SetRegisters(state.Registers)
+	cpu.cycles = state.Cycles
+	cpu.isHalted = state.IsHalted
+}
+
+// Flags
+
+func (cpu *CPU) Flag(statusFlag StatusFlag) bool {
+	return cpu.isFlagSet(statusFlag)
+}
+
+func (cpu *CPU) SetFlag(statusFlag StatusFlag, value bool) {
+	cpu.setFlagToValue(statusFlag, value)
+}
+
+func (registers Registers) Flag(statusFlag StatusFlag) bool {
+	return registers.Status&uint8(statusFlag) != 0
+}
+
+func (registers *Registers) SetFlag(statusFlag StatusFlag, value bool) {
+	if value {
+		registers.Status |= uint8(statusFlag)
+	} else {
+		registers.Status &^= uint8(statusFlag)
+	}
+}
+
+// Status flags from bit 7 to bit 0
+var statusFlagsOrder = []StatusFlag{
+	NEGATIVE_FLAG, OVERFLOW_FLAG, BREAK_2_FLAG, BREAK_FLAG, DECIMAL_FLAG, INTERRUPT_DISABLE_FLAG, ZERO_FLAG, CARRY_FLAG,
+}
+
+// Letter of the flag as in NV-BDIZC, "-" for the unused bit 5
+func (statusFlag StatusFlag) String() string {
+	switch statusFlag {
+	case CARRY_FLAG:
+		return "C"
+	case ZERO_FLAG:
+		return "Z"
+	case INTERRUPT_DISABLE_FLAG:
+		return "I"
+	case DECIMAL_FLAG:
+		return "D"
+	case BREAK_FLAG:
+		return "B"
+	case BREAK_2_FLAG:
+		return "-"
+	case OVERFLOW_FLAG:
+		return "V"
+	case NEGATIVE_FLAG:
+		return "N"
+	default:
+		return "?"
+	}
+}
+
+// Status as NV--DIZC, set flags in upper case and cleared ones in lower case
+// The B flags only exist on the stack, they are shown as "-"
+func (registers Registers) FormatStatus() string {
+	var builder = make([]byte, 0, len(statusFlagsOrder))
+	for _, statusFlag := range statusFlagsOrder {
+		var letter = statusFlag.String()[0]
+		switch {
+		case statusFlag == BREAK_FLAG || statusFlag == BREAK_2_FLAG:
+			letter = '-'
+		case !registers.Flag(statusFlag):
+			letter += 'a' - 'A'
+		}
+		builder = append(builder, letter)
+	}
+	return string(builder)
+}
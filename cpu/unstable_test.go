@@ -0,0 +1,119 @@
+package cpu_test
+
+import (
+	"nes-emulator/cpu"
+	"nes-emulator/singlestep"
+	"testing"
+)
+
+const PROGRAM_START uint16 = 0x0200
+
+// Runs the instruction at PROGRAM_START with the given registers
+func runInstruction(t *testing.T, instruction []uint8, registers cpu.Registers, options ...cpu.Option) (*cpu.CPU, *singlestep.FlatBus) {
+	var flatBus = &singlestep.FlatBus{}
+	var testCPU, _ = runInstructionOn(t, flatBus, instruction, registers, options...)
+	return testCPU, flatBus
+}
+
+// Same as runInstruction on a bus already holding data, also returns the cycles spent
+func runInstructionOn(t *testing.T, flatBus *singlestep.FlatBus, instruction []uint8, registers cpu.Registers, options ...cpu.Option) (*cpu.CPU, uint64) {
+	for i, data := range instruction {
+		flatBus.Poke(PROGRAM_START+uint16(i), data)
+	}
+	// (zp),Y instructions point to $12F0
+	flatBus.Poke(0x0080, 0xF0)
+	flatBus.Poke(0x0081, 0x12)
+	var testCPU = cpu.NewCPU(flatBus, options...)
+	testCPU.SetTraceEnabled(false)
+	registers.ProgramCounter = PROGRAM_START
+	registers.StackPointer = 0xFD
+	testCPU.SetRegisters(registers)
+	if !testCPU.Step() {
+		t.Fatalf("instruction % X stopped the CPU", instruction)
+	}
+	return &testCPU, testCPU.Cycles()
+}
+
+// Results from https://www.nesdev.org/wiki/Visual6502wiki/6502_Opcode_8B_(XAA,_ANE)
+func TestUnstableMagic(t *testing.T) {
+	var tests = []struct {
+		name        string
+		instruction []uint8
+		registers   cpu.Registers
+		options     []cpu.Option
+		a           uint8
+		x           uint8
+		isZero      bool
+	}{
+		{"XAA default magic", []uint8{0x8B, 0xFF}, cpu.Registers{A: 0x00, X: 0xFF}, nil, 0xEE, 0xFF, false},
+		{"XAA masked by X and the operand", []uint8{0x8B, 0x5A}, cpu.Registers{A: 0xF0, X: 0x3C}, nil, 0x18, 0x3C, false},
+		{"XAA magic $FF", []uint8{0x8B, 0xFF}, cpu.Registers{A: 0x00, X: 0xFF}, []cpu.Option{cpu.WithUnstableMagic(0xFF)}, 0xFF, 0xFF, false},
+		{"XAA magic $00", []uint8{0x8B, 0xFF}, cpu.Registers{A: 0x00, X: 0xFF}, []cpu.Option{cpu.WithUnstableMagic(0x00)}, 0x00, 0xFF, true},
+		{"LXA default magic", []uint8{0xAB, 0xFF}, cpu.Registers{A: 0x00, X: 0x12}, nil, 0xEE, 0xEE, false},
+		{"LXA masked by the operand", []uint8{0xAB, 0x0F}, cpu.Registers{A: 0x01, X: 0x12}, nil, 0x0F, 0x0F, false},
+		{"LXA magic $FF", []uint8{0xAB, 0x3C}, cpu.Registers{A: 0x00}, []cpu.Option{cpu.WithUnstableMagic(0xFF)}, 0x3C, 0x3C, false},
+		{"LXA magic $00", []uint8{0xAB, 0xFF}, cpu.Registers{A: 0x00}, []cpu.Option{cpu.WithUnstableMagic(0x00)}, 0x00, 0x00, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var testCPU, _ = runInstruction(t, test.instruction, test.registers, test.options...)
+			var registers = testCPU.Registers()
+			if registers.A != test.a || registers.X != test.x {
+				t.Errorf("A=$%02X X=$%02X, want A=$%02X X=$%02X", registers.A, registers.X, test.a, test.x)
+			}
+			if testCPU.Flag(cpu.ZERO_FLAG) != test.isZero {
+				t.Errorf("zero flag %v, want %v", testCPU.Flag(cpu.ZERO_FLAG), test.isZero)
+			}
+		})
+	}
+}
+
+func TestHighByteCorruption(t *testing.T) {
+	var tests = []struct {
+		name        string
+		instruction []uint8
+		registers   cpu.Registers
+		isCorrupted bool
+		address     uint16
+		value       uint8
+	}{
+		// Same page : value & ($12 + 1) at the indexed address, with or without corruption
+		{"SHA abs,Y", []uint8{0x9F, 0x00, 0x12}, cpu.Registers{A: 0xFF, X: 0x3F, Y: 0x05}, true, 0x1205, 0x13},
+		{"SHA (zp),Y", []uint8{0x93, 0x80}, cpu.Registers{A: 0xFF, X: 0xFF, Y: 0x05}, true, 0x12F5, 0x13},
+		{"SHX abs,Y", []uint8{0x9E, 0x00, 0x12}, cpu.Registers{X: 0xF2, Y: 0x05}, true, 0x1205, 0x12},
+		{"SHY abs,X", []uint8{0x9C, 0x00, 0x12}, cpu.Registers{X: 0x05, Y: 0x0F}, true, 0x1205, 0x03},
+		{"TAS abs,Y", []uint8{0x9B, 0x00, 0x12}, cpu.Registers{A: 0xF3, X: 0x1F, Y: 0x05}, false, 0x1205, 0x13},
+		// Page crossed : the value stored also replaces the high byte of the address when corrupted
+		{"SHA abs,Y page crossed", []uint8{0x9F, 0xF0, 0x12}, cpu.Registers{A: 0x0F, X: 0xFF, Y: 0x20}, true, 0x0310, 0x03},
+		{"SHA abs,Y page crossed without corruption", []uint8{0x9F, 0xF0, 0x12}, cpu.Registers{A: 0x0F, X: 0xFF, Y: 0x20}, false, 0x1310, 0x03},
+		{"SHA (zp),Y page crossed", []uint8{0x93, 0x80}, cpu.Registers{A: 0xFF, X: 0x07, Y: 0x20}, true, 0x0310, 0x03},
+		{"SHA (zp),Y page crossed without corruption", []uint8{0x93, 0x80}, cpu.Registers{A: 0xFF, X: 0x07, Y: 0x20}, false, 0x1310, 0x03},
+		{"SHX abs,Y page crossed", []uint8{0x9E, 0xF0, 0x12}, cpu.Registers{X: 0x0B, Y: 0x20}, true, 0x0310, 0x03},
+		{"SHX abs,Y page crossed without corruption", []uint8{0x9E, 0xF0, 0x12}, cpu.Registers{X: 0x0B, Y: 0x20}, false, 0x1310, 0x03},
+		{"SHY abs,X page crossed", []uint8{0x9C, 0xF0, 0x12}, cpu.Registers{X: 0x20, Y: 0x11}, true, 0x1110, 0x11},
+		{"SHY abs,X page crossed without corruption", []uint8{0x9C, 0xF0, 0x12}, cpu.Registers{X: 0x20, Y: 0x11}, false, 0x1310, 0x11},
+		{"TAS abs,Y page crossed", []uint8{0x9B, 0xF0, 0x12}, cpu.Registers{A: 0x0F, X: 0xF7, Y: 0x20}, true, 0x0310, 0x03},
+		{"TAS abs,Y page crossed without corruption", []uint8{0x9B, 0xF0, 0x12}, cpu.Registers{A: 0x0F, X: 0xF7, Y: 0x20}, false, 0x1310, 0x03},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var testCPU, flatBus = runInstruction(t, test.instruction, test.registers, cpu.WithHighByteCorruption(test.isCorrupted))
+			var writes []singlestep.Access
+			for _, access := range flatBus.TakeAccesses() {
+				if access.IsWrite {
+					writes = append(writes, access)
+				}
+			}
+			if len(writes) != 1 || writes[0].Address != test.address || writes[0].Data != test.value {
+				t.Fatalf("writes %+v, want $%02X at $%04X", writes, test.value, test.address)
+			}
+			// TAS also sets the stack pointer to A & X
+			if test.instruction[0] == 0x9B {
+				var stackPointer = test.registers.A & test.registers.X
+				if registers := testCPU.Registers(); registers.StackPointer != stackPointer {
+					t.Errorf("SP=$%02X, want $%02X", registers.StackPointer, stackPointer)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package cpu
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Chips of the 6502 family the CPU can behave as
+// http://www.6502.org/tutorials/65c02opcodes.html
+type Variant int
+
+const (
+	// NES CPU : NMOS 6502 whose decimal mode is disconnected, the decimal flag has no effect
+	RICOH_2A03 Variant = iota
+	// Original NMOS 6502 : ADC and SBC use BCD arithmetic when the decimal flag is set
+	// N, V and Z are then computed from intermediate results : http://www.6502.org/tutorials/decimal_mode.html
+	NMOS_6502
+	// CMOS 65C02, without the bit instructions of the Rockwell and WDC versions
+	// Unofficial opcodes are NOPs, JMP ($xxFF) reads its target across the page and decimal mode sets N and Z
+	// from the BCD result, at the cost of an extra cycle
+	CMOS_65C02
+)
+
+func (variant Variant) String() string {
+	switch variant {
+	case RICOH_2A03:
+		return "2A03"
+	case NMOS_6502:
+		return "6502"
+	case CMOS_65C02:
+		return "65C02"
+	default:
+		return fmt.Sprintf("Variant(%d)", int(variant))
+	}
+}
+
+// Variant named as returned by String, case insensitive
+func ParseVariant(name string) (Variant, error) {
+	for _, variant := range []Variant{RICOH_2A03, NMOS_6502, CMOS_65C02} {
+		if strings.EqualFold(name, variant.String()) {
+			return variant, nil
+		}
+	}
+	return RICOH_2A03, fmt.Errorf("unknown CPU variant %q, expected 2A03, 6502 or 65C02", name)
+}
+
+// Chip emulated, set with WithVariant
+func (cpu *CPU) Variant() Variant {
+	return cpu.variant
+}
+
+// Opcode of hexCode on the given variant, the NMOS chips share the table of DecodeOpCode
+func DecodeVariantOpCode(variant Variant, hexCode uint8) (OpCode, bool) {
+	if variant == CMOS_65C02 {
+		var opCode, ok = cmosHexToOpsCode[hexCode]
+		return opCode, ok
+	}
+	return DecodeOpCode(hexCode)
+}
+
+// http://www.6502.org/tutorials/65c02opcodes.html
+var cmosHexToOpsCode = buildCmosOpsCode()
+
+func buildCmosOpsCode() map[uint8]OpCode {
+	var table = make(map[uint8]OpCode)
+	for hexCode, opCode := range hexToOpsCode {
+		if !opCode.IsUnofficial() {
+			table[hexCode] = opCode
+		}
+	}
+	// The page boundary bug of JMP indirect is fixed, at the cost of a cycle
+	table[0x6C] = OpCode{operation: JMP, addressingMode: Indirect, cycles: 6}
+	// Indexed shifts and rotations only spend the indexing cycle when a page is crossed
+	table[0x1E] = OpCode{operation: ASL, addressingMode: AbsoluteX, cycles: 6}
+	table[0x5E] = OpCode{operation: LSR, addressingMode: AbsoluteX, cycles: 6}
+	table[0x3E] = OpCode{operation: ROL, addressingMode: AbsoluteX, cycles: 6}
+	table[0x7E] = OpCode{operation: ROR, addressingMode: AbsoluteX, cycles: 6}
+
+	var additions = map[uint8]OpCode{
+		// BRA
+		0x80: {operation: BRA, addressingMode: Relative, cycles: 2},
+		// PHX, PHY, PLX, PLY
+		0xDA: {operation: PHX, addressingMode: Implied, cycles: 3},
+		0x5A: {operation: PHY, addressingMode: Implied, cycles: 3},
+		0xFA: {operation: PLX, addressingMode: Implied, cycles: 4},
+		0x7A: {operation: PLY, addressingMode: Implied, cycles: 4},
+		// STZ
+		0x64: {operation: STZ, addressingMode: ZeroPage, cycles: 3},
+		0x74: {operation: STZ, addressingMode: ZeroPageX, cycles: 4},
+		0x9C: {operation: STZ, addressingMode: Absolute, cycles: 4},
+		0x9E: {operation: STZ, addressingMode: AbsoluteX, cycles: 5},
+		// TRB
+		0x14: {operation: TRB, addressingMode: ZeroPage, cycles: 5},
+		0x1C: {operation: TRB, addressingMode: Absolute, cycles: 6},
+		// TSB
+		0x04: {operation: TSB, addressingMode: ZeroPage, cycles: 5},
+		0x0C: {operation: TSB, addressingMode: Absolute, cycles: 6},
+		// INC A, DEC A
+		0x1A: {operation: INC, addressingMode: Accumulator, cycles: 2},
+		0x3A: {operation: DEC, addressingMode: Accumulator, cycles: 2},
+		// BIT
+		0x89: {operation: BIT, addressingMode: Immediate, cycles: 2},
+		0x34: {operation: BIT, addressingMode: ZeroPageX, cycles: 4},
+		0x3C: {operation: BIT, addressingMode: AbsoluteX, cycles: 4},
+		// (zp)
+		0x12: {operation: ORA, addressingMode: ZeroPageIndirect, cycles: 5},
+		0x32: {operation: AND, addressingMode: ZeroPageIndirect, cycles: 5},
+		0x52: {operation: EOR, addressingMode: ZeroPageIndirect, cycles: 5},
+		0x72: {operation: ADC, addressingMode: ZeroPageIndirect, cycles: 5},
+		0x92: {operation: STA, addressingMode: ZeroPageIndirect, cycles: 5},
+		0xB2: {operation: LDA, addressingMode: ZeroPageIndirect, cycles: 5},
+		0xD2: {operation: CMP, addressingMode: ZeroPageIndirect, cycles: 5},
+		0xF2: {operation: SBC, addressingMode: ZeroPageIndirect, cycles: 5},
+		// JMP (abs,X)
+		0x7C: {operation: JMP, addressingMode: AbsoluteIndexedIndirect, cycles: 6},
+		// Reserved NOPs reading an operand
+		0x44: {operation: NOP, addressingMode: ZeroPage, cycles: 3},
+		0x54: {operation: NOP, addressingMode: ZeroPageX, cycles: 4},
+		0xD4: {operation: NOP, addressingMode: ZeroPageX, cycles: 4},
+		0xF4: {operation: NOP, addressingMode: ZeroPageX, cycles: 4},
+		0x5C: {operation: NOP, addressingMode: Absolute, cycles: 8},
+		0xDC: {operation: NOP, addressingMode: Absolute, cycles: 4},
+		0xFC: {operation: NOP, addressingMode: Absolute, cycles: 4},
+	}
+	for hexCode, opCode := range additions {
+		table[hexCode] = opCode
+	}
+
+	// Remaining opcodes are NOPs : $x2 skip an immediate byte, $x3, $x7, $xB and $xF take a single cycle
+	for hexCode := 0; hexCode <= 0xFF; hexCode++ {
+		if _, isDefined := table[uint8(hexCode)]; isDefined {
+			continue
+		}
+		switch hexCode & 0x0F {
+		case 0x02:
+			table[uint8(hexCode)] = OpCode{operation: NOP, addressingMode: Immediate, cycles: 2}
+		case 0x03, 0x07, 0x0B, 0x0F:
+			table[uint8(hexCode)] = OpCode{operation: NOP, addressingMode: Implied, cycles: 1}
+		default:
+			panic(fmt.Sprintf("65C02 opcode %02X is not defined", hexCode))
+		}
+	}
+	return table
+}
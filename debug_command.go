@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"nes-emulator/bus"
+	"nes-emulator/debugger"
+	"nes-emulator/nes_console"
+	"os"
+	"os/signal"
+)
+
+func runDebugCommand(arguments []string) error {
+	var flags = flag.NewFlagSet("debug", flag.ExitOnError)
+	flags.Parse(arguments)
+
+	fmt.Println(fmt.Sprintf("Reading rom  file at path %s...", ROM_PATH))
+	var rawRom, errorRead = os.ReadFile(ROM_PATH)
+	if errorRead != nil {
+		return errorRead
+	}
+	var rom, errorParse = bus.ParseRawRom(rawRom)
+	if errorParse != nil {
+		return errorParse
+	}
+
+	var console = nes_console.NewConsole()
+	console.LoadRom(rom)
+	console.PowerOn()
+	var romDebugger = debugger.NewDebugger(&console)
+	defer romDebugger.Close()
+
+	// Ctrl-C interrupts the running program instead of leaving the debugger
+	var interrupts = make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	go func() {
+		for range interrupts {
+			romDebugger.Interrupt()
+		}
+	}()
+
+	fmt.Println("Type help for the list of commands")
+	return romDebugger.RunREPL(os.Stdin, os.Stdout)
+}
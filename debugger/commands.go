@@ -0,0 +1,391 @@
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"nes-emulator/cpu"
+	"nes-emulator/nes_console"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const DUMP_DEFAULT_LENGTH int = 64
+const DUMP_BYTES_PER_LINE int = 16
+
+const HELP = `Addresses and values are hexadecimal ($ and 0x prefixes are accepted)
+  s, step [count]           execute instructions
+  n, next                   execute an instruction, stepping over subroutine calls
+  finish                    run until the current subroutine returns
+  c, continue               run until a breakpoint, a watchpoint or an interruption (Ctrl-C)
+  b, break <address>        break before executing address
+  d, delete <address>       remove the breakpoint at address
+  watch <r|w|rw> <start> [end]  break after an access to the address range
+  unwatch <start>           remove the watchpoints starting at start
+  breakop <operation>       break before executing an operation (e.g. KIL, BRK)
+  unbreakop <operation>     stop breaking on an operation
+  info                      list breakpoints, watchpoints and operations
+  r, regs                   print registers
+  set <a|x|y|sp|p|pc> <value>  edit a register
+  flag <c|z|i|d|v|n> <0|1>  edit a status flag
+  x, dump <address> [length]   dump memory
+  fill <address> <length> <value>  fill memory
+  bt, backtrace             print the call stack
+  trace <on|off>            print every executed instruction
+  q, quit                   leave the debugger`
+
+var flagsByName = map[string]cpu.StatusFlag{
+	"c": cpu.CARRY_FLAG,
+	"z": cpu.ZERO_FLAG,
+	"i": cpu.INTERRUPT_DISABLE_FLAG,
+	"d": cpu.DECIMAL_FLAG,
+	"v": cpu.OVERFLOW_FLAG,
+	"n": cpu.NEGATIVE_FLAG,
+}
+
+var errQuit = errors.New("quit")
+
+// Reads commands from input until quit or end of input
+func (debugger *Debugger) RunREPL(input io.Reader, output io.Writer) error {
+	var scanner = bufio.NewScanner(input)
+	fmt.Fprintln(output, debugger.nextInstruction())
+	for {
+		fmt.Fprint(output, "(nes) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(output)
+			return scanner.Err()
+		}
+		var err = debugger.execute(strings.Fields(scanner.Text()), output)
+		if errors.Is(err, errQuit) {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintln(output, "error:", err)
+		}
+	}
+}
+
+func (debugger *Debugger) execute(arguments []string, output io.Writer) (err error) {
+	if len(arguments) == 0 {
+		return nil
+	}
+	// Emulation errors such as writes to ROM must not kill the session
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("%v", recovered)
+		}
+	}()
+	var command, parameters = arguments[0], arguments[1:]
+	switch command {
+	case "h", "help":
+		fmt.Fprintln(output, HELP)
+	case "s", "step":
+		var count = 1
+		if len(parameters) > 0 {
+			if count, err = strconv.Atoi(parameters[0]); err != nil {
+				return err
+			}
+		}
+		var reason string
+		for i := 0; i < count && reason == "" && err == nil; i++ {
+			reason, err = debugger.step()
+		}
+		return debugger.reportStop(output, reason, err)
+	case "n", "next":
+		var reason, errorNext = debugger.Next()
+		return debugger.reportStop(output, reason, errorNext)
+	case "finish":
+		var reason, errorFinish = debugger.Finish()
+		return debugger.reportStop(output, reason, errorFinish)
+	case "c", "continue":
+		var reason, errorContinue = debugger.Continue()
+		return debugger.reportStop(output, reason, errorContinue)
+	case "b", "break":
+		var address, errorParse = parseAddressParameter(parameters)
+		if errorParse != nil {
+			return errorParse
+		}
+		debugger.AddBreakpoint(address)
+	case "d", "delete":
+		var address, errorParse = parseAddressParameter(parameters)
+		if errorParse != nil {
+			return errorParse
+		}
+		debugger.RemoveBreakpoint(address)
+	case "watch":
+		return debugger.addWatchpoint(parameters)
+	case "unwatch":
+		var start, errorParse = parseAddressParameter(parameters)
+		if errorParse != nil {
+			return errorParse
+		}
+		debugger.RemoveWatchpoints(start, 0)
+	case "breakop", "unbreakop":
+		if len(parameters) != 1 {
+			return errors.New("expected an operation")
+		}
+		var operation = strings.ToUpper(strings.TrimPrefix(parameters[0], "*"))
+		if !isKnownOperation(debugger.cpu.Variant(), operation) {
+			return fmt.Errorf("unknown operation %q", parameters[0])
+		}
+		if command == "breakop" {
+			debugger.breakOperations[operation] = true
+		} else {
+			delete(debugger.breakOperations, operation)
+		}
+	case "info":
+		debugger.printBreaks(output)
+	case "r", "regs":
+		debugger.printRegisters(output)
+	case "set":
+		return debugger.setRegister(parameters)
+	case "flag":
+		return debugger.setFlag(parameters)
+	case "x", "dump":
+		return debugger.dump(parameters, output)
+	case "fill":
+		return debugger.fill(parameters)
+	case "bt", "backtrace":
+		debugger.printCallStack(output)
+	case "trace":
+		if len(parameters) != 1 || (parameters[0] != "on" && parameters[0] != "off") {
+			return errors.New("expected on or off")
+		}
+		// Printed by the debugger rather than the CPU, so that the trace goes to the output of the REPL
+		debugger.traceOutput = nil
+		if parameters[0] == "on" {
+			debugger.traceOutput = output
+		}
+	case "q", "quit":
+		return errQuit
+	default:
+		return fmt.Errorf("unknown command %q, type help", command)
+	}
+	return nil
+}
+
+// Stops
+
+func (debugger *Debugger) reportStop(output io.Writer, reason string, err error) error {
+	if errors.Is(err, nes_console.ErrProgramStopped) {
+		fmt.Fprintln(output, "program stopped (BRK)")
+		return nil
+	}
+	if errors.Is(err, nes_console.ErrCpuHalted) {
+		fmt.Fprintf(output, "CPU halted by KIL at $%04X\n", debugger.cpu.ProgramCounter())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		fmt.Fprintln(output, "stopped:", reason)
+	}
+	fmt.Fprintln(output, debugger.nextInstruction())
+	return nil
+}
+
+// Breakpoints
+
+func (debugger *Debugger) addWatchpoint(parameters []string) error {
+	if len(parameters) < 2 || len(parameters) > 3 {
+		return errors.New("expected r, w or rw and an address range")
+	}
+	var kinds = map[string]WatchKind{"r": WATCH_READ, "w": WATCH_WRITE, "rw": WATCH_READ_WRITE}
+	var kind, isKnown = kinds[parameters[0]]
+	if !isKnown {
+		return fmt.Errorf("unknown access %q, expected r, w or rw", parameters[0])
+	}
+	var start, errorStart = parseNumber(parameters[1], 16)
+	if errorStart != nil {
+		return errorStart
+	}
+	var end = start
+	if len(parameters) == 3 {
+		var errorEnd error
+		if end, errorEnd = parseNumber(parameters[2], 16); errorEnd != nil {
+			return errorEnd
+		}
+	}
+	if end < start {
+		return errors.New("end of the range is before its start")
+	}
+	debugger.AddWatchpoint(uint16(start), uint16(end), kind)
+	return nil
+}
+
+func (debugger *Debugger) printBreaks(output io.Writer) {
+	var addresses []int
+	for address := range debugger.breakpoints {
+		addresses = append(addresses, int(address))
+	}
+	sort.Ints(addresses)
+	for _, address := range addresses {
+		fmt.Fprintf(output, "breakpoint $%04X\n", address)
+	}
+	var kindNames = map[WatchKind]string{WATCH_READ: "r", WATCH_WRITE: "w", WATCH_READ_WRITE: "rw"}
+	for _, watch := range debugger.watchpoints {
+		fmt.Fprintf(output, "watchpoint %-2s $%04X-$%04X\n", kindNames[watch.kind], watch.start, watch.end)
+	}
+	for operation := range debugger.breakOperations {
+		fmt.Fprintf(output, "operation  %s\n", operation)
+	}
+}
+
+// Registers
+
+func (debugger *Debugger) printRegisters(output io.Writer) {
+	var state = debugger.cpu.State()
+	fmt.Fprintf(output, "PC:%04X A:%02X X:%02X Y:%02X SP:%02X P:%02X [%s] CYC:%d\n",
+		state.ProgramCounter, state.A, state.X, state.Y, state.StackPointer, state.Status, state.FormatStatus(), state.Cycles)
+}
+
+func (debugger *Debugger) setRegister(parameters []string) error {
+	if len(parameters) != 2 {
+		return errors.New("expected a register and a value")
+	}
+	var bits = 8
+	if parameters[0] == "pc" {
+		bits = 16
+	}
+	var value, errorParse = parseNumber(parameters[1], bits)
+	if errorParse != nil {
+		return errorParse
+	}
+	var registers = debugger.cpu.Registers()
+	switch parameters[0] {
+	case "a":
+		registers.A = uint8(value)
+	case "x":
+		registers.X = uint8(value)
+	case "y":
+		registers.Y = uint8(value)
+	case "sp":
+		registers.StackPointer = uint8(value)
+	case "p":
+		registers.Status = uint8(value)
+	case "pc":
+		registers.ProgramCounter = uint16(value)
+	default:
+		return fmt.Errorf("unknown register %q", parameters[0])
+	}
+	debugger.cpu.SetRegisters(registers)
+	return nil
+}
+
+func (debugger *Debugger) setFlag(parameters []string) error {
+	if len(parameters) != 2 || (parameters[1] != "0" && parameters[1] != "1") {
+		return errors.New("expected a flag and 0 or 1")
+	}
+	var flag, isKnown = flagsByName[strings.ToLower(parameters[0])]
+	if !isKnown {
+		return fmt.Errorf("unknown flag %q", parameters[0])
+	}
+	debugger.cpu.SetFlag(flag, parameters[1] == "1")
+	return nil
+}
+
+// Memory
+
+func (debugger *Debugger) dump(parameters []string, output io.Writer) error {
+	if len(parameters) < 1 || len(parameters) > 2 {
+		return errors.New("expected an address and an optional length")
+	}
+	var address, errorAddress = parseNumber(parameters[0], 16)
+	if errorAddress != nil {
+		return errorAddress
+	}
+	var length = DUMP_DEFAULT_LENGTH
+	if len(parameters) == 2 {
+		var value, errorLength = parseNumber(parameters[1], 17)
+		if errorLength != nil {
+			return errorLength
+		}
+		length = int(value)
+	}
+	for offset := 0; offset < length; offset += DUMP_BYTES_PER_LINE {
+		var line = strings.Builder{}
+		var text = strings.Builder{}
+		line.WriteString(fmt.Sprintf("%04X ", uint16(int(address)+offset)))
+		for i := offset; i < offset+DUMP_BYTES_PER_LINE && i < length; i++ {
+			var data = debugger.readMemory(uint16(int(address) + i))
+			line.WriteString(fmt.Sprintf(" %02X", data))
+			if 0x20 <= data && data < 0x7F {
+				text.WriteByte(data)
+			} else {
+				text.WriteByte('.')
+			}
+		}
+		fmt.Fprintf(output, "%-54s %s\n", line.String(), text.String())
+	}
+	return nil
+}
+
+func (debugger *Debugger) fill(parameters []string) error {
+	if len(parameters) != 3 {
+		return errors.New("expected an address, a length and a value")
+	}
+	var address, errorAddress = parseNumber(parameters[0], 16)
+	if errorAddress != nil {
+		return errorAddress
+	}
+	var length, errorLength = parseNumber(parameters[1], 17)
+	if errorLength != nil {
+		return errorLength
+	}
+	var value, errorValue = parseNumber(parameters[2], 8)
+	if errorValue != nil {
+		return errorValue
+	}
+	for i := 0; i < int(length); i++ {
+		debugger.writeMemory(uint16(int(address)+i), uint8(value))
+	}
+	return nil
+}
+
+// Call stack
+
+// Innermost frame first, each frame shows where execution is in the subroutine
+func (debugger *Debugger) printCallStack(output io.Writer) {
+	var location = debugger.cpu.ProgramCounter()
+	for i := len(debugger.callStack) - 1; i >= 0; i-- {
+		var frame = debugger.callStack[i]
+		var kind = "subroutine"
+		if frame.isInterrupt {
+			kind = "IRQ handler"
+		}
+		fmt.Fprintf(output, "#%d $%04X in %s $%04X\n", len(debugger.callStack)-1-i, location, kind, frame.target)
+		location = frame.caller
+	}
+	fmt.Fprintf(output, "#%d $%04X\n", len(debugger.callStack), location)
+}
+
+// Parsing
+
+func isKnownOperation(variant cpu.Variant, name string) bool {
+	for opHexCode := 0; opHexCode <= 0xFF; opHexCode++ {
+		if opCode, isKnown := cpu.DecodeVariantOpCode(variant, uint8(opHexCode)); isKnown && strings.TrimPrefix(string(opCode.Operation()), "*") == name {
+			return true
+		}
+	}
+	return false
+}
+
+func parseAddressParameter(parameters []string) (uint16, error) {
+	if len(parameters) != 1 {
+		return 0, errors.New("expected an address")
+	}
+	var address, err = parseNumber(parameters[0], 16)
+	return uint16(address), err
+}
+
+func parseNumber(text string, bits int) (uint64, error) {
+	var digits = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(text), "$"), "0x")
+	var value, err = strconv.ParseUint(digits, 16, bits)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hexadecimal number %q", text)
+	}
+	return value, nil
+}
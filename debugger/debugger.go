@@ -0,0 +1,295 @@
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"nes-emulator/bus"
+	"nes-emulator/cpu"
+	"nes-emulator/nes_console"
+	"strings"
+	"sync/atomic"
+)
+
+type WatchKind int
+
+const (
+	WATCH_READ WatchKind = 1 << iota
+	WATCH_WRITE
+	WATCH_READ_WRITE = WATCH_READ | WATCH_WRITE
+)
+
+type watchpoint struct {
+	start uint16
+	end   uint16
+	kind  WatchKind
+	hook  bus.HookId
+}
+
+type WatchHit struct {
+	Address uint16
+	// Kind of the watchpoint which matched, 0 when no watchpoint stopped the last step
+	Kind WatchKind
+}
+
+// Subroutine entered with JSR, or IRQ handler, not returned from yet
+type callFrame struct {
+	// Address of the JSR, or of the instruction the IRQ interrupted
+	caller uint16
+	target uint16
+	// Stack pointer before the call : the subroutine has returned once the stack pointer goes back to it
+	stackPointer uint8
+	isInterrupt  bool
+}
+
+// Drives the console instruction by instruction and stops on breakpoints, watchpoints and chosen operations
+type Debugger struct {
+	console     *nes_console.NesConsole
+	cpu         *cpu.CPU
+	bus         *bus.Bus
+	breakpoints map[uint16]bool
+	watchpoints []watchpoint
+	// Operations to break on, without the "*" of unofficial ones
+	breakOperations map[string]bool
+	callStack       []callFrame
+	// Registers before the instruction being executed
+	stepRegisters cpu.Registers
+	// Executed instructions are printed there while the trace is on, nil otherwise
+	traceOutput io.Writer
+	// Set by the watchpoint hooks during an instruction, reported once it is done
+	watchHit     string
+	lastWatchHit WatchHit
+	// Writes made by the debugger itself must not trigger watchpoints
+	isWatchSuspended bool
+	isInterrupted    atomic.Bool
+}
+
+func NewDebugger(console *nes_console.NesConsole) *Debugger {
+	var debugger = &Debugger{
+		console:         console,
+		cpu:             console.CPU(),
+		bus:             console.Bus(),
+		breakpoints:     make(map[uint16]bool),
+		breakOperations: make(map[string]bool),
+	}
+	debugger.cpu.SetTraceEnabled(false)
+	console.SetInstructionHandler(debugger.trackCalls)
+	return debugger
+}
+
+// Detaches the debugger from the console and the bus
+func (debugger *Debugger) Close() {
+	debugger.console.SetInstructionHandler(nil)
+	for _, watch := range debugger.watchpoints {
+		debugger.bus.RemoveHook(watch.hook)
+	}
+	debugger.watchpoints = nil
+}
+
+// Stops a running continue/next/finish before the next instruction, safe to call from another goroutine
+func (debugger *Debugger) Interrupt() {
+	debugger.isInterrupted.Store(true)
+}
+
+// Watchpoints
+
+// Bus hook of a watchpoint, the first access matching a watchpoint stops the step
+func (debugger *Debugger) watchAccess(kind WatchKind, address uint16, data uint8, access bus.AccessKind) {
+	if debugger.isWatchSuspended || debugger.watchHit != "" {
+		return
+	}
+	var accessName = "read"
+	if access == bus.ACCESS_WRITE {
+		accessName = "write"
+	}
+	debugger.watchHit = fmt.Sprintf("watchpoint: %s $%02X at $%04X", accessName, data, address)
+	debugger.lastWatchHit = WatchHit{Address: address, Kind: kind}
+}
+
+func (kind WatchKind) accessKinds() bus.AccessKind {
+	var kinds bus.AccessKind
+	if kind&WATCH_READ != 0 {
+		kinds |= bus.ACCESS_READ
+	}
+	if kind&WATCH_WRITE != 0 {
+		kinds |= bus.ACCESS_WRITE
+	}
+	return kinds
+}
+
+// Breakpoints
+
+func (debugger *Debugger) AddBreakpoint(address uint16) {
+	debugger.breakpoints[address] = true
+}
+
+func (debugger *Debugger) RemoveBreakpoint(address uint16) {
+	delete(debugger.breakpoints, address)
+}
+
+func (debugger *Debugger) AddWatchpoint(start uint16, end uint16, kind WatchKind) {
+	var hook = debugger.bus.AddHook(start, end, kind.accessKinds(), func(address uint16, data uint8, access bus.AccessKind) {
+		debugger.watchAccess(kind, address, data, access)
+	})
+	debugger.watchpoints = append(debugger.watchpoints, watchpoint{start: start, end: end, kind: kind, hook: hook})
+}
+
+// Removes the watchpoints starting at start, of any kind if kind is 0
+func (debugger *Debugger) RemoveWatchpoints(start uint16, kind WatchKind) {
+	var kept []watchpoint
+	for _, watch := range debugger.watchpoints {
+		if watch.start != start || (kind != 0 && watch.kind != kind) {
+			kept = append(kept, watch)
+		} else {
+			debugger.bus.RemoveHook(watch.hook)
+		}
+	}
+	debugger.watchpoints = kept
+}
+
+// Access which stopped the last step, if the stop reason was a watchpoint
+func (debugger *Debugger) LastWatchHit() WatchHit {
+	return debugger.lastWatchHit
+}
+
+// Memory
+
+// Reads memory for display, without side effects
+func (debugger *Debugger) ReadMemory(address uint16) uint8 {
+	return debugger.readMemory(address)
+}
+
+func (debugger *Debugger) WriteMemory(address uint16, data uint8) {
+	debugger.writeMemory(address, data)
+}
+
+// Peeking neither triggers watchpoints nor disturbs the devices
+func (debugger *Debugger) readMemory(address uint16) uint8 {
+	return debugger.bus.Peek(address)
+}
+
+func (debugger *Debugger) writeMemory(address uint16, data uint8) {
+	debugger.isWatchSuspended = true
+	defer func() { debugger.isWatchSuspended = false }()
+	debugger.bus.MemoryWrite(address, data)
+}
+
+func (debugger *Debugger) nextInstruction() string {
+	return debugger.cpu.TraceNextInstruction()
+}
+
+// Execution
+
+// Decoded as the variant of the CPU does, the 65C02 redefines opcodes
+func (debugger *Debugger) nextOpCode() (cpu.OpCode, bool) {
+	return cpu.DecodeVariantOpCode(debugger.cpu.Variant(), debugger.readMemory(debugger.cpu.ProgramCounter()))
+}
+
+// Executes one instruction and returns why execution must stop after it, if it must
+func (debugger *Debugger) Step() (string, error) {
+	return debugger.step()
+}
+
+func (debugger *Debugger) step() (string, error) {
+	if debugger.traceOutput != nil {
+		fmt.Fprintln(debugger.traceOutput, debugger.nextInstruction())
+	}
+	debugger.stepRegisters = debugger.cpu.Registers()
+	debugger.watchHit = ""
+	debugger.lastWatchHit = WatchHit{}
+	if err := debugger.console.StepInstruction(); err != nil {
+		return "", err
+	}
+	return debugger.watchHit, nil
+}
+
+// Follows the calls with the instructions the CPU reports once executed, IRQs included
+func (debugger *Debugger) trackCalls(instruction cpu.ExecutedInstruction) {
+	var stackPointer = debugger.stepRegisters.StackPointer
+	if instruction.IsInterrupted {
+		debugger.callStack = append(debugger.callStack, callFrame{
+			caller:       debugger.stepRegisters.ProgramCounter,
+			target:       instruction.Address,
+			stackPointer: stackPointer,
+			isInterrupt:  true,
+		})
+		// The return address and the flags were pushed before the first instruction of the handler
+		stackPointer -= 3
+	}
+	if instruction.OpCode.Operation() == cpu.JSR {
+		debugger.callStack = append(debugger.callStack, callFrame{
+			caller:       instruction.Address,
+			target:       instruction.OperandAddress,
+			stackPointer: stackPointer,
+		})
+	}
+	// RTS, RTI or any stack manipulation dropping the return address leaves the subroutine
+	// The stack pointer is compared as a distance, since the stack wraps around its page
+	var registers = debugger.cpu.Registers()
+	for len(debugger.callStack) > 0 && int8(registers.StackPointer-debugger.callStack[len(debugger.callStack)-1].stackPointer) >= 0 {
+		debugger.callStack = debugger.callStack[:len(debugger.callStack)-1]
+	}
+}
+
+// Reason to stop before executing the instruction at the program counter, if any
+func (debugger *Debugger) breakReason() string {
+	var programCounter = debugger.cpu.ProgramCounter()
+	if debugger.breakpoints[programCounter] {
+		return fmt.Sprintf("breakpoint at $%04X", programCounter)
+	}
+	if opCode, isKnown := debugger.nextOpCode(); isKnown {
+		var operation = strings.TrimPrefix(string(opCode.Operation()), "*")
+		if debugger.breakOperations[operation] {
+			return fmt.Sprintf("operation %s at $%04X", operation, programCounter)
+		}
+	}
+	return ""
+}
+
+// Reason to stop given when Interrupt was called
+const INTERRUPTED = "interrupted"
+
+// Runs until isDone returns true or something stops execution, the first instruction never breaks
+// so that continuing from a breakpoint goes past it
+func (debugger *Debugger) runUntil(isDone func() bool) (string, error) {
+	debugger.isInterrupted.Store(false)
+	for isFirst := true; ; isFirst = false {
+		if !isFirst {
+			if isDone() {
+				return "", nil
+			}
+			if reason := debugger.breakReason(); reason != "" {
+				return reason, nil
+			}
+			if debugger.isInterrupted.Load() {
+				return INTERRUPTED, nil
+			}
+		}
+		if reason, err := debugger.step(); reason != "" || err != nil {
+			return reason, err
+		}
+	}
+}
+
+func (debugger *Debugger) Continue() (string, error) {
+	return debugger.runUntil(func() bool { return false })
+}
+
+// Steps over subroutine calls
+func (debugger *Debugger) Next() (string, error) {
+	var opCode, isKnown = debugger.nextOpCode()
+	if !isKnown || opCode.Operation() != cpu.JSR {
+		return debugger.step()
+	}
+	var depth = len(debugger.callStack)
+	return debugger.runUntil(func() bool { return len(debugger.callStack) <= depth })
+}
+
+// Runs until the current subroutine returns
+func (debugger *Debugger) Finish() (string, error) {
+	if len(debugger.callStack) == 0 {
+		return "", errors.New("not in a subroutine")
+	}
+	var depth = len(debugger.callStack) - 1
+	return debugger.runUntil(func() bool { return len(debugger.callStack) <= depth })
+}
@@ -0,0 +1,184 @@
+package debugger
+
+import (
+	"bytes"
+	"io"
+	"nes-emulator/bus"
+	"nes-emulator/cpu"
+	"nes-emulator/nes_console"
+	"strings"
+	"testing"
+)
+
+// Dumping memory and disassembling must peek, not read : no read hook runs and no watchpoint triggers
+func TestInspectionDoesNotRead(t *testing.T) {
+	var debugger = newTestDebugger(t)
+	defer debugger.Close()
+	debugger.AddWatchpoint(0x0000, 0xFFFF, WATCH_READ)
+	var reads = 0
+	var hook = debugger.bus.AddHook(0x0000, 0xFFFF, bus.ACCESS_READ, func(address uint16, data uint8, kind bus.AccessKind) {
+		reads++
+	})
+	defer debugger.bus.RemoveHook(hook)
+
+	for _, command := range [][]string{{"x", "0000", "100"}, {"x", "4015", "1"}, {"x", "C000"}, {"regs"}} {
+		if err := debugger.execute(command, io.Discard); err != nil {
+			t.Fatalf("%v: %v", command, err)
+		}
+	}
+	debugger.ReadMemory(0x4016)
+	debugger.nextInstruction()
+	if reads != 0 {
+		t.Errorf("%d reads went through MemoryRead", reads)
+	}
+	if debugger.LastWatchHit().Kind != 0 || debugger.watchHit != "" {
+		t.Errorf("inspecting memory triggered a watchpoint")
+	}
+}
+
+// The 65C02 turns $80 into BRA and $DA into PHX, which the debugger must decode as the CPU does
+func TestVariantOpCodes(t *testing.T) {
+	var debugger = newTestDebugger(t)
+	defer debugger.Close()
+	cpu.WithVariant(cpu.CMOS_65C02)(debugger.cpu)
+	var program = []uint8{
+		0xEA,       // $0200 NOP
+		0x80, 0x01, // $0201 BRA $0204
+		0xEA,             // $0203 NOP
+		0x20, 0x10, 0x02, // $0204 JSR $0210
+		0x4C, 0x07, 0x02, // $0207 JMP $0207
+	}
+	for i, data := range program {
+		debugger.WriteMemory(0x0200+uint16(i), data)
+	}
+	debugger.WriteMemory(0x0210, 0xDA) // PHX
+	debugger.WriteMemory(0x0211, 0xFA) // PLX
+	debugger.WriteMemory(0x0212, 0x60) // RTS
+	var registers = debugger.cpu.Registers()
+	registers.ProgramCounter = 0x0200
+	debugger.cpu.SetRegisters(registers)
+
+	for _, command := range [][]string{{"breakop", "BRA"}, {"breakop", "PHX"}} {
+		if err := debugger.execute(command, io.Discard); err != nil {
+			t.Fatalf("%v: %v", command, err)
+		}
+	}
+	var reason, err = debugger.Continue()
+	if err != nil || reason != "operation BRA at $0201" {
+		t.Fatalf("Continue() = %q, %v, want a break on BRA", reason, err)
+	}
+	if _, err := debugger.Step(); err != nil || debugger.cpu.ProgramCounter() != 0x0204 {
+		t.Fatalf("BRA went to $%04X, %v", debugger.cpu.ProgramCounter(), err)
+	}
+	// Stepping over the subroutine breaks on the PHX inside it
+	reason, err = debugger.Next()
+	if err != nil || reason != "operation PHX at $0210" {
+		t.Fatalf("Next() = %q, %v, want a break on PHX", reason, err)
+	}
+	delete(debugger.breakOperations, "PHX")
+	if _, err := debugger.Finish(); err != nil || debugger.cpu.ProgramCounter() != 0x0207 {
+		t.Errorf("Finish() returned to $%04X, %v, want $0207", debugger.cpu.ProgramCounter(), err)
+	}
+}
+
+// Debugger of a console running a 16 KiB NROM image, program maps offsets in the bank to their bytes
+func newRomDebugger(t *testing.T, program map[int][]uint8) *Debugger {
+	var image = make([]uint8, 16+bus.PRG_ROM_PAGE_SIZE)
+	copy(image, []uint8{'N', 'E', 'S', 0x1A, 1, 0})
+	for offset, bytes := range program {
+		copy(image[16+offset:], bytes)
+	}
+	var rom, err = bus.ParseRawRom(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var console = nes_console.NewConsole()
+	console.LoadRom(rom)
+	console.PowerOn()
+	return NewDebugger(&console)
+}
+
+// The frame IRQ of the APU interrupts a loop calling a subroutine
+func TestCallStackInterrupt(t *testing.T) {
+	var debugger = newRomDebugger(t, map[int][]uint8{
+		0x0000: {0x58},             // $C000 CLI
+		0x0001: {0x20, 0x20, 0xC0}, // $C001 JSR $C020
+		0x0004: {0x4C, 0x01, 0xC0}, // $C004 JMP $C001
+		0x0010: {0xEA},             // $C010 NOP
+		0x0011: {0x20, 0x20, 0xC0}, // $C011 JSR $C020
+		0x0014: {0xAD, 0x15, 0x40}, // $C014 LDA $4015 : acknowledges the IRQ
+		0x0017: {0x40},             // $C017 RTI
+		0x0020: {0x60},             // $C020 RTS
+		0x3FFE: {0x10, 0xC0},       // IRQ vector
+	})
+	defer debugger.Close()
+	for steps := 0; steps < 20000; steps++ {
+		if _, err := debugger.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if len(debugger.callStack) == 0 {
+			continue
+		}
+		var depth = len(debugger.callStack)
+		var frame = debugger.callStack[depth-1]
+		if !frame.isInterrupt {
+			if depth != 1 || frame.target != 0xC020 || frame.caller != 0xC001 {
+				t.Fatalf("call stack %+v in the loop", debugger.callStack)
+			}
+			continue
+		}
+		// The IRQ is taken before the JSR or the JMP of the loop, or in the subroutine before its RTS
+		var isInLoop = depth == 1 && (frame.caller == 0xC001 || frame.caller == 0xC004)
+		var isInSubroutine = depth == 2 && frame.caller == 0xC020 && debugger.callStack[0].target == 0xC020
+		if !(isInLoop || isInSubroutine) || debugger.cpu.ProgramCounter() != 0xC011 || frame.target != 0xC010 {
+			t.Fatalf("call stack %+v when entering the IRQ handler", debugger.callStack)
+		}
+		if _, err := debugger.Step(); err != nil || len(debugger.callStack) != depth+1 || debugger.callStack[depth].target != 0xC020 {
+			t.Fatalf("call stack %+v after the JSR of the handler, %v", debugger.callStack, err)
+		}
+		// Out of the subroutine, then out of the handler
+		if _, err := debugger.Finish(); err != nil || debugger.cpu.ProgramCounter() != 0xC014 {
+			t.Fatalf("Finish() returned to $%04X, %v", debugger.cpu.ProgramCounter(), err)
+		}
+		if _, err := debugger.Finish(); err != nil || debugger.cpu.ProgramCounter() != frame.caller || len(debugger.callStack) != depth-1 {
+			t.Fatalf("Finish() returned from the IRQ handler to $%04X with %+v, %v", debugger.cpu.ProgramCounter(), debugger.callStack, err)
+		}
+		return
+	}
+	t.Fatalf("no IRQ was taken")
+}
+
+// The stack pointer wraps from $00 to $FF during a JSR
+func TestCallStackWrap(t *testing.T) {
+	var debugger = newTestDebugger(t)
+	defer debugger.Close()
+	debugger.WriteMemory(0x0200, 0x20) // JSR $0210
+	debugger.WriteMemory(0x0201, 0x10)
+	debugger.WriteMemory(0x0202, 0x02)
+	debugger.WriteMemory(0x0210, 0x60) // RTS
+	var registers = debugger.cpu.Registers()
+	registers.ProgramCounter = 0x0200
+	registers.StackPointer = 0x01
+	debugger.cpu.SetRegisters(registers)
+	if _, err := debugger.Step(); err != nil || len(debugger.callStack) != 1 {
+		t.Fatalf("call stack %+v after the JSR, %v", debugger.callStack, err)
+	}
+	if _, err := debugger.Step(); err != nil || len(debugger.callStack) != 0 || debugger.cpu.ProgramCounter() != 0x0203 {
+		t.Fatalf("call stack %+v at $%04X after the RTS, %v", debugger.callStack, debugger.cpu.ProgramCounter(), err)
+	}
+}
+
+func TestTraceOutput(t *testing.T) {
+	var debugger = newTestDebugger(t)
+	defer debugger.Close()
+	var output bytes.Buffer
+	for _, command := range [][]string{{"trace", "on"}, {"s", "2"}, {"trace", "off"}, {"s"}} {
+		if err := debugger.execute(command, &output); err != nil {
+			t.Fatalf("%v: %v", command, err)
+		}
+	}
+	// Both traced instructions, each followed by the stop report of the step
+	if lines := strings.Count(output.String(), "\n"); !strings.HasPrefix(output.String(), "C000  4C F5 C5") || lines < 3 {
+		t.Errorf("trace written to the output of the REPL:\n%s", output.String())
+	}
+}
@@ -0,0 +1,486 @@
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"nes-emulator/cpu"
+	"nes-emulator/nes_console"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// GDB remote serial protocol : https://sourceware.org/gdb/onlinedocs/gdb/Remote-Protocol.html
+
+// Byte sent by the client to interrupt the running program, outside of any packet
+const GDB_INTERRUPT byte = 0x03
+const GDB_PACKET_SIZE int = 0x4000
+
+// Registers in the order of the "g" packet, PC is little endian
+const (
+	GDB_REGISTER_A = iota
+	GDB_REGISTER_X
+	GDB_REGISTER_Y
+	GDB_REGISTER_SP
+	GDB_REGISTER_P
+	GDB_REGISTER_PC
+	GDB_NUMBER_OF_REGISTERS
+)
+
+// GDB has no 6502 architecture, so the registers are described to the client
+const GDB_TARGET_DESCRIPTION = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.nes-emulator.6502">
+    <reg name="a" bitsize="8" type="uint8" regnum="0"/>
+    <reg name="x" bitsize="8" type="uint8"/>
+    <reg name="y" bitsize="8" type="uint8"/>
+    <reg name="sp" bitsize="8" type="uint8"/>
+    <reg name="p" bitsize="8" type="uint8"/>
+    <reg name="pc" bitsize="16" type="code_ptr"/>
+  </feature>
+</target>`
+
+// Stop signals
+const (
+	GDB_SIGINT  = 2
+	GDB_SIGILL  = 4
+	GDB_SIGTRAP = 5
+)
+
+var errGdbSessionEnded = errors.New("gdb session ended")
+var errGdbKilled = errors.New("gdb killed the target")
+
+type gdbSession struct {
+	debugger   *Debugger
+	connection io.ReadWriter
+	writer     *bufio.Writer
+	packets    chan string
+	isRunning  atomic.Bool
+	// The reader acknowledges and resends packets while the main loop replies, or runs the program
+	writeLock  sync.Mutex
+	lastPacket string
+	stopReply  string
+}
+
+// Serves GDB clients one at a time until the listener is closed or a client kills the target
+func ServeGDB(listener net.Listener, debugger *Debugger) error {
+	for {
+		var connection, err = listener.Accept()
+		if err != nil {
+			return err
+		}
+		err = serveGDBConnection(connection, debugger)
+		connection.Close()
+		if errors.Is(err, errGdbKilled) {
+			return nil
+		}
+	}
+}
+
+func serveGDBConnection(connection io.ReadWriter, debugger *Debugger) error {
+	var session = &gdbSession{
+		debugger:   debugger,
+		connection: connection,
+		writer:     bufio.NewWriter(connection),
+		packets:    make(chan string),
+		stopReply:  fmt.Sprintf("S%02x", GDB_SIGTRAP),
+	}
+	go session.readPackets()
+	for packet := range session.packets {
+		var reply, err = session.handlePacket(packet)
+		if err != nil {
+			return err
+		}
+		if err := session.sendPacket(reply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Packets
+
+// Reads packets until the connection is closed, interrupts are handled on the fly since the program may be running
+func (session *gdbSession) readPackets() {
+	defer close(session.packets)
+	var reader = bufio.NewReader(session.connection)
+	for {
+		var data, err = reader.ReadByte()
+		if err != nil {
+			return
+		}
+		switch data {
+		case '+':
+		case '-':
+			session.resend()
+		case GDB_INTERRUPT:
+			if session.isRunning.Load() {
+				session.debugger.Interrupt()
+			} else {
+				session.packets <- string(GDB_INTERRUPT)
+			}
+		case '$':
+			var packet, errPacket = reader.ReadString('#')
+			if errPacket != nil {
+				return
+			}
+			var checksum = make([]byte, 2)
+			if _, errChecksum := io.ReadFull(reader, checksum); errChecksum != nil {
+				return
+			}
+			packet = strings.TrimSuffix(packet, "#")
+			if expected, errParse := strconv.ParseUint(string(checksum), 16, 8); errParse != nil || uint8(expected) != gdbChecksum(packet) {
+				session.acknowledge('-')
+				continue
+			}
+			session.acknowledge('+')
+			session.packets <- packet
+		}
+	}
+}
+
+func gdbChecksum(data string) uint8 {
+	var checksum uint8
+	for i := 0; i < len(data); i++ {
+		checksum += data[i]
+	}
+	return checksum
+}
+
+func (session *gdbSession) sendPacket(data string) error {
+	var escaped = strings.Builder{}
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case '$', '#', '}', '*':
+			escaped.WriteByte('}')
+			escaped.WriteByte(data[i] ^ 0x20)
+		default:
+			escaped.WriteByte(data[i])
+		}
+	}
+	session.writeLock.Lock()
+	defer session.writeLock.Unlock()
+	session.lastPacket = escaped.String()
+	fmt.Fprintf(session.writer, "$%s#%02x", session.lastPacket, gdbChecksum(session.lastPacket))
+	return session.writer.Flush()
+}
+
+func (session *gdbSession) resend() {
+	session.writeLock.Lock()
+	defer session.writeLock.Unlock()
+	fmt.Fprintf(session.writer, "$%s#%02x", session.lastPacket, gdbChecksum(session.lastPacket))
+	session.writer.Flush()
+}
+
+func (session *gdbSession) acknowledge(ack byte) {
+	session.writeLock.Lock()
+	defer session.writeLock.Unlock()
+	session.writer.WriteByte(ack)
+	session.writer.Flush()
+}
+
+func (session *gdbSession) handlePacket(packet string) (reply string, err error) {
+	// Emulation errors (KIL, write to ROM) stop the program or fail the command instead of killing the server
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			reply = "E01"
+			if session.isRunning.Load() {
+				session.isRunning.Store(false)
+				session.stopReply = fmt.Sprintf("S%02x", GDB_SIGILL)
+				reply = session.stopReply
+			}
+		}
+	}()
+	if packet == string(GDB_INTERRUPT) {
+		session.stopReply = fmt.Sprintf("S%02x", GDB_SIGINT)
+		return session.stopReply, nil
+	}
+	if packet == "" {
+		return "", nil
+	}
+	var command, arguments = packet[0], packet[1:]
+	switch command {
+	case '?':
+		return session.stopReply, nil
+	case 'g':
+		return session.readRegisters(), nil
+	case 'G':
+		return session.writeRegisters(arguments), nil
+	case 'p':
+		return session.readRegister(arguments), nil
+	case 'P':
+		return session.writeRegister(arguments), nil
+	case 'm':
+		return session.readMemory(arguments), nil
+	case 'M':
+		return session.writeMemory(arguments), nil
+	case 'Z', 'z':
+		return session.setBreakpoint(command == 'Z', arguments), nil
+	case 's', 'c':
+		if arguments != "" {
+			var address, errParse = strconv.ParseUint(arguments, 16, 16)
+			if errParse != nil {
+				return "E01", nil
+			}
+			var registers = session.debugger.cpu.Registers()
+			registers.ProgramCounter = uint16(address)
+			session.debugger.cpu.SetRegisters(registers)
+		}
+		session.isRunning.Store(true)
+		var reason string
+		var errRun error
+		if command == 's' {
+			reason, errRun = session.debugger.Step()
+		} else {
+			reason, errRun = session.debugger.Continue()
+		}
+		session.isRunning.Store(false)
+		return session.stop(reason, errRun), nil
+	case 'D':
+		session.sendPacket("OK")
+		return "", errGdbSessionEnded
+	case 'k':
+		return "", errGdbKilled
+	case 'H', 'T':
+		return "OK", nil
+	case 'q':
+		return session.query(arguments), nil
+	default:
+		return "", nil
+	}
+}
+
+// Stop replies
+
+func (session *gdbSession) stop(reason string, err error) string {
+	switch {
+	case errors.Is(err, nes_console.ErrProgramStopped):
+		session.stopReply = "W00"
+	case err != nil:
+		session.stopReply = fmt.Sprintf("S%02x", GDB_SIGILL)
+	case reason == INTERRUPTED:
+		session.stopReply = fmt.Sprintf("S%02x", GDB_SIGINT)
+	case session.debugger.LastWatchHit().Kind != 0:
+		var hit = session.debugger.LastWatchHit()
+		var names = map[WatchKind]string{WATCH_READ: "rwatch", WATCH_WRITE: "watch", WATCH_READ_WRITE: "awatch"}
+		session.stopReply = fmt.Sprintf("T%02x%s:%x;", GDB_SIGTRAP, names[hit.Kind], hit.Address)
+	case reason != "":
+		session.stopReply = fmt.Sprintf("T%02xswbreak:;", GDB_SIGTRAP)
+	default:
+		session.stopReply = fmt.Sprintf("S%02x", GDB_SIGTRAP)
+	}
+	return session.stopReply
+}
+
+// Registers
+
+func (session *gdbSession) registerValues() [GDB_NUMBER_OF_REGISTERS]uint16 {
+	var registers = session.debugger.cpu.Registers()
+	return [GDB_NUMBER_OF_REGISTERS]uint16{
+		uint16(registers.A),
+		uint16(registers.X),
+		uint16(registers.Y),
+		uint16(registers.StackPointer),
+		uint16(registers.Status),
+		registers.ProgramCounter,
+	}
+}
+
+func (session *gdbSession) setRegisterValues(values [GDB_NUMBER_OF_REGISTERS]uint16) {
+	session.debugger.cpu.SetRegisters(cpu.Registers{
+		A:              uint8(values[GDB_REGISTER_A]),
+		X:              uint8(values[GDB_REGISTER_X]),
+		Y:              uint8(values[GDB_REGISTER_Y]),
+		StackPointer:   uint8(values[GDB_REGISTER_SP]),
+		Status:         uint8(values[GDB_REGISTER_P]),
+		ProgramCounter: values[GDB_REGISTER_PC],
+	})
+}
+
+func formatGdbRegister(number int, value uint16) string {
+	if number == GDB_REGISTER_PC {
+		return fmt.Sprintf("%02x%02x", value&0xFF, value>>8)
+	}
+	return fmt.Sprintf("%02x", value)
+}
+
+// Returns the value of the register at the beginning of hex and the number of hex digits it takes
+func parseGdbRegister(number int, hex string) (uint16, int, error) {
+	var size = 2
+	if number == GDB_REGISTER_PC {
+		size = 4
+	}
+	if len(hex) < size {
+		return 0, 0, errors.New("register value is too short")
+	}
+	var value, err = strconv.ParseUint(hex[:size], 16, 16)
+	if number == GDB_REGISTER_PC {
+		value = value>>8 | (value&0xFF)<<8
+	}
+	return uint16(value), size, err
+}
+
+func (session *gdbSession) readRegisters() string {
+	var builder = strings.Builder{}
+	for number, value := range session.registerValues() {
+		builder.WriteString(formatGdbRegister(number, value))
+	}
+	return builder.String()
+}
+
+func (session *gdbSession) writeRegisters(hex string) string {
+	var values [GDB_NUMBER_OF_REGISTERS]uint16
+	for number := range values {
+		var value, size, err = parseGdbRegister(number, hex)
+		if err != nil {
+			return "E01"
+		}
+		values[number] = value
+		hex = hex[size:]
+	}
+	session.setRegisterValues(values)
+	return "OK"
+}
+
+func (session *gdbSession) readRegister(arguments string) string {
+	var number, err = strconv.ParseUint(arguments, 16, 8)
+	if err != nil || number >= GDB_NUMBER_OF_REGISTERS {
+		return "E01"
+	}
+	return formatGdbRegister(int(number), session.registerValues()[number])
+}
+
+func (session *gdbSession) writeRegister(arguments string) string {
+	var numberHex, valueHex, isFound = strings.Cut(arguments, "=")
+	var number, err = strconv.ParseUint(numberHex, 16, 8)
+	if !isFound || err != nil || number >= GDB_NUMBER_OF_REGISTERS {
+		return "E01"
+	}
+	var value, _, errValue = parseGdbRegister(int(number), valueHex)
+	if errValue != nil {
+		return "E01"
+	}
+	var values = session.registerValues()
+	values[number] = value
+	session.setRegisterValues(values)
+	return "OK"
+}
+
+// Memory
+
+// Parses "address,length" in hex
+func parseGdbRange(arguments string) (uint16, int, error) {
+	var addressHex, lengthHex, isFound = strings.Cut(arguments, ",")
+	if !isFound {
+		return 0, 0, errors.New("missing length")
+	}
+	var address, errAddress = strconv.ParseUint(addressHex, 16, 16)
+	if errAddress != nil {
+		return 0, 0, errAddress
+	}
+	var length, errLength = strconv.ParseUint(lengthHex, 16, 17)
+	return uint16(address), int(length), errLength
+}
+
+func (session *gdbSession) readMemory(arguments string) string {
+	var address, length, err = parseGdbRange(arguments)
+	if err != nil || length*2 > GDB_PACKET_SIZE {
+		return "E01"
+	}
+	var builder = strings.Builder{}
+	for i := 0; i < length; i++ {
+		builder.WriteString(fmt.Sprintf("%02x", session.debugger.ReadMemory(address+uint16(i))))
+	}
+	return builder.String()
+}
+
+func (session *gdbSession) writeMemory(arguments string) string {
+	var memoryRange, hex, isFound = strings.Cut(arguments, ":")
+	var address, length, err = parseGdbRange(memoryRange)
+	if !isFound || err != nil || len(hex) != length*2 {
+		return "E01"
+	}
+	for i := 0; i < length; i++ {
+		var data, errData = strconv.ParseUint(hex[2*i:2*i+2], 16, 8)
+		if errData != nil {
+			return "E01"
+		}
+		session.debugger.WriteMemory(address+uint16(i), uint8(data))
+	}
+	return "OK"
+}
+
+// Breakpoints
+
+// Types : 0 software breakpoint, 1 hardware breakpoint, 2 write watchpoint, 3 read watchpoint, 4 access watchpoint
+// The kind of watchpoints is the number of bytes watched
+func (session *gdbSession) setBreakpoint(isInserted bool, arguments string) string {
+	var parts = strings.Split(arguments, ",")
+	if len(parts) < 3 {
+		return "E01"
+	}
+	var address, errAddress = strconv.ParseUint(parts[1], 16, 16)
+	var length, errLength = strconv.ParseUint(parts[2], 16, 16)
+	if errAddress != nil || errLength != nil {
+		return "E01"
+	}
+	var watchKinds = map[string]WatchKind{"2": WATCH_WRITE, "3": WATCH_READ, "4": WATCH_READ_WRITE}
+	switch {
+	case parts[0] == "0" || parts[0] == "1":
+		if isInserted {
+			session.debugger.AddBreakpoint(uint16(address))
+		} else {
+			session.debugger.RemoveBreakpoint(uint16(address))
+		}
+	case watchKinds[parts[0]] != 0:
+		if isInserted {
+			if length == 0 {
+				length = 1
+			}
+			var end = uint16(address) + uint16(length) - 1
+			session.debugger.AddWatchpoint(uint16(address), end, watchKinds[parts[0]])
+		} else {
+			session.debugger.RemoveWatchpoints(uint16(address), watchKinds[parts[0]])
+		}
+	default:
+		return ""
+	}
+	return "OK"
+}
+
+// Queries
+
+func (session *gdbSession) query(arguments string) string {
+	switch {
+	case strings.HasPrefix(arguments, "Supported"):
+		return fmt.Sprintf("PacketSize=%x;qXfer:features:read+;swbreak+;hwbreak+", GDB_PACKET_SIZE)
+	case strings.HasPrefix(arguments, "Xfer:features:read:target.xml:"):
+		var offset, length, err = parseGdbRange(strings.TrimPrefix(arguments, "Xfer:features:read:target.xml:"))
+		if err != nil {
+			return "E01"
+		}
+		if int(offset) >= len(GDB_TARGET_DESCRIPTION) {
+			return "l"
+		}
+		var end = int(offset) + length
+		if end >= len(GDB_TARGET_DESCRIPTION) {
+			return "l" + GDB_TARGET_DESCRIPTION[offset:]
+		}
+		return "m" + GDB_TARGET_DESCRIPTION[offset:end]
+	case arguments == "Attached":
+		return "1"
+	case arguments == "C":
+		return "QC1"
+	case arguments == "fThreadInfo":
+		return "m1"
+	case arguments == "sThreadInfo":
+		return "l"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,95 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"nes-emulator/bus"
+	"nes-emulator/nes_console"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newTestDebugger(t *testing.T) *Debugger {
+	var rawRom, err = os.ReadFile("../resources/nestest.nes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rom, err := bus.ParseRawRom(rawRom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var console = nes_console.NewConsole()
+	console.LoadRom(rom)
+	console.PowerOn()
+	return NewDebugger(&console)
+}
+
+func gdbPacket(data string) string {
+	return fmt.Sprintf("$%s#%02x", data, gdbChecksum(data))
+}
+
+// Acks and resends of the reader goroutine must not interleave with the replies of the main loop
+// Run with -race to check the accesses to the writer and the last packet
+func TestGdbResendDuringReplies(t *testing.T) {
+	var server, client = net.Pipe()
+	defer client.Close()
+	var done = make(chan error)
+	go func() {
+		done <- serveGDBConnection(server, newTestDebugger(t))
+		server.Close()
+	}()
+
+	var received = make(chan string)
+	go func() {
+		var output, _ = io.ReadAll(client)
+		received <- string(output)
+	}()
+	const REQUESTS = 50
+	for i := 0; i < REQUESTS; i++ {
+		fmt.Fprint(client, gdbPacket("g"))
+		fmt.Fprint(client, "-")
+	}
+	fmt.Fprint(client, gdbPacket("k"))
+	if err := <-done; err != errGdbKilled {
+		t.Fatalf("session ended with %v, want %v", err, errGdbKilled)
+	}
+	client.Close()
+
+	var reader = bufio.NewReader(strings.NewReader(<-received))
+	var acks, packets int
+	for {
+		var data, err = reader.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		switch data {
+		case '+':
+			acks++
+		case '$':
+			var packet, errPacket = reader.ReadString('#')
+			if errPacket != nil {
+				t.Fatalf("truncated packet %q", packet)
+			}
+			var checksum = make([]byte, 2)
+			io.ReadFull(reader, checksum)
+			packet = strings.TrimSuffix(packet, "#")
+			if expected, _ := strconv.ParseUint(string(checksum), 16, 8); uint8(expected) != gdbChecksum(packet) || strings.ContainsAny(packet, "+$") {
+				t.Fatalf("corrupted packet %q#%s", packet, checksum)
+			}
+			packets++
+		default:
+			t.Fatalf("unexpected byte %q outside of a packet", data)
+		}
+	}
+	// The kill packet is acknowledged but not answered
+	if acks != REQUESTS+1 {
+		t.Errorf("%d acks, want %d", acks, REQUESTS+1)
+	}
+	if packets < REQUESTS {
+		t.Errorf("%d packets, want at least %d", packets, REQUESTS)
+	}
+}
@@ -0,0 +1,325 @@
+package disasm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"nes-emulator/bus"
+	"nes-emulator/cpu"
+	"sort"
+	"strings"
+)
+
+// Output is meant to be assembled back with ca65 : https://cc65.github.io/doc/ca65.html
+
+// Maximum number of bytes of a .byte line
+const BYTES_PER_DATA_LINE int = 8
+
+// Column of the address comments
+const COMMENT_COLUMN int = 32
+
+type Options struct {
+	// Address of the first byte
+	Origin uint16
+	// Bytes for which it returns true are output as .byte, everything is considered code when nil
+	IsData func(address uint16) bool
+}
+
+type line struct {
+	address uint16
+	size    int
+	opCode  cpu.OpCode
+	// Instructions which would not assemble back to the same bytes are output as data
+	isData bool
+	// Name of the unofficial instruction output as data
+	unofficial string
+}
+
+type disassembler struct {
+	code    []uint8
+	options Options
+	lines   []line
+	// Names of the addresses targeted by branches, jumps and vectors
+	labels map[uint16]string
+	// Hardware registers referenced by the code, to define them in the header
+	usedRegisters map[uint16]bool
+	hasVectors    bool
+}
+
+// Writes the ca65 source of code, placed at options.Origin
+func Disassemble(output io.Writer, code []uint8, options Options) error {
+	if int(options.Origin)+len(code) > 0x10000 {
+		return errors.New("code goes past the end of the address space")
+	}
+	var disassembler = disassembler{
+		code:          code,
+		options:       options,
+		labels:        make(map[uint16]string),
+		usedRegisters: make(map[uint16]bool),
+	}
+	disassembler.decode()
+	disassembler.findLabels()
+	var body = disassembler.formatBody()
+
+	var builder = strings.Builder{}
+	builder.WriteString(".setcpu \"6502\"\n\n")
+	if len(disassembler.usedRegisters) > 0 {
+		for _, address := range sortedAddresses(disassembler.usedRegisters) {
+			builder.WriteString(fmt.Sprintf("%-12s = $%04X\n", hardwareRegisters[address], address))
+		}
+		builder.WriteString("\n")
+	}
+	builder.WriteString(fmt.Sprintf(".org $%04X\n\n", options.Origin))
+	builder.WriteString(body)
+	_, err := io.WriteString(output, builder.String())
+	return err
+}
+
+// Number of 16 KiB banks of the PRG ROM
+func NumberOfPrgBanks(rom *bus.Rom) int {
+	return len(rom.PrgRom()) / bus.PRG_ROM_PAGE_SIZE
+}
+
+// Content of a 16 KiB bank of the PRG ROM and the address the CPU sees it at
+// Mapper 0 maps the whole PRG ROM at the end of the address space, other mappers are assumed
+// to keep their last bank fixed at $C000 and to switch the others at $8000
+func PrgBank(rom *bus.Rom, bank int) ([]uint8, uint16, error) {
+	var numberOfBanks = NumberOfPrgBanks(rom)
+	if bank < 0 || bank >= numberOfBanks {
+		return nil, 0, fmt.Errorf("bank %d does not exist, PRG ROM contains %d banks", bank, numberOfBanks)
+	}
+	var start = bank * bus.PRG_ROM_PAGE_SIZE
+	var content = rom.PrgRom()[start : start+bus.PRG_ROM_PAGE_SIZE]
+	var origin uint16 = 0x8000
+	if bank == numberOfBanks-1 || (rom.Mapper() == 0 && bank == 1) {
+		origin = 0xC000
+	}
+	return content, origin, nil
+}
+
+// Writes the ca65 source of a bank of the PRG ROM
+func DisassemblePrgBank(output io.Writer, rom *bus.Rom, bank int, isData func(address uint16) bool) error {
+	var content, origin, err = PrgBank(rom, bank)
+	if err != nil {
+		return err
+	}
+	return Disassemble(output, content, Options{Origin: origin, IsData: isData})
+}
+
+func (disassembler *disassembler) address(offset int) uint16 {
+	return disassembler.options.Origin + uint16(offset)
+}
+
+func (disassembler *disassembler) isData(offset int) bool {
+	return disassembler.options.IsData != nil && disassembler.options.IsData(disassembler.address(offset))
+}
+
+// Linear sweep : each byte belongs to a single line
+func (disassembler *disassembler) decode() {
+	var end = len(disassembler.code)
+	// The vectors are output as .word when the code covers them
+	if int(disassembler.options.Origin)+end == 0x10000 && end >= 6 {
+		disassembler.hasVectors = true
+		end -= 6
+	}
+	for offset := 0; offset < end; {
+		var opCode, isKnown = cpu.DecodeOpCode(disassembler.code[offset])
+		var size = int(opCode.Size())
+		var isData = !isKnown || offset+size > end
+		for i := 0; !isData && i < size; i++ {
+			isData = disassembler.isData(offset + i)
+		}
+		var line = line{address: disassembler.address(offset), size: size, opCode: opCode}
+		switch {
+		case isData:
+			line.size = 1
+			line.isData = true
+		// ca65 has no single encoding for most unofficial instructions : their bytes are kept as they are
+		case opCode.IsUnofficial():
+			line.isData = true
+			line.unofficial = strings.ToLower(opCode.Mnemonic())
+		}
+		disassembler.lines = append(disassembler.lines, line)
+		offset += line.size
+	}
+}
+
+func (disassembler *disassembler) operand(line line) uint16 {
+	var offset = int(line.address - disassembler.options.Origin)
+	if line.size == 2 {
+		return uint16(disassembler.code[offset+1])
+	}
+	return uint16(disassembler.code[offset+1]) | uint16(disassembler.code[offset+2])<<8
+}
+
+func (disassembler *disassembler) vector(address uint16) uint16 {
+	var offset = int(address - disassembler.options.Origin)
+	return uint16(disassembler.code[offset]) | uint16(disassembler.code[offset+1])<<8
+}
+
+// Target of a branch or a jump, if the instruction has one
+func (disassembler *disassembler) target(line line) (uint16, bool) {
+	if line.isData {
+		return 0, false
+	}
+	switch {
+	case line.opCode.AddressingMode() == cpu.Relative:
+		return line.address + 2 + uint16(int8(disassembler.operand(line))), true
+	case line.opCode.Operation() == cpu.JSR,
+		line.opCode.Operation() == cpu.JMP && line.opCode.AddressingMode() == cpu.Absolute:
+		return disassembler.operand(line), true
+	default:
+		return 0, false
+	}
+}
+
+// Only addresses starting a line can be labelled, targets in the middle of an instruction stay numeric
+func (disassembler *disassembler) findLabels() {
+	var lineStarts = make(map[uint16]bool)
+	for _, line := range disassembler.lines {
+		lineStarts[line.address] = true
+	}
+	if disassembler.hasVectors {
+		for _, vector := range []uint16{NMI_VECTOR, RESET_VECTOR, IRQ_VECTOR} {
+			var target = disassembler.vector(vector)
+			if _, isNamed := disassembler.labels[target]; lineStarts[target] && !isNamed {
+				disassembler.labels[target] = vectorNames[vector]
+			}
+		}
+	}
+	for _, line := range disassembler.lines {
+		var target, hasTarget = disassembler.target(line)
+		if _, isNamed := disassembler.labels[target]; hasTarget && lineStarts[target] && !isNamed {
+			disassembler.labels[target] = fmt.Sprintf("L_%04X", target)
+		}
+	}
+}
+
+func (disassembler *disassembler) formatBody() string {
+	var builder = strings.Builder{}
+	var data []uint8
+	var dataAddress uint16
+	var flushData = func(comment string) {
+		if len(data) == 0 {
+			return
+		}
+		var values = make([]string, len(data))
+		for i, value := range data {
+			values[i] = fmt.Sprintf("$%02X", value)
+		}
+		writeLine(&builder, ".byte "+strings.Join(values, ", "), fmt.Sprintf("$%04X%s", dataAddress, comment))
+		data = data[:0]
+	}
+
+	for _, line := range disassembler.lines {
+		if label, isLabelled := disassembler.labels[line.address]; isLabelled {
+			flushData("")
+			builder.WriteString(label + ":\n")
+		}
+		if !line.isData {
+			flushData("")
+			writeLine(&builder, disassembler.formatInstruction(line), fmt.Sprintf("$%04X", line.address))
+			continue
+		}
+		var offset = int(line.address - disassembler.options.Origin)
+		if line.unofficial != "" {
+			flushData("")
+			dataAddress = line.address
+			data = append(data, disassembler.code[offset:offset+line.size]...)
+			flushData(" " + line.unofficial)
+			continue
+		}
+		if len(data) == 0 {
+			dataAddress = line.address
+		}
+		data = append(data, disassembler.code[offset])
+		if len(data) == BYTES_PER_DATA_LINE {
+			flushData("")
+		}
+	}
+	flushData("")
+
+	if disassembler.hasVectors {
+		var names = make([]string, 0, 3)
+		for _, vector := range []uint16{NMI_VECTOR, RESET_VECTOR, IRQ_VECTOR} {
+			names = append(names, disassembler.formatAddress(disassembler.vector(vector)))
+		}
+		builder.WriteString("\n")
+		writeLine(&builder, ".word "+strings.Join(names, ", "), fmt.Sprintf("$%04X", NMI_VECTOR))
+	}
+	return builder.String()
+}
+
+func (disassembler *disassembler) formatInstruction(line line) string {
+	var mnemonic = strings.ToLower(string(line.opCode.Operation()))
+	var operand string
+	switch line.opCode.AddressingMode() {
+	case cpu.Implied:
+		return mnemonic
+	case cpu.Accumulator:
+		return mnemonic + " a"
+	case cpu.Immediate:
+		return fmt.Sprintf("%s #$%02X", mnemonic, disassembler.operand(line))
+	case cpu.Relative:
+		var target, _ = disassembler.target(line)
+		return fmt.Sprintf("%s %s", mnemonic, disassembler.formatAddress(target))
+	case cpu.ZeroPage, cpu.ZeroPageX, cpu.ZeroPageY, cpu.IndirectX, cpu.IndirectY:
+		operand = fmt.Sprintf("$%02X", disassembler.operand(line))
+	case cpu.Indirect:
+		operand = fmt.Sprintf("$%04X", disassembler.operand(line))
+	default:
+		operand = disassembler.formatAbsolute(line)
+	}
+
+	switch line.opCode.AddressingMode() {
+	case cpu.ZeroPageX, cpu.AbsoluteX:
+		return fmt.Sprintf("%s %s,x", mnemonic, operand)
+	case cpu.ZeroPageY, cpu.AbsoluteY:
+		return fmt.Sprintf("%s %s,y", mnemonic, operand)
+	case cpu.Indirect:
+		return fmt.Sprintf("%s (%s)", mnemonic, operand)
+	case cpu.IndirectX:
+		return fmt.Sprintf("%s (%s,x)", mnemonic, operand)
+	case cpu.IndirectY:
+		return fmt.Sprintf("%s (%s),y", mnemonic, operand)
+	default:
+		return fmt.Sprintf("%s %s", mnemonic, operand)
+	}
+}
+
+// Operand of the absolute addressing modes : jump targets, hardware registers or plain addresses
+func (disassembler *disassembler) formatAbsolute(line line) string {
+	var address = disassembler.operand(line)
+	if _, isJump := disassembler.target(line); isJump {
+		return disassembler.formatAddress(address)
+	}
+	if name, isRegister := hardwareRegisters[address]; isRegister {
+		disassembler.usedRegisters[address] = true
+		return name
+	}
+	// Without the prefix, ca65 would assemble addresses below $100 with zero page addressing
+	if address < 0x100 {
+		return fmt.Sprintf("a:$%04X", address)
+	}
+	return fmt.Sprintf("$%04X", address)
+}
+
+func (disassembler *disassembler) formatAddress(address uint16) string {
+	if label, isLabelled := disassembler.labels[address]; isLabelled {
+		return label
+	}
+	return fmt.Sprintf("$%04X", address)
+}
+
+func writeLine(builder *strings.Builder, statement string, comment string) {
+	builder.WriteString(fmt.Sprintf("    %-*s; %s\n", COMMENT_COLUMN-4, statement, comment))
+}
+
+func sortedAddresses(addresses map[uint16]bool) []uint16 {
+	var sorted = make([]uint16, 0, len(addresses))
+	for address := range addresses {
+		sorted = append(sorted, address)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"nes-emulator/bus"
+	"nes-emulator/disasm"
+	"os"
+	"strconv"
+)
+
+// Bank of the PRG ROM disassembled by default : the last one, which holds the vectors
+const LAST_PRG_BANK int = -1
+
+func runDisasmCommand(arguments []string) (err error) {
+	var flags = flag.NewFlagSet("disasm", flag.ExitOnError)
+	var outputPath = flags.String("o", "", "path of the ca65 source file to write, standard output if empty")
+	var bank = flags.Int("bank", LAST_PRG_BANK, "16 KiB bank of the PRG ROM to disassemble, the last one by default")
+	var isRaw = flags.Bool("raw", false, "disassemble the file as raw bytes instead of an iNES ROM")
+	var origin = flags.String("origin", "8000", "address of the first byte of a raw file (hex)")
+	var cdlPath = flags.String("cdl", "", "FCEUX .cdl file of the ROM : bytes it logged as data only are output as .byte")
+	flags.Parse(arguments)
+
+	var path = ROM_PATH
+	if flags.NArg() > 0 {
+		path = flags.Arg(0)
+	}
+	var raw, errorRead = os.ReadFile(path)
+	if errorRead != nil {
+		return errorRead
+	}
+
+	var output io.Writer = os.Stdout
+	if *outputPath != "" {
+		var file, errorCreate = os.Create(*outputPath)
+		if errorCreate != nil {
+			return errorCreate
+		}
+		var writer = bufio.NewWriter(file)
+		// A failed flush or close means the source file is incomplete
+		defer func() {
+			if errorFlush := writer.Flush(); err == nil {
+				err = errorFlush
+			}
+			if errorClose := file.Close(); err == nil {
+				err = errorClose
+			}
+		}()
+		output = writer
+	}
+
+	if *isRaw {
+		var address, errorOrigin = strconv.ParseUint(*origin, 16, 16)
+		if errorOrigin != nil {
+			return fmt.Errorf("invalid origin %q", *origin)
+		}
+		return disasm.Disassemble(output, raw, disasm.Options{Origin: uint16(address)})
+	}
+	var rom, errorParse = bus.ParseRawRom(raw)
+	if errorParse != nil {
+		return errorParse
+	}
+	if *bank == LAST_PRG_BANK {
+		*bank = disasm.NumberOfPrgBanks(rom) - 1
+	}
+	var isData func(address uint16) bool
+	if *cdlPath != "" {
+		var codeDataLog, errorLog = readCodeDataLog(*cdlPath, rom)
+		if errorLog != nil {
+			return errorLog
+		}
+		var _, bankOrigin, errorBank = disasm.PrgBank(rom, *bank)
+		if errorBank != nil {
+			return errorBank
+		}
+		var bankStart = *bank * bus.PRG_ROM_PAGE_SIZE
+		isData = func(address uint16) bool {
+			return codeDataLog.IsData(bankStart + int(address-bankOrigin))
+		}
+	}
+	return disasm.DisassemblePrgBank(output, rom, *bank, isData)
+}
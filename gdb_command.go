@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"nes-emulator/bus"
+	"nes-emulator/debugger"
+	"nes-emulator/nes_console"
+	"net"
+	"os"
+)
+
+const DEFAULT_GDB_PORT int = 6502
+
+func runGdbCommand(arguments []string) error {
+	var flags = flag.NewFlagSet("gdb", flag.ExitOnError)
+	var port = flags.Int("port", DEFAULT_GDB_PORT, "local TCP port to listen on")
+	flags.Parse(arguments)
+
+	fmt.Println(fmt.Sprintf("Reading rom  file at path %s...", ROM_PATH))
+	var rawRom, errorRead = os.ReadFile(ROM_PATH)
+	if errorRead != nil {
+		return errorRead
+	}
+	var rom, errorParse = bus.ParseRawRom(rawRom)
+	if errorParse != nil {
+		return errorParse
+	}
+
+	var console = nes_console.NewConsole()
+	console.LoadRom(rom)
+	console.PowerOn()
+	var romDebugger = debugger.NewDebugger(&console)
+	defer romDebugger.Close()
+
+	// Only local front-ends can attach, the protocol has no authentication
+	var listener, errorListen = net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", *port))
+	if errorListen != nil {
+		return errorListen
+	}
+	defer listener.Close()
+	fmt.Println(fmt.Sprintf("Waiting for GDB on %s (target remote %s)...", listener.Addr(), listener.Addr()))
+	return debugger.ServeGDB(listener, romDebugger)
+}
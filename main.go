@@ -2,27 +2,33 @@ package main
 
 import (
 	"fmt"
-	"nes-emulator/bus"
-	"nes-emulator/nes_console"
 	"os"
 )
 
-const ROM_PATH string = "resources/nestest.nes"
-
 func main() {
-	fmt.Println(fmt.Sprintf("Reading rom  file at path %s...", ROM_PATH))
-	var rawRom, errorRead = os.ReadFile(ROM_PATH)
-	if errorRead != nil {
-		panic(errorRead)
+	var err error
+	var command string
+	if len(os.Args) > 1 {
+		command = os.Args[1]
 	}
-
-	fmt.Println("Parsing rom...")
-	var rom, errorParse = bus.ParseRawRom(rawRom)
-	if errorParse != nil {
-		panic(errorParse)
+	switch command {
+	case "nsf":
+		err = runNsfCommand(os.Args[2:])
+	case "debug":
+		err = runDebugCommand(os.Args[2:])
+	case "gdb":
+		err = runGdbCommand(os.Args[2:])
+	case "disasm":
+		err = runDisasmCommand(os.Args[2:])
+	case "asm":
+		err = runAsmCommand(os.Args[2:])
+	case "singlestep":
+		err = runSingleStepCommand(os.Args[2:])
+	default:
+		err = runRomCommand(os.Args[1:])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-
-	fmt.Println("Running rom in nes emulator...")
-	var console = nes_console.NewConsole()
-	console.RunRom(rom)
 }
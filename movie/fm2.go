@@ -0,0 +1,197 @@
+package movie
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"nes-emulator/controller"
+	"strconv"
+	"strings"
+)
+
+// FCEUX movie format, in its text flavour
+// More info here : https://fceux.com/web/FM2.html
+
+const FM2_VERSION int = 3
+const FM2_EMULATOR_VERSION int = 22020
+const FM2_CHECKSUM_PREFIX string = "base64:"
+
+// Port types
+const FM2_PORT_NONE int = 0
+const FM2_PORT_GAMEPAD int = 1
+
+// Buttons of a gamepad, in the order of the input log
+const FM2_GAMEPAD_BUTTONS string = "RLDUTSBA"
+
+var fm2GamepadButtons = [8]controller.Buttons{
+	controller.BUTTON_RIGHT,
+	controller.BUTTON_LEFT,
+	controller.BUTTON_DOWN,
+	controller.BUTTON_UP,
+	controller.BUTTON_START,
+	controller.BUTTON_SELECT,
+	controller.BUTTON_B,
+	controller.BUTTON_A,
+}
+
+func ReadFM2(input io.Reader) (*Movie, error) {
+	var movie = &Movie{}
+	var scanner = bufio.NewScanner(input)
+	var ports = [3]int{FM2_PORT_GAMEPAD, FM2_PORT_GAMEPAD, FM2_PORT_NONE}
+	var lineNumber = 0
+	for scanner.Scan() {
+		lineNumber += 1
+		var line = strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "|") {
+			var frame, err = parseFM2Frame(line, ports)
+			if err != nil {
+				return nil, fmt.Errorf("line %d of FM2 movie : %w", lineNumber, err)
+			}
+			movie.Frames = append(movie.Frames, frame)
+			continue
+		}
+
+		var key, value, _ = strings.Cut(line, " ")
+		var err error
+		switch key {
+		case "version":
+			var version int
+			version, err = strconv.Atoi(value)
+			if err == nil && version != FM2_VERSION {
+				err = fmt.Errorf("version %d is not supported", version)
+			}
+		case "binary":
+			if value != "0" && value != "false" {
+				err = errors.New("binary FM2 movies are not supported")
+			}
+		case "romFilename":
+			movie.RomFilename = value
+		case "romChecksum":
+			var checksum []byte
+			checksum, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(value, FM2_CHECKSUM_PREFIX))
+			if err == nil && len(checksum) != len(movie.RomChecksum) {
+				err = errors.New("ROM checksum is not a MD5")
+			}
+			copy(movie.RomChecksum[:], checksum)
+		case "guid":
+			movie.Guid = value
+		case "rerecordCount":
+			movie.RerecordCount, err = strconv.Atoi(value)
+		case "palFlag":
+			movie.IsPal = value == "1"
+		case "fourscore":
+			if value == "1" {
+				err = errors.New("four score is not supported")
+			}
+		case "port0", "port1", "port2":
+			var port = int(key[4] - '0')
+			ports[port], err = strconv.Atoi(value)
+			if err == nil && ports[port] != FM2_PORT_NONE && (port == 2 || ports[port] != FM2_PORT_GAMEPAD) {
+				err = fmt.Errorf("device %d in %s is not supported", ports[port], key)
+			}
+		case "savestate":
+			err = errors.New("movies starting from a save state are not supported")
+		case "comment":
+			movie.Comments = append(movie.Comments, value)
+		case "subtitle":
+			movie.Subtitles = append(movie.Subtitles, value)
+		default:
+			// Other keys (emuVersion, microphone, FDS, NewPPU...) have no meaning for this emulator
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d of FM2 movie : %w", lineNumber, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return movie, nil
+}
+
+// Input log lines look like |c|RLDUTSBA|RLDUTSBA||
+func parseFM2Frame(line string, ports [3]int) (Frame, error) {
+	var fields = strings.Split(line, "|")
+	if len(fields) < 5 {
+		return Frame{}, errors.New("input log line is malformed")
+	}
+	var frame = Frame{}
+	var commands, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return Frame{}, fmt.Errorf("commands are malformed : %w", err)
+	}
+	frame.Commands = Commands(commands)
+	for port := 0; port < controller.NUMBER_OF_PORTS; port++ {
+		if ports[port] != FM2_PORT_GAMEPAD {
+			continue
+		}
+		var buttons = fields[2+port]
+		if len(buttons) != len(FM2_GAMEPAD_BUTTONS) {
+			return Frame{}, fmt.Errorf("buttons of port %d are malformed", port)
+		}
+		for i := 0; i < len(buttons); i++ {
+			if buttons[i] != '.' && buttons[i] != ' ' {
+				frame.Buttons[port] |= fm2GamepadButtons[i]
+			}
+		}
+	}
+	return frame, nil
+}
+
+func (movie *Movie) WriteFM2(output io.Writer) error {
+	var writer = bufio.NewWriter(output)
+	var palFlag = 0
+	if movie.IsPal {
+		palFlag = 1
+	}
+	fmt.Fprintf(writer, "version %d\n", FM2_VERSION)
+	fmt.Fprintf(writer, "emuVersion %d\n", FM2_EMULATOR_VERSION)
+	fmt.Fprintf(writer, "rerecordCount %d\n", movie.RerecordCount)
+	fmt.Fprintf(writer, "palFlag %d\n", palFlag)
+	fmt.Fprintf(writer, "romFilename %s\n", movie.RomFilename)
+	fmt.Fprintf(writer, "romChecksum %s%s\n", FM2_CHECKSUM_PREFIX, base64.StdEncoding.EncodeToString(movie.RomChecksum[:]))
+	fmt.Fprintf(writer, "guid %s\n", movie.Guid)
+	fmt.Fprintf(writer, "fourscore 0\n")
+	fmt.Fprintf(writer, "microphone 0\n")
+	fmt.Fprintf(writer, "port0 %d\n", FM2_PORT_GAMEPAD)
+	fmt.Fprintf(writer, "port1 %d\n", FM2_PORT_GAMEPAD)
+	fmt.Fprintf(writer, "port2 %d\n", FM2_PORT_NONE)
+	fmt.Fprintf(writer, "FDS 0\n")
+	fmt.Fprintf(writer, "NewPPU 0\n")
+	for _, comment := range movie.Comments {
+		fmt.Fprintf(writer, "comment %s\n", comment)
+	}
+	for _, subtitle := range movie.Subtitles {
+		fmt.Fprintf(writer, "subtitle %s\n", subtitle)
+	}
+	for _, frame := range movie.Frames {
+		fmt.Fprintf(writer, "|%d|%s|%s||\n", frame.Commands, formatFM2Buttons(frame.Buttons[controller.PORT_1]), formatFM2Buttons(frame.Buttons[controller.PORT_2]))
+	}
+	return writer.Flush()
+}
+
+func formatFM2Buttons(buttons controller.Buttons) string {
+	var builder = strings.Builder{}
+	for i, button := range fm2GamepadButtons {
+		if buttons.IsPressed(button) {
+			builder.WriteByte(FM2_GAMEPAD_BUTTONS[i])
+		} else {
+			builder.WriteByte('.')
+		}
+	}
+	return builder.String()
+}
+
+// FCEUX uses random GUIDs formatted as XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX
+func newGuid() string {
+	var bytes = make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "00000000-0000-0000-0000-000000000000"
+	}
+	return fmt.Sprintf("%X-%X-%X-%X-%X", bytes[0:4], bytes[4:6], bytes[6:8], bytes[8:10], bytes[10:16])
+}
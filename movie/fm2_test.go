@@ -0,0 +1,61 @@
+package movie
+
+import (
+	"bytes"
+	"nes-emulator/controller"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFM2RoundTrip(t *testing.T) {
+	var movie = NewMovie("game.nes", [16]byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xAB, 0xCD, 0xEF, 0xFE, 0xDC, 0xBA, 0x98, 0x76, 0x54, 0x32, 0x10})
+	movie.RerecordCount = 3
+	movie.IsPal = true
+	movie.Comments = []string{"author someone"}
+	movie.Subtitles = []string{"10 hello"}
+	movie.Frames = []Frame{
+		{Commands: COMMAND_POWER},
+		{Buttons: [controller.NUMBER_OF_PORTS]controller.Buttons{controller.BUTTON_A | controller.BUTTON_RIGHT, 0}},
+		{Commands: COMMAND_SOFT_RESET, Buttons: [controller.NUMBER_OF_PORTS]controller.Buttons{0, controller.BUTTON_START | controller.BUTTON_UP}},
+		{Buttons: [controller.NUMBER_OF_PORTS]controller.Buttons{0xFF, 0xFF}},
+	}
+	var file bytes.Buffer
+	if err := movie.WriteFM2(&file); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(file.String(), "\n|0|R......A|........||\n") {
+		t.Errorf("frame 1 is not in the FCEUX format :\n%s", file.String())
+	}
+	var read, err = ReadFM2(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, movie) {
+		t.Errorf("read movie %+v, want %+v", read, movie)
+	}
+}
+
+func TestReadFM2Errors(t *testing.T) {
+	var tests = []struct {
+		name  string
+		movie string
+	}{
+		{"unknown version", "version 2\n"},
+		{"binary movie", "version 3\nbinary 1\n"},
+		{"checksum of another size", "romChecksum base64:AAAA\n"},
+		{"four score", "fourscore 1\n"},
+		{"zapper", "port1 2\n"},
+		{"save state", "savestate base64:AAAA\n"},
+		{"missing buttons", "|0|R......A||\n"},
+		{"malformed commands", "|x|........|........||\n"},
+		{"buttons of the wrong length", "|0|RA|........||\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ReadFM2(strings.NewReader(test.movie)); err == nil {
+				t.Errorf("movie read without error")
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package movie
+
+import (
+	"errors"
+	"nes-emulator/controller"
+)
+
+// A movie is the log of everything fed to the console frame by frame, replaying it reproduces a run exactly
+// Movies always start from power-on
+
+// Events happening at the beginning of a frame, same values as the FM2 format
+type Commands uint8
+
+const (
+	COMMAND_SOFT_RESET Commands = 0b0000_0001
+	COMMAND_POWER      Commands = 0b0000_0010
+	COMMAND_FDS_INSERT Commands = 0b0000_0100
+	COMMAND_FDS_SELECT Commands = 0b0000_1000
+	COMMAND_VS_COIN    Commands = 0b0001_0000
+)
+
+func (commands Commands) Has(command Commands) bool {
+	return commands&command != 0
+}
+
+type Frame struct {
+	Commands Commands
+	Buttons  [controller.NUMBER_OF_PORTS]controller.Buttons
+}
+
+type Movie struct {
+	RomFilename string
+	// MD5 of the ROM the movie was recorded with
+	RomChecksum   [16]byte
+	Guid          string
+	RerecordCount int
+	IsPal         bool
+	Comments      []string
+	Subtitles     []string
+	Frames        []Frame
+}
+
+func NewMovie(romFilename string, romChecksum [16]byte) *Movie {
+	return &Movie{
+		RomFilename: romFilename,
+		RomChecksum: romChecksum,
+		Guid:        newGuid(),
+	}
+}
+
+func (movie *Movie) CheckRom(romChecksum [16]byte) error {
+	if movie.RomChecksum != romChecksum {
+		return errors.New("movie was recorded with another ROM (checksum mismatch)")
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package nes_console
+
+import (
+	"errors"
+	"nes-emulator/cdl"
+)
+
+// Flags the PRG ROM bytes executed or read by the game in the log, until StopCodeDataLogger
+// The log can come from a previous session, see cdl.Read
+func (console *NesConsole) StartCodeDataLogger(log *cdl.Log) error {
+	if console.rom == nil {
+		return errors.New("a ROM must be loaded to log code and data")
+	}
+	if len(log.Prg()) != len(console.rom.PrgRom()) || len(log.Chr()) != len(console.rom.ChrRom()) {
+		return errors.New("code/data log does not match the sizes of the ROM")
+	}
+	console.StopCodeDataLogger()
+	console.codeDataLogger = cdl.NewLogger(log, console.rom)
+	console.codeDataLogger.Attach(console.bus)
+	console.updateInstructionHandler()
+	return nil
+}
+
+func (console *NesConsole) StopCodeDataLogger() {
+	if console.codeDataLogger != nil {
+		console.codeDataLogger.Detach()
+		console.codeDataLogger = nil
+		console.updateInstructionHandler()
+	}
+}
+
+func (console *NesConsole) IsLoggingCodeData() bool {
+	return console.codeDataLogger != nil
+}
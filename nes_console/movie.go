@@ -0,0 +1,129 @@
+package nes_console
+
+import (
+	"errors"
+	"nes-emulator/controller"
+	"nes-emulator/movie"
+	"nes-emulator/region"
+)
+
+type movieMode int
+
+const (
+	MOVIE_INACTIVE movieMode = iota
+	MOVIE_RECORDING
+	MOVIE_PLAYING
+)
+
+type movieState struct {
+	mode  movieMode
+	movie *movie.Movie
+	// Index of the next frame to play
+	frameIndex int
+	// Commands issued since the last recorded frame
+	pendingCommands movie.Commands
+}
+
+func (state *movieState) recordCommand(command movie.Commands) {
+	if state.mode == MOVIE_RECORDING {
+		state.pendingCommands |= command
+	}
+}
+
+// Powers the console on and records every following frame in the movie
+func (console *NesConsole) StartMovieRecording(recordedMovie *movie.Movie) error {
+	if console.rom == nil {
+		return errors.New("a ROM must be loaded to record a movie")
+	}
+	console.StopMovie()
+	console.PowerOn()
+	recordedMovie.Frames = nil
+	recordedMovie.IsPal = console.region == region.PAL
+	console.movie = movieState{
+		mode:  MOVIE_RECORDING,
+		movie: recordedMovie,
+	}
+	return nil
+}
+
+// Powers the console on and replays the movie, input given while playing is overridden by the movie
+func (console *NesConsole) StartMoviePlayback(playedMovie *movie.Movie) error {
+	if console.rom == nil {
+		return errors.New("a ROM must be loaded to play a movie")
+	}
+	if err := playedMovie.CheckRom(console.rom.Checksum()); err != nil {
+		return err
+	}
+	console.StopMovie()
+	if playedMovie.IsPal {
+		console.SetRegion(region.PAL)
+	} else if console.region == region.PAL {
+		console.SetRegion(region.NTSC)
+	}
+	console.PowerOn()
+	console.movie = movieState{
+		mode:  MOVIE_PLAYING,
+		movie: playedMovie,
+	}
+	return nil
+}
+
+func (console *NesConsole) StopMovie() {
+	console.movie = movieState{}
+}
+
+func (console *NesConsole) IsRecordingMovie() bool {
+	return console.movie.mode == MOVIE_RECORDING
+}
+
+func (console *NesConsole) IsPlayingMovie() bool {
+	return console.movie.mode == MOVIE_PLAYING
+}
+
+// Applies or records the input of the frame about to be run
+func (console *NesConsole) beginMovieFrame() {
+	switch console.movie.mode {
+	case MOVIE_RECORDING:
+		var frame = movie.Frame{Commands: console.movie.pendingCommands}
+		for port, joypad := range console.joypads {
+			frame.Buttons[port] = joypad.Buttons()
+		}
+		console.movie.movie.Frames = append(console.movie.movie.Frames, frame)
+		console.movie.pendingCommands = 0
+	case MOVIE_PLAYING:
+		if console.movie.frameIndex >= len(console.movie.movie.Frames) {
+			console.StopMovie()
+			return
+		}
+		var frame = console.movie.movie.Frames[console.movie.frameIndex]
+		console.movie.frameIndex += 1
+		if frame.Commands.Has(movie.COMMAND_POWER) {
+			console.PowerOn()
+		}
+		if frame.Commands.Has(movie.COMMAND_SOFT_RESET) {
+			console.Reset()
+		}
+		for port := 0; port < controller.NUMBER_OF_PORTS; port++ {
+			console.SetInput(port, frame.Buttons[port])
+		}
+	}
+}
+
+// Moves the movie to the frame of a state that has just been loaded
+func (console *NesConsole) restoreMovieFrame() {
+	// Input of the running frame has already been applied or recorded
+	var framesBegun = int(console.frameCount)
+	if console.isFrameRunning {
+		framesBegun += 1
+	}
+	switch console.movie.mode {
+	case MOVIE_RECORDING:
+		if framesBegun < len(console.movie.movie.Frames) {
+			console.movie.movie.Frames = console.movie.movie.Frames[:framesBegun]
+		}
+		console.movie.movie.RerecordCount += 1
+		console.movie.pendingCommands = 0
+	case MOVIE_PLAYING:
+		console.movie.frameIndex = framesBegun
+	}
+}
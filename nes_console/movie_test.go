@@ -0,0 +1,121 @@
+package nes_console
+
+import (
+	"bytes"
+	"nes-emulator/bus"
+	"nes-emulator/controller"
+	"nes-emulator/movie"
+	"testing"
+)
+
+// NROM image running from $C000 a loop that adds the A button of port 1 to $00, so that the input changes the RAM
+func newControllerRom(t *testing.T) *bus.Rom {
+	var image = make([]uint8, 16+bus.PRG_ROM_PAGE_SIZE)
+	copy(image, []uint8{'N', 'E', 'S', 0x1A, 1, 0})
+	var loop = []uint8{
+		0xA9, 0x01, // LDA #$01
+		0x8D, 0x16, 0x40, // STA $4016
+		0xA9, 0x00, // LDA #$00
+		0x8D, 0x16, 0x40, // STA $4016
+		0xAD, 0x16, 0x40, // LDA $4016
+		0x29, 0x01, // AND #$01
+		0x18,       // CLC
+		0x65, 0x00, // ADC $00
+		0x85, 0x00, // STA $00
+		0xE6, 0x01, // INC $01
+		0x4C, 0x00, 0xC0, // JMP $C000
+	}
+	copy(image[16:], loop)
+	var rom, err = bus.ParseRawRom(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rom
+}
+
+// Commands issued before each frame of the recording
+var recordedCommands = []movie.Commands{0, 0, movie.COMMAND_SOFT_RESET, 0, movie.COMMAND_POWER, 0, 0}
+
+// Cycles at the end of each frame and state of the machine once it is run
+type frameResult struct {
+	cycles uint64
+	state  []byte
+}
+
+func runMovieFrame(t *testing.T, console *NesConsole) frameResult {
+	if _, err := console.StepFrame(); err != nil {
+		t.Fatal(err)
+	}
+	var state bytes.Buffer
+	if err := console.SaveState(&state); err != nil {
+		t.Fatal(err)
+	}
+	return frameResult{cycles: console.cpu.Cycles(), state: state.Bytes()}
+}
+
+func TestMovieRecordAndReplay(t *testing.T) {
+	var rom = newControllerRom(t)
+	var console = NewConsole()
+	console.LoadRom(rom)
+	var recordedMovie = movie.NewMovie("controller.nes", rom.Checksum())
+	if err := console.StartMovieRecording(recordedMovie); err != nil {
+		t.Fatal(err)
+	}
+	var recorded []frameResult
+	for frame, command := range recordedCommands {
+		switch command {
+		case movie.COMMAND_SOFT_RESET:
+			console.Reset()
+		case movie.COMMAND_POWER:
+			console.PowerOn()
+		}
+		if frame%2 == 1 {
+			console.SetInput(controller.PORT_1, controller.BUTTON_A)
+		} else {
+			console.SetInput(controller.PORT_1, 0)
+		}
+		recorded = append(recorded, runMovieFrame(t, &console))
+	}
+	console.StopMovie()
+	if len(recordedMovie.Frames) != len(recordedCommands) {
+		t.Fatalf("recorded %d frames, want %d", len(recordedMovie.Frames), len(recordedCommands))
+	}
+	for frame, command := range recordedCommands {
+		if recordedMovie.Frames[frame].Commands != command {
+			t.Errorf("commands %d recorded at frame %d, want %d", recordedMovie.Frames[frame].Commands, frame, command)
+		}
+	}
+
+	if err := console.StartMoviePlayback(recordedMovie); err != nil {
+		t.Fatal(err)
+	}
+	var stateAfterPower []byte
+	for frame := range recordedCommands {
+		// Input given while playing is overridden by the movie
+		console.SetInput(controller.PORT_1, controller.BUTTON_A)
+		var played = runMovieFrame(t, &console)
+		if played.cycles != recorded[frame].cycles {
+			t.Errorf("frame %d ended at cycle %d, recorded at cycle %d", frame, played.cycles, recorded[frame].cycles)
+		}
+		if !bytes.Equal(played.state, recorded[frame].state) {
+			t.Errorf("state after frame %d differs from the recorded one", frame)
+		}
+		if frame == 4 {
+			stateAfterPower = played.state
+		}
+	}
+	if console.FrameCount() != uint64(len(recordedCommands)) {
+		t.Errorf("played %d frames, want %d", console.FrameCount(), len(recordedCommands))
+	}
+
+	// The power command is counted in the frames of the movie, which go on from the loaded state
+	if err := console.LoadState(bytes.NewReader(stateAfterPower)); err != nil {
+		t.Fatal(err)
+	}
+	if !console.IsPlayingMovie() || console.movie.frameIndex != 5 {
+		t.Errorf("movie moved to frame %d after loading the state of frame 5", console.movie.frameIndex)
+	}
+	if played := runMovieFrame(t, &console); !bytes.Equal(played.state, recorded[5].state) {
+		t.Errorf("state after frame 5 differs from the recorded one once the state is loaded")
+	}
+}
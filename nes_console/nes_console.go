@@ -1,26 +1,306 @@
 package nes_console
 
 import (
+	"errors"
+	"nes-emulator/apu"
 	"nes-emulator/bus"
+	"nes-emulator/cdl"
+	"nes-emulator/controller"
 	"nes-emulator/cpu"
+	"nes-emulator/movie"
+	"nes-emulator/profiler"
+	"nes-emulator/region"
+	"time"
 )
 
+// Number of buffered audio samples before they are flushed to the audio output
+const AUDIO_FLUSH_THRESHOLD int = 4096
+
+// Size of the picture output by the PPU
+const FRAME_WIDTH int = 256
+const FRAME_HEIGHT int = 240
+
+var ErrProgramStopped = errors.New("program stopped (BRK executed)")
+
+// Returned while the CPU is halted by KIL, until Reset or PowerOn
+var ErrCpuHalted = errors.New("CPU halted (KIL executed)")
+
+// Palette indices ($00-$3F) of the pixels of a frame, row by row
+// The PPU is not emulated yet, so frames are blank
+type Framebuffer [FRAME_HEIGHT][FRAME_WIDTH]uint8
+
+type FrameOutput struct {
+	// Owned by the console, only valid until the next frame is run
+	Framebuffer *Framebuffer
+	// Samples produced during the frame, at the rate set with SetSampleRate or SetAudioOutput
+	AudioSamples []float32
+}
+
+type AudioOutput interface {
+	WriteSamples(samples []float32) error
+}
+
 type NesConsole struct {
-	bus *bus.Bus
-	cpu *cpu.CPU
+	bus         *bus.Bus
+	cpu         *cpu.CPU
+	apu         *apu.APU
+	audioOutput AudioOutput
+	rom         *bus.Rom
+	nsf         *bus.Nsf
+	joypads     [controller.NUMBER_OF_PORTS]*controller.Joypad
+	framebuffer Framebuffer
+	// Frames run since power-on, or since the movie began when a movie is recorded or played,
+	// and CPU cycle at which the current frame ends
+	frameCount     uint64
+	isFrameRunning bool
+	nextFrameCycle float64
+	movie          movieState
+	rewind         *rewindBuffer
+	codeDataLogger *cdl.Logger
+	profiler       *profiler.Profiler
+	// Set by debuggers, see SetInstructionHandler
+	instructionHandler func(instruction cpu.ExecutedInstruction)
+	powerOnConfig      PowerOnConfig
+	// Until the PPU is emulated, a frame is the number of CPU cycles the PPU of the region takes to render one
+	region region.Region
+	// Seed of the RAM filled by the last power-on
+	powerOnSeed int64
+}
+
+type PowerOnConfig struct {
+	RamFill bus.RamFill
+	// Seed of bus.RAM_FILL_RANDOM, 0 draws a new seed at each power-on
+	Seed int64
 }
 
 func NewConsole() NesConsole {
-	var consoleBus = bus.NewBus()
-	var consoleCPU = cpu.NewCPU(&consoleBus)
-	return NesConsole{
+	var consoleAPU = apu.NewAPU()
+	var consoleBus = bus.NewBus(&consoleAPU)
+	// Programs are run until BRK, which is how nestest ends
+	var consoleCPU = cpu.NewCPU(&consoleBus, cpu.WithStopOnBrk(true))
+	// The trace of every instruction is only wanted by the command line, see SetTraceEnabled
+	consoleCPU.SetTraceEnabled(false)
+	var console = NesConsole{
 		bus: &consoleBus,
 		cpu: &consoleCPU,
+		apu: &consoleAPU,
+	}
+	// A standard controller is plugged in each port
+	for port := range console.joypads {
+		var joypad = controller.NewJoypad()
+		console.joypads[port] = &joypad
+		consoleBus.ConnectController(port, &joypad)
 	}
+	return console
+}
+
+// Sets the buttons held on the controller plugged in port (controller.PORT_1 or controller.PORT_2)
+// Frontends call it once per frame, before running the frame
+func (console *NesConsole) SetInput(port int, buttons controller.Buttons) {
+	console.joypads[port].SetButtons(buttons)
+}
+
+// Audio samples are produced at the given rate (Hz), 0 disables audio
+func (console *NesConsole) SetSampleRate(sampleRate int) {
+	console.apu.SetSampleRate(sampleRate)
+}
+
+// Audio samples are produced at the given rate and written to the output while running
+func (console *NesConsole) SetAudioOutput(output AudioOutput, sampleRate int) {
+	console.audioOutput = output
+	console.SetSampleRate(sampleRate)
+}
+
+// Returns the audio samples produced since the last call, for frontends driving the console instruction by instruction
+func (console *NesConsole) TakeAudioSamples() []float32 {
+	return console.apu.TakeSamples()
 }
 
-func (console *NesConsole) RunRom(rom *bus.Rom) {
+func (console *NesConsole) SetPowerOnConfig(config PowerOnConfig) {
+	console.powerOnConfig = config
+}
+
+// Seed of the random RAM content of the last power-on : set it in the config to reproduce the run
+func (console *NesConsole) PowerOnSeed() int64 {
+	return console.powerOnSeed
+}
+
+// The console takes the region of the ROM, call SetRegion afterwards to override it
+func (console *NesConsole) LoadRom(rom *bus.Rom) {
+	// The log and the profile belong to the previous ROM
+	console.StopCodeDataLogger()
+	console.StopProfiler()
+	console.rom = rom
 	console.bus.LoadRom(rom)
+	console.SetRegion(rom.Region())
+}
+
+func (console *NesConsole) SetRegion(consoleRegion region.Region) {
+	console.region = consoleRegion
+	console.apu.SetRegion(consoleRegion)
+}
+
+func (console *NesConsole) Region() region.Region {
+	return console.region
+}
+
+// Prints the state of the CPU before each instruction on stdout, in the format of the nestest log
+func (console *NesConsole) SetTraceEnabled(isTraceEnabled bool) {
+	console.cpu.SetTraceEnabled(isTraceEnabled)
+}
+
+// Direct access to the CPU and the bus for debugging tools
+func (console *NesConsole) CPU() *cpu.CPU {
+	return console.cpu
+}
+
+func (console *NesConsole) Bus() *bus.Bus {
+	return console.bus
+}
+
+// Cold boot of the console : RAM is filled as configured and every device goes back to its power-up state
+func (console *NesConsole) PowerOn() {
+	console.movie.recordCommand(movie.COMMAND_POWER)
+	console.powerOnSeed = console.powerOnConfig.Seed
+	if console.powerOnSeed == 0 && console.powerOnConfig.RamFill == bus.RAM_FILL_RANDOM {
+		console.powerOnSeed = time.Now().UnixNano()
+	}
+	console.bus.PowerOn(console.powerOnConfig.RamFill, console.powerOnSeed)
+	console.apu.PowerOn()
+	console.cpu.PowerOn()
+	// Frames of a movie keep being counted across its power cycles, they index the movie when a state is loaded
+	if console.movie.mode == MOVIE_INACTIVE {
+		console.frameCount = 0
+	}
+	console.isFrameRunning = false
+	console.nextFrameCycle = float64(console.cpu.Cycles())
+}
+
+// Reset button of the console : RAM is kept
+func (console *NesConsole) Reset() {
+	console.movie.recordCommand(movie.COMMAND_SOFT_RESET)
+	console.apu.Reset()
 	console.cpu.Reset()
-	console.cpu.Run()
+	console.isFrameRunning = false
+	console.nextFrameCycle = float64(console.cpu.Cycles())
+}
+
+func (console *NesConsole) RunRom(rom *bus.Rom) error {
+	console.LoadRom(rom)
+	console.PowerOn()
+	for console.cpu.Step() {
+		if console.cpu.IsHalted() {
+			return ErrCpuHalted
+		}
+		if err := console.flushAudioIfNeeded(); err != nil {
+			return err
+		}
+	}
+	return console.flushAudio()
+}
+
+// Executes a single CPU instruction
+func (console *NesConsole) StepInstruction() error {
+	if !console.isFrameRunning {
+		console.beginFrame()
+	}
+	if !console.cpu.Step() {
+		return ErrProgramStopped
+	}
+	if float64(console.cpu.Cycles()) >= console.nextFrameCycle {
+		console.endFrame()
+	}
+	if console.cpu.IsHalted() {
+		return ErrCpuHalted
+	}
+	return nil
+}
+
+// Runs the console until the end of the current frame
+// Audio samples of the frame are also written to the audio output, if any
+// When the program stops or the CPU halts, the output of the partial frame is returned with the error
+func (console *NesConsole) StepFrame() (FrameOutput, error) {
+	var frameCount = console.frameCount
+	var errorStep error
+	for console.frameCount == frameCount && errorStep == nil {
+		errorStep = console.StepInstruction()
+	}
+	var samples = console.apu.TakeSamples()
+	if console.audioOutput != nil {
+		if err := console.audioOutput.WriteSamples(samples); err != nil {
+			return FrameOutput{}, err
+		}
+	}
+	return FrameOutput{
+		Framebuffer:  &console.framebuffer,
+		AudioSamples: samples,
+	}, errorStep
+}
+
+func (console *NesConsole) beginFrame() {
+	// Resets and power cycles played from a movie restart the frame timing, which is set up after them
+	console.beginMovieFrame()
+	console.isFrameRunning = true
+	console.nextFrameCycle += console.region.Timing().CpuCyclesPerFrame
+}
+
+func (console *NesConsole) endFrame() {
+	console.isFrameRunning = false
+	console.frameCount += 1
+	// Frames of the profile should be split at the NMI, which does not exist without a PPU :
+	// the end of the frame, timed in CPU cycles, stands for it
+	if console.profiler != nil {
+		console.profiler.EndFrame()
+	}
+	console.captureRewindSnapshotIfNeeded()
+}
+
+// The handler is called after each instruction executed by the CPU, nil removes it
+// The code/data logger and the profiler keep receiving the instructions as well
+func (console *NesConsole) SetInstructionHandler(handler func(instruction cpu.ExecutedInstruction)) {
+	console.instructionHandler = handler
+	console.updateInstructionHandler()
+}
+
+// The CPU only reports its instructions while the code/data logger, the profiler or a handler needs them
+func (console *NesConsole) updateInstructionHandler() {
+	if console.codeDataLogger == nil && console.profiler == nil && console.instructionHandler == nil {
+		console.cpu.SetInstructionHandler(nil)
+		return
+	}
+	console.cpu.SetInstructionHandler(console.handleInstruction)
+}
+
+func (console *NesConsole) handleInstruction(instruction cpu.ExecutedInstruction) {
+	if console.codeDataLogger != nil {
+		console.codeDataLogger.LogInstruction(instruction)
+	}
+	if console.profiler != nil {
+		console.profiler.ProfileInstruction(instruction, console.cpu.Registers())
+	}
+	if console.instructionHandler != nil {
+		console.instructionHandler(instruction)
+	}
+}
+
+func (console *NesConsole) IsCpuHalted() bool {
+	return console.cpu.IsHalted()
+}
+
+func (console *NesConsole) FrameCount() uint64 {
+	return console.frameCount
+}
+
+func (console *NesConsole) flushAudioIfNeeded() error {
+	if console.audioOutput == nil || console.apu.BufferedSamples() < AUDIO_FLUSH_THRESHOLD {
+		return nil
+	}
+	return console.flushAudio()
+}
+
+func (console *NesConsole) flushAudio() error {
+	if console.audioOutput == nil {
+		return nil
+	}
+	return console.audioOutput.WriteSamples(console.apu.TakeSamples())
 }
@@ -0,0 +1,47 @@
+package nes_console
+
+import (
+	"errors"
+	"nes-emulator/bus"
+	"testing"
+)
+
+type sampleCollector struct {
+	samples []float32
+}
+
+func (collector *sampleCollector) WriteSamples(samples []float32) error {
+	collector.samples = append(collector.samples, samples...)
+	return nil
+}
+
+func TestStepFrameHaltedOutputsPartialFrame(t *testing.T) {
+	// NOPs for about half a frame, then KIL
+	var image = make([]uint8, 16+bus.PRG_ROM_PAGE_SIZE)
+	copy(image, []uint8{'N', 'E', 'S', 0x1A, 1, 0})
+	for i := 0; i < 8000; i++ {
+		image[16+i] = 0xEA
+	}
+	image[16+8000] = 0x02
+	var rom, err = bus.ParseRawRom(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var console = NewConsole()
+	var collector = &sampleCollector{}
+	console.SetAudioOutput(collector, 44100)
+	console.LoadRom(rom)
+	console.PowerOn()
+
+	var output, errorFrame = console.StepFrame()
+	if !errors.Is(errorFrame, ErrCpuHalted) {
+		t.Fatalf("StepFrame() returned %v, want ErrCpuHalted", errorFrame)
+	}
+	// 16000 cycles at 44.1 kHz are about 395 samples
+	if len(output.AudioSamples) < 390 || len(collector.samples) != len(output.AudioSamples) {
+		t.Errorf("%d samples returned and %d written for the partial frame", len(output.AudioSamples), len(collector.samples))
+	}
+	if console.FrameCount() != 0 {
+		t.Errorf("partial frame counted as frame %d", console.FrameCount())
+	}
+}
@@ -0,0 +1,113 @@
+package nes_console
+
+import (
+	"errors"
+	"fmt"
+	"nes-emulator/apu"
+	"nes-emulator/bus"
+	"nes-emulator/region"
+	"time"
+)
+
+// More info on the way tunes are played here : https://www.nesdev.org/wiki/NSF#Initializing_a_tune
+
+// Time given to the INIT routine before considering it never returns
+const NSF_INIT_TIMEOUT time.Duration = time.Second
+
+// Value of X given to INIT
+const NSF_REGION_NTSC uint8 = 0
+const NSF_REGION_PAL uint8 = 1
+
+// Tunes made for both regions are played with NTSC timings
+func (console *NesConsole) LoadNsf(nsf *bus.Nsf) {
+	console.nsf = nsf
+	console.bus.LoadCartridge(nsf)
+	if nsf.IsPal && !nsf.IsDualRegion {
+		console.SetRegion(region.PAL)
+	} else {
+		console.SetRegion(region.NTSC)
+	}
+	// Tracing millions of instructions of a music driver is useless
+	console.cpu.SetTraceEnabled(false)
+}
+
+// Plays song (numbered from 0) during duration, sound is written to the audio output
+func (console *NesConsole) PlayNsfSong(song int, duration time.Duration) error {
+	if console.nsf == nil {
+		return errors.New("no NSF file loaded")
+	}
+	if song < 0 || song >= console.nsf.NumberOfSongs {
+		return fmt.Errorf("song %d does not exist, NSF file contains %d songs", song+1, console.nsf.NumberOfSongs)
+	}
+	if err := console.initNsfSong(song); err != nil {
+		return err
+	}
+
+	var cpuFrequency = console.region.Timing().CpuFrequency
+	var playSpeed = console.nsf.NtscPlaySpeed
+	if console.region == region.PAL {
+		playSpeed = console.nsf.PalPlaySpeed
+	}
+	var cyclesPerPlay = float64(playSpeed) * cpuFrequency / 1_000_000
+	var nextPlayCycle = float64(console.cpu.Cycles())
+	var endCycle = console.cpu.Cycles() + uint64(duration.Seconds()*cpuFrequency)
+	for console.cpu.Cycles() < endCycle {
+		// PLAY is only called once the previous call has returned to the driver
+		if console.cpu.ProgramCounter() == bus.NSF_DRIVER_ADDRESS && float64(console.cpu.Cycles()) >= nextPlayCycle {
+			console.cpu.CallSubroutine(console.nsf.PlayAddress, bus.NSF_DRIVER_ADDRESS, 0, 0)
+			nextPlayCycle += cyclesPerPlay
+		}
+		if !console.cpu.Step() {
+			return errors.New("BRK executed while playing NSF song")
+		}
+		if console.cpu.IsHalted() {
+			return errors.New("KIL executed while playing NSF song")
+		}
+		if err := console.flushAudioIfNeeded(); err != nil {
+			return err
+		}
+	}
+	return console.flushAudio()
+}
+
+func (console *NesConsole) initNsfSong(song int) error {
+	console.nsf.Reset()
+	console.cpu.PowerOn()
+	for address := bus.CPU_RAM_START; address < 0x0800; address++ {
+		console.bus.MemoryWrite(address, 0x00)
+	}
+	for address := bus.NSF_WRAM_START; address <= bus.NSF_WRAM_END; address++ {
+		console.bus.MemoryWrite(address, 0x00)
+	}
+	for address := apu.PULSE_1_CONTROL; address <= apu.DMC_SAMPLE_LENGTH; address++ {
+		console.bus.MemoryWrite(address, 0x00)
+	}
+	console.bus.MemoryWrite(apu.STATUS, 0x00)
+	console.bus.MemoryWrite(apu.STATUS, 0x0F)
+	// 4-step mode, frame IRQ inhibited
+	console.bus.MemoryWrite(apu.FRAME_COUNTER, 0x40)
+	if console.nsf.IsBankswitched() {
+		for i, bank := range console.nsf.BankswitchInit() {
+			console.bus.MemoryWrite(bus.NSF_BANKSWITCH_START+uint16(i), bank)
+		}
+	}
+
+	var nsfRegion = NSF_REGION_NTSC
+	if console.region == region.PAL {
+		nsfRegion = NSF_REGION_PAL
+	}
+	console.cpu.CallSubroutine(console.nsf.InitAddress, bus.NSF_DRIVER_ADDRESS, uint8(song), nsfRegion)
+	var endCycle = console.cpu.Cycles() + uint64(NSF_INIT_TIMEOUT.Seconds()*console.region.Timing().CpuFrequency)
+	for console.cpu.ProgramCounter() != bus.NSF_DRIVER_ADDRESS {
+		if console.cpu.Cycles() >= endCycle {
+			return errors.New("INIT routine of the NSF file did not return")
+		}
+		if !console.cpu.Step() {
+			return errors.New("BRK executed while initializing NSF song")
+		}
+		if console.cpu.IsHalted() {
+			return errors.New("KIL executed while initializing NSF song")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package nes_console
+
+import (
+	"nes-emulator/profiler"
+)
+
+// Accounts the cycles of the instructions executed from now on, until StopProfiler
+// Frames end when the console ends them, which is when the PPU raises its NMI
+func (console *NesConsole) StartProfiler() *profiler.Profiler {
+	console.profiler = profiler.NewProfiler(console.cpu)
+	console.updateInstructionHandler()
+	return console.profiler
+}
+
+func (console *NesConsole) StopProfiler() {
+	console.profiler = nil
+	console.updateInstructionHandler()
+}
+
+func (console *NesConsole) IsProfiling() bool {
+	return console.profiler != nil
+}
@@ -0,0 +1,237 @@
+package nes_console
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+const DEFAULT_REWIND_INTERVAL int = 5
+const DEFAULT_REWIND_MAX_MEMORY int = 16 * 1024 * 1024
+
+var errRewindDisabled = errors.New("rewind is not enabled")
+
+type RewindConfig struct {
+	// Frames run between two snapshots, rewinding lands on the closest snapshot
+	Interval int
+	// Bytes used by the snapshots, the oldest ones are dropped beyond it
+	MaxMemory int
+}
+
+func DefaultRewindConfig() RewindConfig {
+	return RewindConfig{
+		Interval:  DEFAULT_REWIND_INTERVAL,
+		MaxMemory: DEFAULT_REWIND_MAX_MEMORY,
+	}
+}
+
+// Snapshot older than the one following it in the ring
+type rewindEntry struct {
+	frame uint64
+	// Compressed XOR between this snapshot and the following one
+	delta []byte
+}
+
+// The newest snapshot is kept raw, older ones are stored as deltas from the snapshot following them
+// so that dropping the oldest entry never breaks the chain
+type rewindBuffer struct {
+	config  RewindConfig
+	current []byte
+	frame   uint64
+	// Ring of entries, from the oldest at start
+	entries     []rewindEntry
+	start       int
+	count       int
+	deltasBytes int
+	compressor  *flate.Writer
+}
+
+// Captures a snapshot of the machine every config.Interval frames
+func (console *NesConsole) EnableRewind(config RewindConfig) error {
+	if console.rom == nil {
+		return errNoRomLoaded
+	}
+	if config.Interval <= 0 || config.MaxMemory <= 0 {
+		return errors.New("rewind interval and memory must be positive")
+	}
+	var compressor, err = flate.NewWriter(io.Discard, flate.BestSpeed)
+	if err != nil {
+		return err
+	}
+	console.rewind = &rewindBuffer{config: config, compressor: compressor}
+	return nil
+}
+
+func (console *NesConsole) DisableRewind() {
+	console.rewind = nil
+}
+
+// Bytes held by the snapshots
+func (console *NesConsole) RewindMemoryUsage() int {
+	if console.rewind == nil {
+		return 0
+	}
+	return console.rewind.memoryUsage()
+}
+
+// Goes back to the latest snapshot at least the given number of frames ago, or to the oldest one
+// Returns the number of frames actually rewound, rewinding 0 frames leaves the machine untouched
+func (console *NesConsole) RewindFrames(frames int) (int, error) {
+	if console.rewind == nil {
+		return 0, errRewindDisabled
+	}
+	if frames < 0 {
+		return 0, errors.New("number of frames to rewind must not be negative")
+	}
+	if frames == 0 {
+		return 0, nil
+	}
+	if console.rewind.current == nil {
+		return 0, errors.New("no snapshot to rewind to")
+	}
+	var frameCount = console.frameCount
+	var targetFrame uint64
+	if uint64(frames) < frameCount {
+		targetFrame = frameCount - uint64(frames)
+	}
+	if err := console.rewind.rewindTo(targetFrame); err != nil {
+		return 0, err
+	}
+	if err := console.LoadState(bytes.NewReader(console.rewind.current)); err != nil {
+		return 0, err
+	}
+	return int(frameCount - console.frameCount), nil
+}
+
+func (console *NesConsole) RewindDuration(duration time.Duration) (int, error) {
+	return console.RewindFrames(int(math.Round(duration.Seconds() * console.region.FramesPerSecond())))
+}
+
+func (console *NesConsole) captureRewindSnapshotIfNeeded() {
+	if console.rewind == nil || console.frameCount%uint64(console.rewind.config.Interval) != 0 {
+		return
+	}
+	var snapshot bytes.Buffer
+	if err := console.SaveState(&snapshot); err != nil {
+		return
+	}
+	console.rewind.push(snapshot.Bytes(), console.frameCount)
+}
+
+func (buffer *rewindBuffer) memoryUsage() int {
+	return len(buffer.current) + buffer.deltasBytes
+}
+
+func (buffer *rewindBuffer) push(snapshot []byte, frame uint64) {
+	if buffer.current != nil && frame <= buffer.frame {
+		// The machine went back in time (power-on, state loaded), older snapshots are now in its future
+		buffer.clear()
+	}
+	if buffer.current != nil {
+		if len(buffer.current) != len(snapshot) {
+			buffer.clear()
+		} else {
+			var delta, err = buffer.compress(xorBytes(buffer.current, snapshot))
+			if err != nil {
+				buffer.clear()
+			} else {
+				buffer.pushEntry(rewindEntry{frame: buffer.frame, delta: delta})
+			}
+		}
+	}
+	buffer.current = snapshot
+	buffer.frame = frame
+	for buffer.count > 0 && buffer.memoryUsage() > buffer.config.MaxMemory {
+		buffer.dropOldestEntry()
+	}
+}
+
+func (buffer *rewindBuffer) rewindTo(frame uint64) error {
+	for buffer.frame > frame && buffer.count > 0 {
+		var entry = buffer.popNewestEntry()
+		var delta, err = decompress(entry.delta, len(buffer.current))
+		if err != nil {
+			buffer.clear()
+			return err
+		}
+		buffer.current = xorBytes(buffer.current, delta)
+		buffer.frame = entry.frame
+	}
+	return nil
+}
+
+func (buffer *rewindBuffer) clear() {
+	buffer.current = nil
+	buffer.entries = nil
+	buffer.start = 0
+	buffer.count = 0
+	buffer.deltasBytes = 0
+}
+
+// Ring
+
+func (buffer *rewindBuffer) pushEntry(entry rewindEntry) {
+	if buffer.count == len(buffer.entries) {
+		var entries = make([]rewindEntry, 2*len(buffer.entries)+1)
+		for i := 0; i < buffer.count; i++ {
+			entries[i] = buffer.entries[(buffer.start+i)%len(buffer.entries)]
+		}
+		buffer.entries = entries
+		buffer.start = 0
+	}
+	buffer.entries[(buffer.start+buffer.count)%len(buffer.entries)] = entry
+	buffer.count += 1
+	buffer.deltasBytes += len(entry.delta)
+}
+
+func (buffer *rewindBuffer) dropOldestEntry() {
+	buffer.deltasBytes -= len(buffer.entries[buffer.start].delta)
+	buffer.entries[buffer.start] = rewindEntry{}
+	buffer.start = (buffer.start + 1) % len(buffer.entries)
+	buffer.count -= 1
+}
+
+func (buffer *rewindBuffer) popNewestEntry() rewindEntry {
+	var index = (buffer.start + buffer.count - 1) % len(buffer.entries)
+	var entry = buffer.entries[index]
+	buffer.entries[index] = rewindEntry{}
+	buffer.count -= 1
+	buffer.deltasBytes -= len(entry.delta)
+	return entry
+}
+
+// Compression
+
+// Consecutive snapshots mostly differ by a few bytes, so their XOR is mostly zeros
+func xorBytes(a []byte, b []byte) []byte {
+	var result = make([]byte, len(a))
+	for i := range a {
+		result[i] = a[i] ^ b[i]
+	}
+	return result
+}
+
+func (buffer *rewindBuffer) compress(data []byte) ([]byte, error) {
+	var output bytes.Buffer
+	buffer.compressor.Reset(&output)
+	if _, err := buffer.compressor.Write(data); err != nil {
+		return nil, err
+	}
+	if err := buffer.compressor.Close(); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+func decompress(data []byte, size int) ([]byte, error) {
+	var result = make([]byte, size)
+	var reader = flate.NewReader(bytes.NewReader(data))
+	defer reader.Close()
+	if _, err := io.ReadFull(reader, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,77 @@
+package nes_console
+
+import (
+	"nes-emulator/bus"
+	"testing"
+)
+
+// NROM image running from $C000 a loop that keeps changing the RAM, so that every snapshot differs
+func newLoopingRom(t testing.TB) *bus.Rom {
+	var image = make([]uint8, 16+bus.PRG_ROM_PAGE_SIZE)
+	copy(image, []uint8{'N', 'E', 'S', 0x1A, 1, 0})
+	var loop = []uint8{
+		0xE6, 0x00, // INC $00
+		0xA5, 0x00, // LDA $00
+		0x9D, 0x00, 0x03, // STA $0300,X
+		0xE8,             // INX
+		0x4C, 0x00, 0xC0, // JMP $C000
+	}
+	// A single PRG bank is mirrored at $8000 and $C000
+	copy(image[16:], loop)
+	var rom, err = bus.ParseRawRom(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rom
+}
+
+func newRewindConsole(t testing.TB, config RewindConfig) *NesConsole {
+	var console = NewConsole()
+	console.LoadRom(newLoopingRom(t))
+	console.PowerOn()
+	if err := console.EnableRewind(config); err != nil {
+		t.Fatal(err)
+	}
+	return &console
+}
+
+func runFrames(t testing.TB, console *NesConsole, frames int) {
+	for i := 0; i < frames; i++ {
+		if _, err := console.StepFrame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRewindZeroFrames(t *testing.T) {
+	var console = newRewindConsole(t, RewindConfig{Interval: 5, MaxMemory: DEFAULT_REWIND_MAX_MEMORY})
+	runFrames(t, console, 12)
+	var cycles = console.cpu.Cycles()
+	var rewound, err = console.RewindFrames(0)
+	if err != nil || rewound != 0 {
+		t.Fatalf("RewindFrames(0) = %d, %v, want 0, nil", rewound, err)
+	}
+	if console.FrameCount() != 12 || console.cpu.Cycles() != cycles {
+		t.Errorf("RewindFrames(0) moved the machine to frame %d", console.FrameCount())
+	}
+	if _, err := console.RewindFrames(-1); err == nil {
+		t.Errorf("RewindFrames(-1) did not fail")
+	}
+	rewound, err = console.RewindFrames(1)
+	if err != nil || rewound != 2 || console.FrameCount() != 10 {
+		t.Errorf("RewindFrames(1) = %d, %v to frame %d, want 2 frames back to the snapshot of frame 10", rewound, err, console.FrameCount())
+	}
+}
+
+// Reports the memory held by the snapshots of REWIND_BENCHMARK_FRAMES frames with the default config
+func BenchmarkRewindMemoryUsage(b *testing.B) {
+	const REWIND_BENCHMARK_FRAMES = 600
+	var usage int
+	for i := 0; i < b.N; i++ {
+		var console = newRewindConsole(b, DefaultRewindConfig())
+		runFrames(b, console, REWIND_BENCHMARK_FRAMES)
+		usage = console.RewindMemoryUsage()
+	}
+	b.ReportMetric(float64(usage), "rewind-bytes")
+	b.ReportMetric(float64(usage)/REWIND_BENCHMARK_FRAMES, "rewind-bytes/frame")
+}
@@ -0,0 +1,58 @@
+package nes_console
+
+import (
+	"errors"
+	"io"
+	"nes-emulator/region"
+	"nes-emulator/savestate"
+)
+
+var errNoRomLoaded = errors.New("a ROM must be loaded to save or load a state")
+
+// Writes the state of every component of the machine
+func (console *NesConsole) SaveState(output io.Writer) error {
+	if console.rom == nil {
+		return errNoRomLoaded
+	}
+	return savestate.Write(output, console.rom.Checksum(), console.stateSections())
+}
+
+// Restores a state written by SaveState, states made with another ROM are rejected
+// When a movie is recorded, the frames after the state are discarded and the loading counts as a rerecord
+func (console *NesConsole) LoadState(input io.Reader) error {
+	if console.rom == nil {
+		return errNoRomLoaded
+	}
+	if err := savestate.Read(input, console.rom.Checksum(), console.stateSections()); err != nil {
+		return err
+	}
+	console.restoreMovieFrame()
+	return nil
+}
+
+func (console *NesConsole) stateSections() []savestate.Section {
+	return []savestate.Section{
+		{Tag: "CONS", Serialize: console.serializeState},
+		{Tag: "CPU", Serialize: console.cpu.SerializeState},
+		{Tag: "BUS", Serialize: console.bus.SerializeState},
+		{Tag: "APU", Serialize: console.apu.SerializeState},
+		{Tag: "CART", Serialize: console.rom.SerializeState},
+		{Tag: "PAD1", Serialize: console.joypads[0].SerializeState},
+		{Tag: "PAD2", Serialize: console.joypads[1].SerializeState},
+	}
+}
+
+func (console *NesConsole) serializeState(state *savestate.Serializer) {
+	var consoleRegion = int(console.region)
+	state.Int(&consoleRegion)
+	if region.Region(consoleRegion) < region.NTSC || region.Region(consoleRegion) > region.DENDY {
+		state.Fail(errors.New("unknown region"))
+		return
+	}
+	if state.IsLoading() && consoleRegion != int(console.region) {
+		console.SetRegion(region.Region(consoleRegion))
+	}
+	state.Uint64(&console.frameCount)
+	state.Bool(&console.isFrameRunning)
+	state.Float64(&console.nextFrameCycle)
+}
@@ -0,0 +1,90 @@
+package nes_console
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"nes-emulator/savestate"
+	"testing"
+)
+
+func saveConsoleState(t *testing.T, console *NesConsole) []byte {
+	var state bytes.Buffer
+	if err := console.SaveState(&state); err != nil {
+		t.Fatal(err)
+	}
+	return state.Bytes()
+}
+
+func newStateConsole(t *testing.T) *NesConsole {
+	var console = NewConsole()
+	console.LoadRom(newLoopingRom(t))
+	console.PowerOn()
+	runFrames(t, &console, 3)
+	return &console
+}
+
+func TestSaveStateRoundTrip(t *testing.T) {
+	var console = newStateConsole(t)
+	var saved = saveConsoleState(t, console)
+	runFrames(t, console, 2)
+	var expected = saveConsoleState(t, console)
+
+	if err := console.LoadState(bytes.NewReader(saved)); err != nil {
+		t.Fatal(err)
+	}
+	if console.FrameCount() != 3 {
+		t.Errorf("state loaded at frame %d, want 3", console.FrameCount())
+	}
+	runFrames(t, console, 2)
+	if !bytes.Equal(saveConsoleState(t, console), expected) {
+		t.Errorf("frames run from the loaded state differ from the ones run after saving it")
+	}
+}
+
+func TestLoadStateErrors(t *testing.T) {
+	var tests = []struct {
+		name    string
+		corrupt func(t *testing.T, state []byte, console *NesConsole) []byte
+	}{
+		{"another ROM", func(t *testing.T, state []byte, console *NesConsole) []byte {
+			var other = NewConsole()
+			other.LoadRom(newControllerRom(t))
+			other.PowerOn()
+			return saveConsoleState(t, &other)
+		}},
+		{"another version", func(t *testing.T, state []byte, console *NesConsole) []byte {
+			binary.LittleEndian.PutUint16(state[8:], savestate.FORMAT_VERSION-1)
+			return state
+		}},
+		// The last section is cut short, once every other one has been loaded
+		{"truncated last section", func(t *testing.T, state []byte, console *NesConsole) []byte {
+			var offset = 8 + 2 + 16 + 2
+			var sizeOffset int
+			for offset < len(state) {
+				sizeOffset = offset + savestate.TAG_SIZE
+				offset = sizeOffset + 4 + int(binary.LittleEndian.Uint32(state[sizeOffset:]))
+			}
+			binary.LittleEndian.PutUint32(state[sizeOffset:], binary.LittleEndian.Uint32(state[sizeOffset:])-1)
+			return state[:len(state)-1]
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var console = newStateConsole(t)
+			var state = test.corrupt(t, saveConsoleState(t, console), console)
+			runFrames(t, console, 2)
+			var current = saveConsoleState(t, console)
+			var err = console.LoadState(bytes.NewReader(state))
+			if err == nil {
+				t.Fatal("state loaded without error")
+			}
+			if test.name == "another ROM" && !errors.Is(err, savestate.ErrRomMismatch) {
+				t.Errorf("error %v, want savestate.ErrRomMismatch", err)
+			}
+			if !bytes.Equal(saveConsoleState(t, console), current) {
+				t.Errorf("machine changed by a failed loading")
+			}
+		})
+	}
+}
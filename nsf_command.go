@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"nes-emulator/bus"
+	"nes-emulator/nes_console"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const DEFAULT_SONG_DURATION time.Duration = 2 * time.Minute
+
+func runNsfCommand(arguments []string) error {
+	var flags = flag.NewFlagSet("nsf", flag.ExitOnError)
+	var track = flags.Int("track", 0, "track to render, numbered from 1 (defaults to the starting track of the file)")
+	var allTracks = flags.Bool("all", false, "render every track, in files suffixed with the track number")
+	var duration = flags.Duration("duration", 0, "duration of each track (defaults to the NSFe track time, or 2m)")
+	var wavPath = flags.String("wav", "", "WAV file to write (defaults to the NSF file name)")
+	var sampleRate = flags.Int("sample-rate", DEFAULT_SAMPLE_RATE, "sample rate of the WAV file in Hz (44100 or 48000)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: nes-emulator nsf [options] file.nsf|file.nsfe")
+		flags.PrintDefaults()
+	}
+	flags.Parse(arguments)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected exactly one NSF file")
+	}
+
+	var nsfPath = flags.Arg(0)
+	var raw, errorRead = os.ReadFile(nsfPath)
+	if errorRead != nil {
+		return errorRead
+	}
+	var nsf *bus.Nsf
+	var errorParse error
+	if bytes.HasPrefix(raw, []byte("NSFE")) {
+		nsf, errorParse = bus.ParseRawNsfe(raw)
+	} else {
+		nsf, errorParse = bus.ParseRawNsf(raw)
+	}
+	if errorParse != nil {
+		return errorParse
+	}
+
+	fmt.Println(fmt.Sprintf("%s - %s (%s), %d tracks", nsf.Title, nsf.Artist, nsf.Copyright, nsf.NumberOfSongs))
+	if nsf.ExpansionChips != 0 {
+		fmt.Println(fmt.Sprintf("Warning : expansion audio (%s) is not emulated, only the 2A03 channels are rendered", nsf.ExpansionChips))
+	}
+	if nsf.IsPal && !nsf.IsDualRegion {
+		fmt.Println("PAL tune, played with PAL timings")
+	}
+
+	if *wavPath == "" {
+		*wavPath = strings.TrimSuffix(nsfPath, filepath.Ext(nsfPath)) + ".wav"
+	}
+
+	var songs []int
+	switch {
+	case *allTracks:
+		for song := 0; song < nsf.NumberOfSongs; song++ {
+			songs = append(songs, song)
+		}
+	case *track > 0:
+		songs = []int{*track - 1}
+	default:
+		songs = []int{nsf.StartingSong}
+	}
+
+	for _, song := range songs {
+		var songWavPath = *wavPath
+		if *allTracks {
+			songWavPath = fmt.Sprintf("%s-%02d%s", strings.TrimSuffix(*wavPath, filepath.Ext(*wavPath)), song+1, filepath.Ext(*wavPath))
+		}
+		if err := renderNsfSong(nsf, song, songDuration(nsf, song, *duration), songWavPath, *sampleRate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func songDuration(nsf *bus.Nsf, song int, requestedDuration time.Duration) time.Duration {
+	if requestedDuration > 0 {
+		return requestedDuration
+	}
+	if song < len(nsf.TrackDurations) && nsf.TrackDurations[song] > 0 {
+		return time.Duration(nsf.TrackDurations[song]) * time.Millisecond
+	}
+	return DEFAULT_SONG_DURATION
+}
+
+func renderNsfSong(nsf *bus.Nsf, song int, duration time.Duration, wavPath string, sampleRate int) error {
+	var title = fmt.Sprintf("track %d", song+1)
+	if song < len(nsf.TrackLabels) && nsf.TrackLabels[song] != "" {
+		title = fmt.Sprintf("%s (%s)", title, nsf.TrackLabels[song])
+	}
+	fmt.Println(fmt.Sprintf("Rendering %s during %v in WAV file %s...", title, duration, wavPath))
+
+	var console = nes_console.NewConsole()
+	console.LoadNsf(nsf)
+	var closeWav, errorWav = openWavOutput(&console, wavPath, sampleRate)
+	if errorWav != nil {
+		return errorWav
+	}
+	var errorPlay = console.PlayNsfSong(song, duration)
+	if errorClose := closeWav(); errorPlay == nil {
+		errorPlay = errorClose
+	}
+	return errorPlay
+}
@@ -0,0 +1,213 @@
+package profiler
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Profiles in the format of pprof, a gzipped protocol buffer, so that `go tool pprof` can show the hot spots
+// of the 6502 program : each subroutine is a function, each instruction address a location
+// https://github.com/google/pprof/blob/main/proto/profile.proto
+
+// Fields of the messages of profile.proto
+const (
+	PROFILE_SAMPLE_TYPE  = 1
+	PROFILE_SAMPLE       = 2
+	PROFILE_MAPPING      = 3
+	PROFILE_LOCATION     = 4
+	PROFILE_FUNCTION     = 5
+	PROFILE_STRING_TABLE = 6
+	PROFILE_PERIOD_TYPE  = 11
+	PROFILE_PERIOD       = 12
+	VALUE_TYPE_TYPE      = 1
+	VALUE_TYPE_UNIT      = 2
+	SAMPLE_LOCATION_ID   = 1
+	SAMPLE_VALUE         = 2
+	MAPPING_ID           = 1
+	MAPPING_MEMORY_START = 2
+	MAPPING_MEMORY_LIMIT = 3
+	MAPPING_FILENAME     = 5
+	MAPPING_HAS_FUNCTION = 7
+	LOCATION_ID          = 1
+	LOCATION_MAPPING_ID  = 2
+	LOCATION_ADDRESS     = 3
+	LOCATION_LINE        = 4
+	LINE_FUNCTION_ID     = 1
+	FUNCTION_ID          = 1
+	FUNCTION_NAME        = 2
+	FUNCTION_SYSTEM_NAME = 3
+)
+
+// Wire types of protocol buffers
+const (
+	WIRE_VARINT = 0
+	WIRE_BYTES  = 2
+)
+
+// Every location belongs to the single mapping of the CPU address space
+const CPU_MAPPING_ID uint64 = 1
+
+// Writes the profile in the pprof format, with the executions and the cycles of each stack of calls
+func (profiler *Profiler) WritePprof(output io.Writer) error {
+	var profile = newPprofBuilder()
+	profile.valueType(PROFILE_SAMPLE_TYPE, "instructions", "count")
+	profile.valueType(PROFILE_SAMPLE_TYPE, "cycles", "count")
+	profile.addSamples(profiler.root)
+
+	var mapping = protoBuffer{}
+	mapping.varint(MAPPING_ID, CPU_MAPPING_ID)
+	mapping.varint(MAPPING_MEMORY_START, 0)
+	mapping.varint(MAPPING_MEMORY_LIMIT, 0x10000)
+	mapping.varint(MAPPING_FILENAME, profile.stringIndex("6502"))
+	// Functions are already named, pprof must not look for symbols
+	mapping.varint(MAPPING_HAS_FUNCTION, 1)
+	profile.buffer.message(PROFILE_MAPPING, &mapping)
+
+	profile.valueType(PROFILE_PERIOD_TYPE, "cycles", "count")
+	profile.buffer.varint(PROFILE_PERIOD, 1)
+	// The table is written last, once every string is known
+	for _, value := range profile.strings {
+		profile.buffer.bytes(PROFILE_STRING_TABLE, []byte(value))
+	}
+
+	var compressor = gzip.NewWriter(output)
+	if _, err := compressor.Write(profile.buffer.data); err != nil {
+		return err
+	}
+	return compressor.Close()
+}
+
+type pprofBuilder struct {
+	buffer protoBuffer
+	// The first string of the table is always empty
+	strings       []string
+	stringIndices map[string]uint64
+	// Location of each subroutine and address pair, and function of each subroutine
+	locationIds map[uint32]uint64
+	functionIds map[uint16]uint64
+}
+
+func newPprofBuilder() *pprofBuilder {
+	return &pprofBuilder{
+		strings:       []string{""},
+		stringIndices: map[string]uint64{"": 0},
+		locationIds:   map[uint32]uint64{},
+		functionIds:   map[uint16]uint64{},
+	}
+}
+
+func (profile *pprofBuilder) stringIndex(value string) uint64 {
+	var index, isKnown = profile.stringIndices[value]
+	if !isKnown {
+		index = uint64(len(profile.strings))
+		profile.strings = append(profile.strings, value)
+		profile.stringIndices[value] = index
+	}
+	return index
+}
+
+func (profile *pprofBuilder) valueType(field int, valueType string, unit string) {
+	var message = protoBuffer{}
+	message.varint(VALUE_TYPE_TYPE, profile.stringIndex(valueType))
+	message.varint(VALUE_TYPE_UNIT, profile.stringIndex(unit))
+	profile.buffer.message(field, &message)
+}
+
+// One sample per instruction address of each call, children sorted so that the output is reproducible
+func (profile *pprofBuilder) addSamples(node *callNode) {
+	var addresses = make([]uint16, 0, len(node.instructions))
+	for address := range node.instructions {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+	for _, address := range addresses {
+		// From the instruction up to the root of the calls
+		var locationIds = []uint64{profile.locationId(node.routine, address)}
+		for call := node; call.parent != nil; call = call.parent {
+			locationIds = append(locationIds, profile.locationId(call.parent.routine, call.callSite))
+		}
+		var count = node.instructions[address]
+		var sample = protoBuffer{}
+		sample.packed(SAMPLE_LOCATION_ID, locationIds)
+		sample.packed(SAMPLE_VALUE, []uint64{count.executions, count.cycles})
+		profile.buffer.message(PROFILE_SAMPLE, &sample)
+	}
+
+	var keys = make([]uint32, 0, len(node.children))
+	for key := range node.children {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, key := range keys {
+		profile.addSamples(node.children[key])
+	}
+}
+
+func (profile *pprofBuilder) locationId(routine uint16, address uint16) uint64 {
+	var key = uint32(routine)<<16 | uint32(address)
+	if id, isKnown := profile.locationIds[key]; isKnown {
+		return id
+	}
+	var id = uint64(len(profile.locationIds) + 1)
+	profile.locationIds[key] = id
+	var line = protoBuffer{}
+	line.varint(LINE_FUNCTION_ID, profile.functionId(routine))
+	var location = protoBuffer{}
+	location.varint(LOCATION_ID, id)
+	location.varint(LOCATION_MAPPING_ID, CPU_MAPPING_ID)
+	location.varint(LOCATION_ADDRESS, uint64(address))
+	location.message(LOCATION_LINE, &line)
+	profile.buffer.message(PROFILE_LOCATION, &location)
+	return id
+}
+
+func (profile *pprofBuilder) functionId(routine uint16) uint64 {
+	if id, isKnown := profile.functionIds[routine]; isKnown {
+		return id
+	}
+	var id = uint64(len(profile.functionIds) + 1)
+	profile.functionIds[routine] = id
+	var name = profile.stringIndex(fmt.Sprintf("$%04X", routine))
+	var function = protoBuffer{}
+	function.varint(FUNCTION_ID, id)
+	function.varint(FUNCTION_NAME, name)
+	function.varint(FUNCTION_SYSTEM_NAME, name)
+	profile.buffer.message(PROFILE_FUNCTION, &function)
+	return id
+}
+
+// Encoder of the few protocol buffer types a profile needs
+type protoBuffer struct {
+	data []byte
+}
+
+func (buffer *protoBuffer) tag(field int, wireType int) {
+	buffer.data = binary.AppendUvarint(buffer.data, uint64(field<<3|wireType))
+}
+
+func (buffer *protoBuffer) varint(field int, value uint64) {
+	buffer.tag(field, WIRE_VARINT)
+	buffer.data = binary.AppendUvarint(buffer.data, value)
+}
+
+func (buffer *protoBuffer) bytes(field int, value []byte) {
+	buffer.tag(field, WIRE_BYTES)
+	buffer.data = binary.AppendUvarint(buffer.data, uint64(len(value)))
+	buffer.data = append(buffer.data, value...)
+}
+
+func (buffer *protoBuffer) message(field int, message *protoBuffer) {
+	buffer.bytes(field, message.data)
+}
+
+// Repeated numbers are packed in a single field
+func (buffer *protoBuffer) packed(field int, values []uint64) {
+	var packed = protoBuffer{}
+	for _, value := range values {
+		packed.data = binary.AppendUvarint(packed.data, value)
+	}
+	buffer.bytes(field, packed.data)
+}
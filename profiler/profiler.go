@@ -0,0 +1,150 @@
+package profiler
+
+import (
+	"nes-emulator/cpu"
+)
+
+// Cycles spent by the program per instruction address and per subroutine, with the stacks of calls they were
+// spent in, see report.go and pprof.go for the outputs
+// Subroutines are entered by JSR or an IRQ, and left once RTS, RTI or TXS moves the stack pointer back
+// above the return address, so that stack tricks such as RTS dispatch tables do not unbalance the calls
+type Profiler struct {
+	// Indexed by the address of the instructions
+	cycles     [0x10000]uint64
+	executions [0x10000]uint64
+	mnemonics  map[uint16]string
+	// Subroutines being executed, from the outermost one
+	callStack []*callNode
+	// Root of the tree of every stack of calls seen
+	root *callNode
+	// Registers after the previous instruction, which are the registers before the current one
+	stackPointer   uint8
+	programCounter uint16
+	totalCycles    uint64
+	// Cycles of each frame ended, and of the current one
+	frameCycles    []uint64
+	currentFrame   uint64
+	isFrameRunning bool
+}
+
+// Subroutine called from a given stack of calls
+type callNode struct {
+	routine uint16
+	// Address of the JSR, or of the instruction interrupted by the IRQ
+	callSite uint16
+	parent   *callNode
+	children map[uint32]*callNode
+	// Stack pointer the subroutine returns with, which is the one before its call
+	stackPointer uint8
+	calls        uint64
+	// Cycles and executions of the instructions run by this call itself, by address
+	instructions map[uint16]*instructionCount
+}
+
+type instructionCount struct {
+	executions uint64
+	cycles     uint64
+}
+
+// The program counter of consoleCPU is the root subroutine, which never returns
+func NewProfiler(consoleCPU *cpu.CPU) *Profiler {
+	var registers = consoleCPU.Registers()
+	var root = newCallNode(registers.ProgramCounter, registers.ProgramCounter, nil, registers.StackPointer)
+	root.calls = 1
+	return &Profiler{
+		mnemonics:      map[uint16]string{},
+		callStack:      []*callNode{root},
+		root:           root,
+		stackPointer:   registers.StackPointer,
+		programCounter: registers.ProgramCounter,
+	}
+}
+
+func newCallNode(routine uint16, callSite uint16, parent *callNode, stackPointer uint8) *callNode {
+	return &callNode{
+		routine:      routine,
+		callSite:     callSite,
+		parent:       parent,
+		children:     map[uint32]*callNode{},
+		stackPointer: stackPointer,
+		instructions: map[uint16]*instructionCount{},
+	}
+}
+
+// Accounts an instruction executed by the CPU, registers holds the registers once it is executed
+func (profiler *Profiler) ProfileInstruction(instruction cpu.ExecutedInstruction, registers cpu.Registers) {
+	// The interrupt sequence is spent in the handler
+	if instruction.IsInterrupted {
+		profiler.enter(instruction.Address, profiler.programCounter, profiler.stackPointer)
+	}
+	var address = instruction.Address
+	if profiler.executions[address] == 0 {
+		profiler.mnemonics[address] = instruction.OpCode.Mnemonic()
+	}
+	profiler.cycles[address] += instruction.Cycles
+	profiler.executions[address]++
+	profiler.totalCycles += instruction.Cycles
+	profiler.currentFrame += instruction.Cycles
+	profiler.isFrameRunning = true
+
+	var node = profiler.callStack[len(profiler.callStack)-1]
+	var count, isCounted = node.instructions[address]
+	if !isCounted {
+		count = &instructionCount{}
+		node.instructions[address] = count
+	}
+	count.executions++
+	count.cycles += instruction.Cycles
+
+	// The JSR itself is spent in the caller, the RTS in the callee
+	switch instruction.OpCode.Operation() {
+	case cpu.JSR:
+		profiler.enter(instruction.OperandAddress, address, profiler.stackPointer)
+	case cpu.RTS, cpu.RTI, cpu.TXS:
+		profiler.leave(registers.StackPointer)
+	}
+	profiler.stackPointer = registers.StackPointer
+	profiler.programCounter = registers.ProgramCounter
+}
+
+func (profiler *Profiler) enter(routine uint16, callSite uint16, stackPointer uint8) {
+	var parent = profiler.callStack[len(profiler.callStack)-1]
+	var key = uint32(callSite)<<16 | uint32(routine)
+	var node, isKnown = parent.children[key]
+	if !isKnown {
+		node = newCallNode(routine, callSite, parent, stackPointer)
+		parent.children[key] = node
+	}
+	// The same call site can be reached with another stack pointer
+	node.stackPointer = stackPointer
+	node.calls++
+	profiler.callStack = append(profiler.callStack, node)
+}
+
+// Leaves the subroutines whose return address is above the stack pointer
+func (profiler *Profiler) leave(stackPointer uint8) {
+	for len(profiler.callStack) > 1 && profiler.callStack[len(profiler.callStack)-1].stackPointer <= stackPointer {
+		profiler.callStack = profiler.callStack[:len(profiler.callStack)-1]
+	}
+}
+
+// Ends the current frame, which should happen when the PPU raises the NMI of the vertical blank
+// The PPU is not emulated yet, so the console calls it at the end of its frames, see NesConsole.StepFrame
+// Frames without any instruction executed, such as when the CPU is halted, are not counted
+func (profiler *Profiler) EndFrame() {
+	if !profiler.isFrameRunning {
+		return
+	}
+	profiler.frameCycles = append(profiler.frameCycles, profiler.currentFrame)
+	profiler.currentFrame = 0
+	profiler.isFrameRunning = false
+}
+
+func (profiler *Profiler) TotalCycles() uint64 {
+	return profiler.totalCycles
+}
+
+// Cycles of each frame ended
+func (profiler *Profiler) FrameCycles() []uint64 {
+	return profiler.frameCycles
+}
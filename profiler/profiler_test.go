@@ -0,0 +1,87 @@
+package profiler_test
+
+import (
+	"nes-emulator/cpu"
+	"nes-emulator/profiler"
+	"nes-emulator/singlestep"
+	"testing"
+)
+
+// A main routine calling twice a subroutine, which calls another one
+var program = map[uint16][]uint8{
+	0x0200: {0x20, 0x10, 0x02}, // JSR $0210 : 6 cycles
+	0x0203: {0x20, 0x10, 0x02}, // JSR $0210 : 6 cycles
+	0x0206: {0x4C, 0x06, 0x02}, // JMP $0206 : 3 cycles
+	0x0210: {0x20, 0x20, 0x02}, // JSR $0220 : 6 cycles
+	0x0213: {0x60},             // RTS : 6 cycles
+	0x0220: {0xEA},             // NOP : 2 cycles
+	0x0221: {0x60},             // RTS : 6 cycles
+}
+
+func profileProgram(t *testing.T, steps int, frameSteps int) *profiler.Profiler {
+	var flatBus = &singlestep.FlatBus{}
+	for address, bytes := range program {
+		for i, data := range bytes {
+			flatBus.Poke(address+uint16(i), data)
+		}
+	}
+	var testCPU = cpu.NewCPU(flatBus)
+	testCPU.SetTraceEnabled(false)
+	testCPU.SetRegisters(cpu.Registers{StackPointer: 0xFD, ProgramCounter: 0x0200})
+	var cycleProfiler = profiler.NewProfiler(&testCPU)
+	testCPU.SetInstructionHandler(func(instruction cpu.ExecutedInstruction) {
+		cycleProfiler.ProfileInstruction(instruction, testCPU.Registers())
+	})
+	for i := 1; i <= steps; i++ {
+		if !testCPU.Step() {
+			t.Fatalf("CPU stopped at step %d", i)
+		}
+		if frameSteps > 0 && i%frameSteps == 0 {
+			cycleProfiler.EndFrame()
+		}
+	}
+	return cycleProfiler
+}
+
+func TestRoutineCycles(t *testing.T) {
+	// Both calls, then the JMP
+	var cycleProfiler = profileProgram(t, 11, 0)
+	var want = []profiler.RoutineStats{
+		{Address: 0x0200, Calls: 1, InclusiveCycles: 55, ExclusiveCycles: 15},
+		{Address: 0x0210, Calls: 2, InclusiveCycles: 40, ExclusiveCycles: 24},
+		{Address: 0x0220, Calls: 2, InclusiveCycles: 16, ExclusiveCycles: 16},
+	}
+	var routines = cycleProfiler.Routines()
+	if len(routines) != len(want) {
+		t.Fatalf("routines %+v, want %+v", routines, want)
+	}
+	for i := range want {
+		if routines[i] != want[i] {
+			t.Errorf("routine %+v, want %+v", routines[i], want[i])
+		}
+	}
+	if cycleProfiler.TotalCycles() != 55 {
+		t.Errorf("%d cycles profiled, want 55", cycleProfiler.TotalCycles())
+	}
+
+	// The JSR and RTS of the subroutines tie, by address
+	var instructions = cycleProfiler.Instructions()
+	if instructions[0].Address != 0x0210 || instructions[0].Mnemonic != "JSR" || instructions[0].Executions != 2 || instructions[0].Cycles != 12 {
+		t.Errorf("hottest instruction %+v, want the JSR at $0210", instructions[0])
+	}
+}
+
+func TestFrameCycles(t *testing.T) {
+	// A frame per call, the JMP starts a frame which is not ended
+	var cycleProfiler = profileProgram(t, 11, 5)
+	var frames = cycleProfiler.FrameCycles()
+	if len(frames) != 2 || frames[0] != 26 || frames[1] != 26 {
+		t.Errorf("frames of %v cycles, want [26 26]", frames)
+	}
+	// Ending a frame without any instruction run does not count it
+	cycleProfiler.EndFrame()
+	cycleProfiler.EndFrame()
+	if len(cycleProfiler.FrameCycles()) != 3 {
+		t.Errorf("%d frames, want 3", len(cycleProfiler.FrameCycles()))
+	}
+}
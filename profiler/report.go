@@ -0,0 +1,144 @@
+package profiler
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+type RoutineStats struct {
+	Address uint16
+	Calls   uint64
+	// Cycles spent in the subroutine and the ones it called, recursive calls counted once
+	InclusiveCycles uint64
+	// Cycles spent in the subroutine itself
+	ExclusiveCycles uint64
+}
+
+type InstructionStats struct {
+	Address    uint16
+	Mnemonic   string
+	Executions uint64
+	Cycles     uint64
+}
+
+// Subroutines called, by decreasing inclusive cycles
+func (profiler *Profiler) Routines() []RoutineStats {
+	var statsByAddress = map[uint16]*RoutineStats{}
+	var activeCalls = map[uint16]int{}
+	var visit func(node *callNode) uint64
+	visit = func(node *callNode) uint64 {
+		var stats, isKnown = statsByAddress[node.routine]
+		if !isKnown {
+			stats = &RoutineStats{Address: node.routine}
+			statsByAddress[node.routine] = stats
+		}
+		stats.Calls += node.calls
+		var total uint64
+		for _, count := range node.instructions {
+			total += count.cycles
+		}
+		stats.ExclusiveCycles += total
+		activeCalls[node.routine]++
+		for _, child := range node.children {
+			total += visit(child)
+		}
+		activeCalls[node.routine]--
+		if activeCalls[node.routine] == 0 {
+			stats.InclusiveCycles += total
+		}
+		return total
+	}
+	visit(profiler.root)
+
+	var routines = make([]RoutineStats, 0, len(statsByAddress))
+	for _, stats := range statsByAddress {
+		routines = append(routines, *stats)
+	}
+	sort.Slice(routines, func(i, j int) bool {
+		if routines[i].InclusiveCycles != routines[j].InclusiveCycles {
+			return routines[i].InclusiveCycles > routines[j].InclusiveCycles
+		}
+		return routines[i].Address < routines[j].Address
+	})
+	return routines
+}
+
+// Instructions executed, by decreasing cycles
+func (profiler *Profiler) Instructions() []InstructionStats {
+	var instructions = make([]InstructionStats, 0, len(profiler.mnemonics))
+	for address, mnemonic := range profiler.mnemonics {
+		instructions = append(instructions, InstructionStats{
+			Address:    address,
+			Mnemonic:   mnemonic,
+			Executions: profiler.executions[address],
+			Cycles:     profiler.cycles[address],
+		})
+	}
+	sort.Slice(instructions, func(i, j int) bool {
+		if instructions[i].Cycles != instructions[j].Cycles {
+			return instructions[i].Cycles > instructions[j].Cycles
+		}
+		return instructions[i].Address < instructions[j].Address
+	})
+	return instructions
+}
+
+// Writes the cycles per frame, then the limit subroutines and instructions taking the most cycles
+func (profiler *Profiler) WriteReport(output io.Writer, limit int) error {
+	var report = reportWriter{output: output}
+	report.printf("%d cycles profiled\n", profiler.totalCycles)
+	if len(profiler.frameCycles) > 0 {
+		var minimum, maximum, total = profiler.frameCycles[0], profiler.frameCycles[0], uint64(0)
+		for _, cycles := range profiler.frameCycles {
+			if cycles < minimum {
+				minimum = cycles
+			}
+			if cycles > maximum {
+				maximum = cycles
+			}
+			total += cycles
+		}
+		report.printf("%d frames : %d cycles per frame on average, %d at least, %d at most\n",
+			len(profiler.frameCycles), total/uint64(len(profiler.frameCycles)), minimum, maximum)
+	}
+
+	report.printf("\nSubroutines       Calls     Inclusive cycles     Exclusive cycles\n")
+	for i, routine := range profiler.Routines() {
+		if i == limit {
+			break
+		}
+		report.printf("$%04X      %11d %13d %5.1f%% %13d %5.1f%%\n", routine.Address, routine.Calls,
+			routine.InclusiveCycles, profiler.percentage(routine.InclusiveCycles),
+			routine.ExclusiveCycles, profiler.percentage(routine.ExclusiveCycles))
+	}
+
+	report.printf("\nInstructions       Executions        Cycles\n")
+	for i, instruction := range profiler.Instructions() {
+		if i == limit {
+			break
+		}
+		report.printf("$%04X  %-4s %16d %13d %5.1f%%\n", instruction.Address, instruction.Mnemonic,
+			instruction.Executions, instruction.Cycles, profiler.percentage(instruction.Cycles))
+	}
+	return report.err
+}
+
+func (profiler *Profiler) percentage(cycles uint64) float64 {
+	if profiler.totalCycles == 0 {
+		return 0
+	}
+	return float64(cycles) * 100 / float64(profiler.totalCycles)
+}
+
+// Keeps the first error, so that the report is written without checking each line
+type reportWriter struct {
+	output io.Writer
+	err    error
+}
+
+func (report *reportWriter) printf(format string, arguments ...any) {
+	if report.err == nil {
+		_, report.err = fmt.Fprintf(report.output, format, arguments...)
+	}
+}
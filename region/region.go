@@ -0,0 +1,82 @@
+package region
+
+import "fmt"
+
+// TV system the console was sold for, it sets the clocks of the CPU, PPU and APU
+// https://www.nesdev.org/wiki/Cycle_reference_chart
+type Region int
+
+const (
+	NTSC Region = iota
+	PAL
+	// Famiclone sold in Russia : PAL video with NTSC CPU/PPU ratio and APU timings
+	DENDY
+)
+
+// PPU dots per scanline, whatever the region
+const DOTS_PER_SCANLINE int = 341
+
+type Timing struct {
+	// Hz
+	CpuFrequency float64
+	// PPU dots per CPU cycle
+	PpuClockRatio float64
+	Scanlines     int
+	// Scanlines between the end of the picture and the pre-render scanline
+	VBlankScanlines int
+	// NTSC PPU skips a dot every other frame, so this is an average
+	CpuCyclesPerFrame float64
+}
+
+var timings = map[Region]Timing{
+	NTSC: {
+		CpuFrequency:      1789773,
+		PpuClockRatio:     3,
+		Scanlines:         262,
+		VBlankScanlines:   20,
+		CpuCyclesPerFrame: 29780.5,
+	},
+	PAL: {
+		CpuFrequency:      1662607,
+		PpuClockRatio:     3.2,
+		Scanlines:         312,
+		VBlankScanlines:   70,
+		CpuCyclesPerFrame: 33247.5,
+	},
+	DENDY: {
+		CpuFrequency:    1773448,
+		PpuClockRatio:   3,
+		Scanlines:       312,
+		VBlankScanlines: 20,
+		// 51 post-render scanlines come before VBlank, so that the NMI happens at the NTSC cycle
+		CpuCyclesPerFrame: 35464,
+	},
+}
+
+var names = map[Region]string{
+	NTSC:  "ntsc",
+	PAL:   "pal",
+	DENDY: "dendy",
+}
+
+func Parse(name string) (Region, error) {
+	for region, regionName := range names {
+		if regionName == name {
+			return region, nil
+		}
+	}
+	return NTSC, fmt.Errorf("unknown region %q (ntsc, pal or dendy)", name)
+}
+
+func (region Region) String() string {
+	return names[region]
+}
+
+func (region Region) Timing() Timing {
+	return timings[region]
+}
+
+func (region Region) FramesPerSecond() float64 {
+	var timing = region.Timing()
+	return timing.CpuFrequency / timing.CpuCyclesPerFrame
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"nes-emulator/bus"
+	"nes-emulator/cdl"
+	"nes-emulator/movie"
+	"nes-emulator/nes_console"
+	"nes-emulator/profiler"
+	"nes-emulator/region"
+	"nes-emulator/wav"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const ROM_PATH string = "resources/nestest.nes"
+const DEFAULT_SAMPLE_RATE int = 44100
+
+// Subroutines and instructions listed in the profile report
+const DEFAULT_PROFILE_TOP int = 20
+
+// Save states are stored next to the ROM, in numbered slots : nestest.ss0 ... nestest.ss9
+const NUMBER_OF_STATE_SLOTS int = 10
+const NO_STATE_SLOT int = -1
+
+func runRomCommand(arguments []string) (err error) {
+	var flags = flag.NewFlagSet("nes-emulator", flag.ExitOnError)
+	var wavPath = flags.String("wav", "", "write the emulated sound to this WAV file")
+	var sampleRate = flags.Int("sample-rate", DEFAULT_SAMPLE_RATE, "sample rate of the WAV file in Hz (44100 or 48000)")
+	var recordPath = flags.String("record", "", "record the input of every frame in this FM2 movie")
+	var playPath = flags.String("play", "", "replay the input of this FM2 movie")
+	var frames = flags.Int("frames", 0, "number of frames to run (defaults to the movie length, or until the program stops)")
+	var loadSlot = flags.Int("load-slot", NO_STATE_SLOT, "load the save state of this slot (0-9) before running")
+	var saveSlot = flags.Int("save-slot", NO_STATE_SLOT, "save the state in this slot (0-9) once done running")
+	var ramFillName = flags.String("ram-fill", bus.RAM_FILL_ZERO.String(), "content of the RAM at power-on (zero, ff, fceux or random)")
+	var regionName = flags.String("region", "auto", "timings of the console (ntsc, pal or dendy), defaults to the region of the ROM")
+	var ramSeed = flags.Int64("ram-seed", 0, "seed of the random RAM content, to reproduce a previous run (defaults to a new seed)")
+	var cdlPath = flags.String("cdl", "", "log the code and data accesses in this FCEUX .cdl file, adding to its content if it exists")
+	var profilePath = flags.String("profile", "", "profile the cycles spent per instruction and subroutine, print a report and write a pprof profile in this file")
+	var profileTop = flags.Int("profile-top", DEFAULT_PROFILE_TOP, "number of subroutines and instructions listed in the profile report")
+	flags.Parse(arguments)
+	for _, slot := range []int{*loadSlot, *saveSlot} {
+		if slot != NO_STATE_SLOT && (slot < 0 || slot >= NUMBER_OF_STATE_SLOTS) {
+			return fmt.Errorf("save state slot %d does not exist (0-%d)", slot, NUMBER_OF_STATE_SLOTS-1)
+		}
+	}
+
+	fmt.Println(fmt.Sprintf("Reading rom  file at path %s...", ROM_PATH))
+	var rawRom, errorRead = os.ReadFile(ROM_PATH)
+	if errorRead != nil {
+		return errorRead
+	}
+
+	fmt.Println("Parsing rom...")
+	var rom, errorParse = bus.ParseRawRom(rawRom)
+	if errorParse != nil {
+		return errorParse
+	}
+
+	var ramFill, errorRamFill = bus.ParseRamFill(*ramFillName)
+	if errorRamFill != nil {
+		return errorRamFill
+	}
+	var consoleRegion = rom.Region()
+	if *regionName != "auto" {
+		var errorRegion error
+		consoleRegion, errorRegion = region.Parse(*regionName)
+		if errorRegion != nil {
+			return errorRegion
+		}
+	}
+
+	if ramFill == bus.RAM_FILL_RANDOM {
+		if *ramSeed == 0 {
+			*ramSeed = time.Now().UnixNano()
+		}
+		fmt.Println(fmt.Sprintf("Filling RAM with random values, seed %d...", *ramSeed))
+	}
+
+	var console = nes_console.NewConsole()
+	console.SetTraceEnabled(true)
+	console.SetPowerOnConfig(nes_console.PowerOnConfig{RamFill: ramFill, Seed: *ramSeed})
+
+	if *wavPath != "" {
+		fmt.Println(fmt.Sprintf("Recording sound at %d Hz in WAV file %s...", *sampleRate, *wavPath))
+		var closeWav, errorWav = openWavOutput(&console, *wavPath, *sampleRate)
+		if errorWav != nil {
+			return errorWav
+		}
+		// The header is completed on close, a failure leaves a corrupt file
+		defer func() {
+			if errorClose := closeWav(); err == nil {
+				err = errorClose
+			}
+		}()
+	}
+
+	var isStateUsed = *loadSlot != NO_STATE_SLOT || *saveSlot != NO_STATE_SLOT
+	if *recordPath == "" && *playPath == "" && *frames == 0 && !isStateUsed && *regionName == "auto" && *cdlPath == "" && *profilePath == "" {
+		fmt.Println("Running rom in nes emulator...")
+		return console.RunRom(rom)
+	}
+
+	console.LoadRom(rom)
+	console.SetRegion(consoleRegion)
+	console.PowerOn()
+	if *playPath != "" {
+		fmt.Println(fmt.Sprintf("Playing movie %s...", *playPath))
+		var playedMovie, errorMovie = readMovie(*playPath)
+		if errorMovie != nil {
+			return errorMovie
+		}
+		if errorPlay := console.StartMoviePlayback(playedMovie); errorPlay != nil {
+			return errorPlay
+		}
+		if *frames == 0 {
+			*frames = len(playedMovie.Frames)
+		}
+	}
+	var recordedMovie *movie.Movie
+	if *recordPath != "" {
+		fmt.Println(fmt.Sprintf("Recording movie %s...", *recordPath))
+		recordedMovie = movie.NewMovie(filepath.Base(ROM_PATH), rom.Checksum())
+		if errorRecord := console.StartMovieRecording(recordedMovie); errorRecord != nil {
+			return errorRecord
+		}
+	}
+
+	var codeDataLog *cdl.Log
+	if *cdlPath != "" {
+		fmt.Println(fmt.Sprintf("Logging code and data in %s...", *cdlPath))
+		var errorLog error
+		codeDataLog, errorLog = readCodeDataLog(*cdlPath, rom)
+		if errorLog != nil {
+			return errorLog
+		}
+		if errorStart := console.StartCodeDataLogger(codeDataLog); errorStart != nil {
+			return errorStart
+		}
+	}
+
+	if *loadSlot != NO_STATE_SLOT {
+		fmt.Println(fmt.Sprintf("Loading state from slot %d...", *loadSlot))
+		if errorLoad := loadState(&console, *loadSlot); errorLoad != nil {
+			return errorLoad
+		}
+	}
+
+	var cycleProfiler *profiler.Profiler
+	if *profilePath != "" {
+		cycleProfiler = console.StartProfiler()
+	}
+
+	fmt.Println(fmt.Sprintf("Running rom in nes emulator with %s timings...", strings.ToUpper(console.Region().String())))
+	for frame := 0; *frames == 0 || frame < *frames; frame++ {
+		var _, errorFrame = console.StepFrame()
+		if errors.Is(errorFrame, nes_console.ErrProgramStopped) {
+			break
+		}
+		if errors.Is(errorFrame, nes_console.ErrCpuHalted) {
+			fmt.Println(fmt.Sprintf("CPU halted by KIL at $%04X", console.CPU().ProgramCounter()))
+			break
+		}
+		if errorFrame != nil {
+			return errorFrame
+		}
+	}
+
+	if codeDataLog != nil {
+		var code, data, unknown = codeDataLog.PrgCounts()
+		fmt.Println(fmt.Sprintf("PRG ROM : %d bytes of code, %d bytes of data, %d bytes never accessed", code, data, unknown))
+		if errorLog := writeCodeDataLog(*cdlPath, codeDataLog); errorLog != nil {
+			return errorLog
+		}
+	}
+	if cycleProfiler != nil {
+		if errorReport := cycleProfiler.WriteReport(os.Stdout, *profileTop); errorReport != nil {
+			return errorReport
+		}
+		fmt.Println(fmt.Sprintf("Writing pprof profile %s...", *profilePath))
+		if errorProfile := writeProfile(*profilePath, cycleProfiler); errorProfile != nil {
+			return errorProfile
+		}
+	}
+	if *saveSlot != NO_STATE_SLOT {
+		fmt.Println(fmt.Sprintf("Saving state in slot %d...", *saveSlot))
+		if errorSave := saveState(&console, *saveSlot); errorSave != nil {
+			return errorSave
+		}
+	}
+	if recordedMovie != nil {
+		return writeMovie(*recordPath, recordedMovie)
+	}
+	return nil
+}
+
+func stateSlotPath(slot int) string {
+	return fmt.Sprintf("%s.ss%d", strings.TrimSuffix(ROM_PATH, filepath.Ext(ROM_PATH)), slot)
+}
+
+func loadState(console *nes_console.NesConsole, slot int) error {
+	var stateFile, errorOpen = os.Open(stateSlotPath(slot))
+	if errorOpen != nil {
+		return errorOpen
+	}
+	defer stateFile.Close()
+	return console.LoadState(bufio.NewReader(stateFile))
+}
+
+func saveState(console *nes_console.NesConsole, slot int) error {
+	var stateFile, errorCreate = os.Create(stateSlotPath(slot))
+	if errorCreate != nil {
+		return errorCreate
+	}
+	var writer = bufio.NewWriter(stateFile)
+	var errorSave = console.SaveState(writer)
+	if errorSave == nil {
+		errorSave = writer.Flush()
+	}
+	if errorClose := stateFile.Close(); errorSave == nil {
+		errorSave = errorClose
+	}
+	return errorSave
+}
+
+// A missing file starts an empty log
+func readCodeDataLog(path string, rom *bus.Rom) (*cdl.Log, error) {
+	var logFile, errorOpen = os.Open(path)
+	if os.IsNotExist(errorOpen) {
+		return cdl.NewLog(len(rom.PrgRom()), len(rom.ChrRom())), nil
+	}
+	if errorOpen != nil {
+		return nil, errorOpen
+	}
+	defer logFile.Close()
+	return cdl.Read(bufio.NewReader(logFile), len(rom.PrgRom()), len(rom.ChrRom()))
+}
+
+// The CHR ROM part of the file is left as read, see cdl.Log.Write
+func writeCodeDataLog(path string, log *cdl.Log) error {
+	var logFile, errorCreate = os.Create(path)
+	if errorCreate != nil {
+		return errorCreate
+	}
+	if errorWrite := log.Write(logFile); errorWrite != nil {
+		logFile.Close()
+		return errorWrite
+	}
+	return logFile.Close()
+}
+
+func writeProfile(path string, cycleProfiler *profiler.Profiler) error {
+	var profileFile, errorCreate = os.Create(path)
+	if errorCreate != nil {
+		return errorCreate
+	}
+	if errorWrite := cycleProfiler.WritePprof(profileFile); errorWrite != nil {
+		profileFile.Close()
+		return errorWrite
+	}
+	return profileFile.Close()
+}
+
+func readMovie(path string) (*movie.Movie, error) {
+	var movieFile, errorOpen = os.Open(path)
+	if errorOpen != nil {
+		return nil, errorOpen
+	}
+	defer movieFile.Close()
+	return movie.ReadFM2(movieFile)
+}
+
+func writeMovie(path string, recordedMovie *movie.Movie) error {
+	var movieFile, errorCreate = os.Create(path)
+	if errorCreate != nil {
+		return errorCreate
+	}
+	if errorWrite := recordedMovie.WriteFM2(movieFile); errorWrite != nil {
+		movieFile.Close()
+		return errorWrite
+	}
+	return movieFile.Close()
+}
+
+// Plugs a WAV file as the audio output of the console, the returned function must be called once done
+func openWavOutput(console *nes_console.NesConsole, path string, sampleRate int) (func() error, error) {
+	var wavFile, errorCreate = os.Create(path)
+	if errorCreate != nil {
+		return nil, errorCreate
+	}
+	var wavWriter, errorWav = wav.NewWriter(wavFile, sampleRate)
+	if errorWav != nil {
+		wavFile.Close()
+		return nil, errorWav
+	}
+	console.SetAudioOutput(wavWriter, sampleRate)
+	return func() error {
+		var errorClose = wavWriter.Close()
+		if errorFile := wavFile.Close(); errorClose == nil {
+			errorClose = errorFile
+		}
+		return errorClose
+	}, nil
+}
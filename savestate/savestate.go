@@ -0,0 +1,128 @@
+package savestate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// File layout (little endian) :
+//
+//	"NESSTATE"              magic
+//	uint16                  format version
+//	[16]byte                MD5 of the ROM the state was saved from
+//	uint16                  number of sections
+//	then for each section :
+//	[4]byte                 tag of the component, e.g. "CPU "
+//	uint32                  size of the data
+//	data                    fields written by the SerializeState method of the component
+var MAGIC = [8]byte{'N', 'E', 'S', 'S', 'T', 'A', 'T', 'E'}
+
+// Incremented whenever the fields of a component change
+const FORMAT_VERSION uint16 = 4
+
+const TAG_SIZE int = 4
+
+var ErrRomMismatch = errors.New("save state was made with another ROM")
+
+// A component of the machine, identified by its tag in the file
+type Section struct {
+	Tag       string
+	Serialize func(state *Serializer)
+}
+
+type header struct {
+	Magic           [8]byte
+	Version         uint16
+	RomChecksum     [16]byte
+	NumberOfSection uint16
+}
+
+func Write(output io.Writer, romChecksum [16]byte, sections []Section) error {
+	var fileHeader = header{
+		Magic:           MAGIC,
+		Version:         FORMAT_VERSION,
+		RomChecksum:     romChecksum,
+		NumberOfSection: uint16(len(sections)),
+	}
+	if err := binary.Write(output, binary.LittleEndian, fileHeader); err != nil {
+		return err
+	}
+	for _, section := range sections {
+		var state = newSavingSerializer()
+		section.Serialize(state)
+		var tag [TAG_SIZE]byte
+		copy(tag[:], section.Tag)
+		if _, err := output.Write(tag[:]); err != nil {
+			return err
+		}
+		if err := binary.Write(output, binary.LittleEndian, uint32(state.buffer.Len())); err != nil {
+			return err
+		}
+		if _, err := output.Write(state.buffer.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Every section must be present in the file, unknown sections are ignored
+// If the state cannot be loaded, the components are left untouched
+func Read(input io.Reader, romChecksum [16]byte, sections []Section) error {
+	var fileHeader header
+	if err := binary.Read(input, binary.LittleEndian, &fileHeader); err != nil {
+		return fmt.Errorf("cannot read save state header: %w", err)
+	}
+	if fileHeader.Magic != MAGIC {
+		return errors.New("file is not a save state")
+	}
+	if fileHeader.Version != FORMAT_VERSION {
+		return fmt.Errorf("save state version %d is not supported (expected %d)", fileHeader.Version, FORMAT_VERSION)
+	}
+	if fileHeader.RomChecksum != romChecksum {
+		return ErrRomMismatch
+	}
+
+	var sectionsData = make(map[string][]byte)
+	for i := 0; i < int(fileHeader.NumberOfSection); i++ {
+		var tag [TAG_SIZE]byte
+		var size uint32
+		if _, err := io.ReadFull(input, tag[:]); err != nil {
+			return fmt.Errorf("cannot read save state section: %w", err)
+		}
+		if err := binary.Read(input, binary.LittleEndian, &size); err != nil {
+			return fmt.Errorf("cannot read save state section: %w", err)
+		}
+		var name = string(bytes.TrimRight(tag[:], "\x00"))
+		var data = make([]byte, size)
+		if _, err := io.ReadFull(input, data); err != nil {
+			return fmt.Errorf("cannot read save state section %q: %w", name, err)
+		}
+		sectionsData[name] = data
+	}
+	for _, section := range sections {
+		if _, isPresent := sectionsData[section.Tag]; !isPresent {
+			return fmt.Errorf("save state has no %q section", section.Tag)
+		}
+	}
+
+	// Current state is kept to roll back if a section turns out to be invalid
+	var backup bytes.Buffer
+	if err := Write(&backup, romChecksum, sections); err != nil {
+		return err
+	}
+	for _, section := range sections {
+		var state = newLoadingSerializer(sectionsData[section.Tag])
+		section.Serialize(state)
+		if state.err == nil && state.buffer.Len() != 0 {
+			state.Fail(errors.New("data is too long"))
+		}
+		if state.err != nil {
+			Read(&backup, romChecksum, sections)
+			return fmt.Errorf("cannot load save state section %q: %w", section.Tag, state.err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package savestate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+type testUnit struct {
+	register uint8
+	counter  uint64
+	isOn     bool
+	memory   [4]uint8
+}
+
+func (unit *testUnit) serializeState(state *Serializer) {
+	state.Uint8(&unit.register)
+	state.Uint64(&unit.counter)
+	state.Bool(&unit.isOn)
+	state.Bytes(unit.memory[:])
+}
+
+var romChecksum = [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+
+func testSections(first *testUnit, second *testUnit) []Section {
+	return []Section{
+		{Tag: "ONE", Serialize: first.serializeState},
+		{Tag: "TWO", Serialize: second.serializeState},
+	}
+}
+
+func saveTestUnits(t *testing.T) []byte {
+	var first = testUnit{register: 0x12, counter: 123456789, isOn: true, memory: [4]uint8{1, 2, 3, 4}}
+	var second = testUnit{register: 0x34, counter: 42, memory: [4]uint8{5, 6, 7, 8}}
+	var file bytes.Buffer
+	if err := Write(&file, romChecksum, testSections(&first, &second)); err != nil {
+		t.Fatal(err)
+	}
+	return file.Bytes()
+}
+
+// Offset of the first section, after the header
+const SECTIONS_OFFSET int = 8 + 2 + 16 + 2
+
+// Size of the data of a section of a testUnit
+const TEST_UNIT_SIZE int = 1 + 8 + 1 + 4
+
+func TestRoundTrip(t *testing.T) {
+	var file = saveTestUnits(t)
+	var first, second testUnit
+	if err := Read(bytes.NewReader(file), romChecksum, testSections(&first, &second)); err != nil {
+		t.Fatal(err)
+	}
+	if first != (testUnit{register: 0x12, counter: 123456789, isOn: true, memory: [4]uint8{1, 2, 3, 4}}) {
+		t.Errorf("first unit loaded as %+v", first)
+	}
+	if second != (testUnit{register: 0x34, counter: 42, memory: [4]uint8{5, 6, 7, 8}}) {
+		t.Errorf("second unit loaded as %+v", second)
+	}
+
+	// Sections are found by their tag, whatever their order, and unknown ones are ignored
+	var third testUnit
+	if err := Read(bytes.NewReader(file), romChecksum, []Section{{Tag: "TWO", Serialize: third.serializeState}}); err != nil || third != second {
+		t.Errorf("section TWO alone loaded as %+v, %v", third, err)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	var tests = []struct {
+		name    string
+		corrupt func(file []byte) []byte
+	}{
+		{"not a save state", func(file []byte) []byte {
+			file[0] = 'X'
+			return file
+		}},
+		{"older version", func(file []byte) []byte {
+			binary.LittleEndian.PutUint16(file[8:], FORMAT_VERSION-1)
+			return file
+		}},
+		{"newer version", func(file []byte) []byte {
+			binary.LittleEndian.PutUint16(file[8:], FORMAT_VERSION+1)
+			return file
+		}},
+		{"another ROM", func(file []byte) []byte {
+			file[10] ^= 0xFF
+			return file
+		}},
+		{"missing section", func(file []byte) []byte {
+			binary.LittleEndian.PutUint16(file[26:], 1)
+			return file
+		}},
+		{"truncated file", func(file []byte) []byte {
+			return file[:len(file)-1]
+		}},
+		// Second section one byte shorter : the first one is loaded before the error is found
+		{"truncated section", func(file []byte) []byte {
+			var sizeOffset = SECTIONS_OFFSET + 2*(TAG_SIZE+4) + TEST_UNIT_SIZE - 4
+			binary.LittleEndian.PutUint32(file[sizeOffset:], uint32(TEST_UNIT_SIZE-1))
+			return file[:len(file)-1]
+		}},
+		{"section too long", func(file []byte) []byte {
+			var sizeOffset = SECTIONS_OFFSET + 2*(TAG_SIZE+4) + TEST_UNIT_SIZE - 4
+			binary.LittleEndian.PutUint32(file[sizeOffset:], uint32(TEST_UNIT_SIZE+1))
+			return append(file, 0)
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var file = test.corrupt(saveTestUnits(t))
+			var first = testUnit{register: 0xAA, counter: 1}
+			var second = testUnit{register: 0xBB, counter: 2}
+			var err = Read(bytes.NewReader(file), romChecksum, testSections(&first, &second))
+			if err == nil {
+				t.Fatal("state loaded without error")
+			}
+			if test.name == "another ROM" && !errors.Is(err, ErrRomMismatch) {
+				t.Errorf("error %v, want ErrRomMismatch", err)
+			}
+			// Components are rolled back to their state before the loading
+			if first != (testUnit{register: 0xAA, counter: 1}) || second != (testUnit{register: 0xBB, counter: 2}) {
+				t.Errorf("units changed to %+v and %+v by a failed loading", first, second)
+			}
+		})
+	}
+}
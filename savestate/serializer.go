@@ -0,0 +1,117 @@
+package savestate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var errTruncated = errors.New("data is truncated")
+
+// Saves or loads the state of a component : a single method describes the fields of the component in both directions
+//
+//	func (unit *Unit) SerializeState(state *savestate.Serializer) {
+//		state.Uint8(&unit.register)
+//		state.Bool(&unit.isEnabled)
+//	}
+type Serializer struct {
+	isLoading bool
+	buffer    *bytes.Buffer
+	err       error
+}
+
+func newSavingSerializer() *Serializer {
+	return &Serializer{buffer: &bytes.Buffer{}}
+}
+
+func newLoadingSerializer(data []byte) *Serializer {
+	return &Serializer{isLoading: true, buffer: bytes.NewBuffer(data)}
+}
+
+func (state *Serializer) IsLoading() bool {
+	return state.isLoading
+}
+
+// Aborts the loading of the state, the first error is kept
+func (state *Serializer) Fail(err error) {
+	if state.err == nil {
+		state.err = err
+	}
+}
+
+func (state *Serializer) Err() error {
+	return state.err
+}
+
+// Fields
+
+func (state *Serializer) Bytes(values []uint8) {
+	if state.err != nil {
+		return
+	}
+	if !state.isLoading {
+		state.buffer.Write(values)
+		return
+	}
+	if state.buffer.Len() < len(values) {
+		state.Fail(errTruncated)
+		return
+	}
+	copy(values, state.buffer.Next(len(values)))
+}
+
+func (state *Serializer) Uint8(value *uint8) {
+	var bytes = []uint8{*value}
+	state.Bytes(bytes)
+	*value = bytes[0]
+}
+
+func (state *Serializer) Bool(value *bool) {
+	var data uint8
+	if *value {
+		data = 1
+	}
+	state.Uint8(&data)
+	*value = data != 0
+}
+
+func (state *Serializer) Uint16(value *uint16) {
+	var bytes = make([]uint8, 2)
+	binary.LittleEndian.PutUint16(bytes, *value)
+	state.Bytes(bytes)
+	*value = binary.LittleEndian.Uint16(bytes)
+}
+
+func (state *Serializer) Uint32(value *uint32) {
+	var bytes = make([]uint8, 4)
+	binary.LittleEndian.PutUint32(bytes, *value)
+	state.Bytes(bytes)
+	*value = binary.LittleEndian.Uint32(bytes)
+}
+
+func (state *Serializer) Uint64(value *uint64) {
+	var bytes = make([]uint8, 8)
+	binary.LittleEndian.PutUint64(bytes, *value)
+	state.Bytes(bytes)
+	*value = binary.LittleEndian.Uint64(bytes)
+}
+
+// Ints are stored on 64 bits, whatever the platform
+func (state *Serializer) Int(value *int) {
+	var data = uint64(int64(*value))
+	state.Uint64(&data)
+	*value = int(int64(data))
+}
+
+func (state *Serializer) Float32(value *float32) {
+	var data = math.Float32bits(*value)
+	state.Uint32(&data)
+	*value = math.Float32frombits(data)
+}
+
+func (state *Serializer) Float64(value *float64) {
+	var data = math.Float64bits(*value)
+	state.Uint64(&data)
+	*value = math.Float64frombits(data)
+}
@@ -0,0 +1,50 @@
+package singlestep
+
+// 64 KiB of RAM with nothing mapped on it, recording every access of the CPU
+type FlatBus struct {
+	memory   [0x10000]uint8
+	accesses []Access
+}
+
+type Access struct {
+	Address uint16
+	Data    uint8
+	IsWrite bool
+}
+
+func (bus *FlatBus) MemoryRead(address uint16) uint8 {
+	var data = bus.memory[address]
+	bus.accesses = append(bus.accesses, Access{Address: address, Data: data})
+	return data
+}
+
+func (bus *FlatBus) MemoryWrite(address uint16, data uint8) {
+	bus.memory[address] = data
+	bus.accesses = append(bus.accesses, Access{Address: address, Data: data, IsWrite: true})
+}
+
+func (bus *FlatBus) Tick(cycles int) {}
+
+func (bus *FlatBus) TakeDmaStallCycles() int {
+	return 0
+}
+
+func (bus *FlatBus) IsIRQPending() bool {
+	return false
+}
+
+// Sets memory without recording the access
+func (bus *FlatBus) Poke(address uint16, data uint8) {
+	bus.memory[address] = data
+}
+
+func (bus *FlatBus) Peek(address uint16) uint8 {
+	return bus.memory[address]
+}
+
+// Returns the accesses recorded since the last call
+func (bus *FlatBus) TakeAccesses() []Access {
+	var accesses = bus.accesses
+	bus.accesses = nil
+	return accesses
+}
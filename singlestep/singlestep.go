@@ -0,0 +1,165 @@
+package singlestep
+
+import (
+	"encoding/json"
+	"fmt"
+	"nes-emulator/cpu"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Runs the per-instruction tests of https://github.com/SingleStepTests/65x02 (nes6502 directory)
+// Each file, named after the opcode (a9.json), holds thousands of tests of a single instruction
+// starting from random registers and memory
+
+type CpuState struct {
+	ProgramCounter uint16 `json:"pc"`
+	StackPointer   uint8  `json:"s"`
+	A              uint8  `json:"a"`
+	X              uint8  `json:"x"`
+	Y              uint8  `json:"y"`
+	Status         uint8  `json:"p"`
+	// Pairs of address and value
+	Ram [][2]uint16 `json:"ram"`
+}
+
+type Test struct {
+	Name    string   `json:"name"`
+	Initial CpuState `json:"initial"`
+	Final   CpuState `json:"final"`
+	// Address, value and "read" or "write" for each cycle
+	Cycles [][3]interface{} `json:"cycles"`
+}
+
+// Outcome of the tests of an opcode, with the number of tests passed for each check : the final state, the cycle
+// count, and the bus activity, where each access, dummy reads and writes included, must match the address,
+// the value and the direction of its cycle
+type Result struct {
+	OpCode       uint8
+	Tests        int
+	StatePassed  int
+	CyclesPassed int
+	BusPassed    int
+	// Description of the first test whose final state is wrong
+	FirstFailure string
+}
+
+func (result Result) HasPassed() bool {
+	return result.StatePassed == result.Tests && result.CyclesPassed == result.Tests && result.BusPassed == result.Tests
+}
+
+func LoadTests(path string) ([]Test, error) {
+	var content, err = os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tests []Test
+	if err := json.Unmarshal(content, &tests); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return tests, nil
+}
+
+// Runs the tests of the given opcodes found in directory, opcodes without a test file are skipped
+// The options configure the CPU, to test another variant than the 2A03 for instance
+func RunDirectory(directory string, opCodes []uint8, options ...cpu.Option) ([]Result, error) {
+	var results []Result
+	for _, opCode := range opCodes {
+		var path = filepath.Join(directory, fmt.Sprintf("%02x.json", opCode))
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		var tests, err = LoadTests(path)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, RunTests(opCode, tests, options...))
+	}
+	return results, nil
+}
+
+func RunTests(opCode uint8, tests []Test, options ...cpu.Option) Result {
+	var result = Result{OpCode: opCode, Tests: len(tests)}
+	for _, test := range tests {
+		var stateError, cycles, accesses = runTest(test, options)
+		if stateError == "" {
+			result.StatePassed++
+		} else if result.FirstFailure == "" {
+			result.FirstFailure = fmt.Sprintf("%s: %s", test.Name, stateError)
+		}
+		if cycles == len(test.Cycles) {
+			result.CyclesPassed++
+		}
+		if isSameBusActivity(accesses, test.Cycles) {
+			result.BusPassed++
+		}
+	}
+	return result
+}
+
+// Returns what differs from the expected final state, the cycles taken and the bus accesses
+func runTest(test Test, options []cpu.Option) (stateError string, cycles int, accesses []Access) {
+	var flatBus = &FlatBus{}
+	var testCPU = cpu.NewCPU(flatBus, options...)
+	testCPU.SetTraceEnabled(false)
+	testCPU.SetRegisters(test.Initial.registers())
+	for _, cell := range test.Initial.Ram {
+		flatBus.Poke(cell[0], uint8(cell[1]))
+	}
+
+	var startCycles = testCPU.Cycles()
+	defer func() {
+		// Unsupported opcodes panic
+		if recovered := recover(); recovered != nil {
+			stateError = fmt.Sprint(recovered)
+			accesses = flatBus.TakeAccesses()
+		}
+	}()
+	testCPU.Step()
+	cycles = int(testCPU.Cycles() - startCycles)
+	accesses = flatBus.TakeAccesses()
+
+	var differences []string
+	var registers = testCPU.Registers()
+	var expected = test.Final.registers()
+	if registers != expected {
+		differences = append(differences, fmt.Sprintf("registers %+v, expected %+v", registers, expected))
+	}
+	for _, cell := range test.Final.Ram {
+		if data := flatBus.Peek(cell[0]); data != uint8(cell[1]) {
+			differences = append(differences, fmt.Sprintf("$%04X = $%02X, expected $%02X", cell[0], data, cell[1]))
+		}
+	}
+	return strings.Join(differences, ", "), cycles, accesses
+}
+
+func (state CpuState) registers() cpu.Registers {
+	return cpu.Registers{
+		A:              state.A,
+		X:              state.X,
+		Y:              state.Y,
+		StackPointer:   state.StackPointer,
+		Status:         state.Status,
+		ProgramCounter: state.ProgramCounter,
+	}
+}
+
+func isSameBusActivity(accesses []Access, cycles [][3]interface{}) bool {
+	if len(accesses) != len(cycles) {
+		return false
+	}
+	for i, cycle := range cycles {
+		var address, isAddress = cycle[0].(float64)
+		var data, isData = cycle[1].(float64)
+		var kind, isKind = cycle[2].(string)
+		if !isAddress || !isData || !isKind {
+			return false
+		}
+		var access = accesses[i]
+		if access.Address != uint16(address) || access.Data != uint8(data) || access.IsWrite != (kind == "write") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package singlestep
+
+import (
+	"encoding/json"
+	"fmt"
+	"nes-emulator/cpu"
+	"os"
+	"testing"
+)
+
+// Where the nes6502 vectors are looked for, overridden by the SINGLE_STEP_TESTS environment variable
+const TESTS_DIRECTORY string = "../resources/singlestep"
+
+// Unstable opcodes, whose results depend on the chip, see cpu/options.go
+var unstableOpCodes = map[uint8]bool{0x8B: true, 0xAB: true, 0x93: true, 0x9B: true, 0x9C: true, 0x9E: true, 0x9F: true}
+
+// LDA ($10),Y crossing a page, in the format of the vectors
+const LDA_INDIRECT_Y_TEST = `[{
+	"name": "b1 page crossed",
+	"initial": {"pc": 512, "s": 253, "a": 0, "x": 0, "y": 255, "p": 36,
+		"ram": [[512, 177], [513, 16], [16, 1], [17, 3], [1024, 128]]},
+	"final": {"pc": 514, "s": 253, "a": 128, "x": 0, "y": 255, "p": 164,
+		"ram": [[512, 177], [513, 16], [16, 1], [17, 3], [1024, 128]]},
+	"cycles": [[512, 177, "read"], [513, 16, "read"], [16, 1, "read"], [17, 3, "read"], [768, 0, "read"], [1024, 128, "read"]]
+}]`
+
+func TestRunTests(t *testing.T) {
+	var tests []Test
+	if err := json.Unmarshal([]byte(LDA_INDIRECT_Y_TEST), &tests); err != nil {
+		t.Fatal(err)
+	}
+	var result = RunTests(0xB1, tests)
+	if !result.HasPassed() {
+		t.Errorf("%+v", result)
+	}
+
+	// A wrong expectation is reported
+	tests[0].Final.A = 0x7F
+	result = RunTests(0xB1, tests)
+	if result.StatePassed != 0 || result.FirstFailure == "" || result.CyclesPassed != 1 || result.BusPassed != 1 {
+		t.Errorf("wrong final state not reported : %+v", result)
+	}
+}
+
+// Runs the vectors of every opcode, when they are copied in TESTS_DIRECTORY
+func TestSingleStepVectors(t *testing.T) {
+	var directory = os.Getenv("SINGLE_STEP_TESTS")
+	if directory == "" {
+		directory = TESTS_DIRECTORY
+	}
+	if _, err := os.Stat(directory); os.IsNotExist(err) {
+		t.Skipf("no SingleStepTests vectors in %s", directory)
+	}
+	for opCode := 0; opCode <= 0xFF; opCode++ {
+		var decoded, isKnown = cpu.DecodeOpCode(uint8(opCode))
+		if !isKnown || decoded.Mnemonic() == "KIL" || unstableOpCodes[uint8(opCode)] {
+			continue
+		}
+		t.Run(fmt.Sprintf("%02x", opCode), func(t *testing.T) {
+			var results, err = RunDirectory(directory, []uint8{uint8(opCode)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) == 0 {
+				t.Skip("no test file")
+			}
+			var result = results[0]
+			if !result.HasPassed() {
+				t.Errorf("state %d/%d, cycles %d/%d, bus %d/%d, first failure %s", result.StatePassed, result.Tests,
+					result.CyclesPassed, result.Tests, result.BusPassed, result.Tests, result.FirstFailure)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"nes-emulator/cpu"
+	"nes-emulator/singlestep"
+	"strconv"
+	"strings"
+)
+
+// Where the nes6502 tests of https://github.com/SingleStepTests/65x02 are looked for by default
+const SINGLE_STEP_TESTS_PATH string = "resources/singlestep"
+
+func runSingleStepCommand(arguments []string) error {
+	var flags = flag.NewFlagSet("singlestep", flag.ExitOnError)
+	var directory = flags.String("dir", SINGLE_STEP_TESTS_PATH, "directory of the JSON test files (00.json to ff.json)")
+	var opCodesFlag = flags.String("opcodes", "", "comma separated hex opcodes to test, all of them if empty")
+	var isVerbose = flags.Bool("v", false, "print the first failing test of each opcode")
+	var variantName = flags.String("variant", cpu.RICOH_2A03.String(), "CPU tested : 2A03 (nes6502 tests), 6502 or 65C02")
+	flags.Parse(arguments)
+
+	var variant, errorVariant = cpu.ParseVariant(*variantName)
+	if errorVariant != nil {
+		return errorVariant
+	}
+
+	var opCodes []uint8
+	if *opCodesFlag == "" {
+		for opCode := 0; opCode <= 0xFF; opCode++ {
+			opCodes = append(opCodes, uint8(opCode))
+		}
+	} else {
+		for _, text := range strings.Split(*opCodesFlag, ",") {
+			var opCode, errorParse = strconv.ParseUint(strings.TrimSpace(text), 16, 8)
+			if errorParse != nil {
+				return fmt.Errorf("invalid opcode %q", text)
+			}
+			opCodes = append(opCodes, uint8(opCode))
+		}
+	}
+
+	var results, errorRun = singlestep.RunDirectory(*directory, opCodes, cpu.WithVariant(variant))
+	if errorRun != nil {
+		return errorRun
+	}
+	if len(results) == 0 {
+		return fmt.Errorf("no test file found in %s", *directory)
+	}
+	var total singlestep.Result
+	var opCodesPassed = 0
+	fmt.Println("opcode          state           cycles          bus")
+	for _, result := range results {
+		var operation = "???"
+		if opCode, isKnown := cpu.DecodeVariantOpCode(variant, result.OpCode); isKnown {
+			operation = string(opCode.Operation())
+		}
+		fmt.Println(fmt.Sprintf("%02X %-4s  %15s %15s %15s", result.OpCode, operation,
+			formatPassed(result.StatePassed, result.Tests), formatPassed(result.CyclesPassed, result.Tests), formatPassed(result.BusPassed, result.Tests)))
+		if *isVerbose && result.FirstFailure != "" {
+			fmt.Println("    " + result.FirstFailure)
+		}
+		if result.HasPassed() {
+			opCodesPassed++
+		}
+		total.Tests += result.Tests
+		total.StatePassed += result.StatePassed
+		total.CyclesPassed += result.CyclesPassed
+		total.BusPassed += result.BusPassed
+	}
+	fmt.Println(fmt.Sprintf("total     %15s %15s %15s", formatPassed(total.StatePassed, total.Tests),
+		formatPassed(total.CyclesPassed, total.Tests), formatPassed(total.BusPassed, total.Tests)))
+	fmt.Println(fmt.Sprintf("%d/%d opcodes fully passed", opCodesPassed, len(results)))
+	return nil
+}
+
+func formatPassed(passed int, tests int) string {
+	return fmt.Sprintf("%d/%d", passed, tests)
+}
@@ -0,0 +1,82 @@
+package wav
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// Writes mono 16-bit PCM WAV files
+// More info on the format here : http://soundfile.sapp.org/doc/WaveFormat/
+
+const HEADER_SIZE int64 = 44
+const BITS_PER_SAMPLE uint16 = 16
+const NUMBER_OF_CHANNELS uint16 = 1
+
+type Writer struct {
+	output     io.WriteSeeker
+	sampleRate int
+	// Number of bytes of samples written so far
+	dataSize uint32
+	buffer   []byte
+}
+
+func NewWriter(output io.WriteSeeker, sampleRate int) (*Writer, error) {
+	if sampleRate <= 0 {
+		return nil, errors.New("sample rate must be positive")
+	}
+	var writer = &Writer{
+		output:     output,
+		sampleRate: sampleRate,
+	}
+	// Sizes are unknown yet, the header is written again when closing
+	if err := writer.writeHeader(); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (writer *Writer) writeHeader() error {
+	var blockAlign = NUMBER_OF_CHANNELS * BITS_PER_SAMPLE / 8
+	var header = make([]byte, HEADER_SIZE)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], 36+writer.dataSize)
+	copy(header[8:12], "WAVE")
+	copy(header[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:20], 16) // Size of the fmt chunk
+	binary.LittleEndian.PutUint16(header[20:22], 1)  // PCM
+	binary.LittleEndian.PutUint16(header[22:24], NUMBER_OF_CHANNELS)
+	binary.LittleEndian.PutUint32(header[24:28], uint32(writer.sampleRate))
+	binary.LittleEndian.PutUint32(header[28:32], uint32(writer.sampleRate)*uint32(blockAlign))
+	binary.LittleEndian.PutUint16(header[32:34], blockAlign)
+	binary.LittleEndian.PutUint16(header[34:36], BITS_PER_SAMPLE)
+	copy(header[36:40], "data")
+	binary.LittleEndian.PutUint32(header[40:44], writer.dataSize)
+	var _, err = writer.output.Write(header)
+	return err
+}
+
+// Samples are expected in range [-1.0, 1.0] and are clamped otherwise
+func (writer *Writer) WriteSamples(samples []float32) error {
+	writer.buffer = writer.buffer[:0]
+	for _, sample := range samples {
+		var value = math.Max(-1, math.Min(1, float64(sample)))
+		writer.buffer = binary.LittleEndian.AppendUint16(writer.buffer, uint16(int16(value*math.MaxInt16)))
+	}
+	var _, err = writer.output.Write(writer.buffer)
+	writer.dataSize += uint32(len(writer.buffer))
+	return err
+}
+
+// Rewrites the header with the final sizes, the underlying output is not closed
+func (writer *Writer) Close() error {
+	if _, err := writer.output.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := writer.writeHeader(); err != nil {
+		return err
+	}
+	var _, err = writer.output.Seek(0, io.SeekEnd)
+	return err
+}
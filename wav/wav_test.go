@@ -0,0 +1,72 @@
+package wav
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	var file, err = os.Create(filepath.Join(t.TempDir(), "sound.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer, err := NewWriter(file, 48000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Samples out of range are clamped
+	if err := writer.WriteSamples([]float32{0, 0.5, -0.5, 1, -1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.WriteSamples([]float32{2, -2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wantSamples = []int16{0, 16383, -16383, 32767, -32767, 32767, -32767}
+	var dataSize = uint32(2 * len(wantSamples))
+	if len(content) != int(HEADER_SIZE)+int(dataSize) {
+		t.Fatalf("file is %d bytes long, want %d", len(content), int(HEADER_SIZE)+int(dataSize))
+	}
+	if string(content[0:4]) != "RIFF" || string(content[8:16]) != "WAVEfmt " || string(content[36:40]) != "data" {
+		t.Errorf("chunk identifiers in header % X", content[:HEADER_SIZE])
+	}
+	if size := binary.LittleEndian.Uint32(content[4:8]); size != 36+dataSize {
+		t.Errorf("RIFF chunk size %d, want %d", size, 36+dataSize)
+	}
+	if size := binary.LittleEndian.Uint32(content[40:44]); size != dataSize {
+		t.Errorf("data chunk size %d, want %d", size, dataSize)
+	}
+	if sampleRate := binary.LittleEndian.Uint32(content[24:28]); sampleRate != 48000 {
+		t.Errorf("sample rate %d, want 48000", sampleRate)
+	}
+	if byteRate := binary.LittleEndian.Uint32(content[28:32]); byteRate != 96000 {
+		t.Errorf("byte rate %d, want 96000", byteRate)
+	}
+	for i, want := range wantSamples {
+		var offset = int(HEADER_SIZE) + 2*i
+		if sample := int16(binary.LittleEndian.Uint16(content[offset:])); sample != want {
+			t.Errorf("sample %d is %d, want %d", i, sample, want)
+		}
+	}
+}
+
+func TestNewWriterRejectsSampleRate(t *testing.T) {
+	var file, err = os.Create(filepath.Join(t.TempDir(), "sound.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := NewWriter(file, 0); err == nil {
+		t.Errorf("NewWriter() accepted a sample rate of 0")
+	}
+}